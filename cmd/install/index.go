@@ -0,0 +1,147 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexStampFile is the file in the pkg directory whose modification time is updated when packages are installed.
+// PackageIndex reloads installed packages when the modification time changes.
+const indexStampFile = ".index-stamp"
+
+// PackageIndex finds std packages and packages installed in LGOPATH by their names.
+type PackageIndex struct {
+	lgopath string
+
+	stdOnce sync.Once
+	// std maps names of std packages to their paths.
+	std map[string][]string
+
+	installedMu sync.Mutex
+	// installedPaths caches installed(). installedStamp is the modification time of indexStampFile when it was loaded.
+	installedPaths  []string
+	installedStamp  time.Time
+	installedLoaded bool
+}
+
+// NewPackageIndex returns a new PackageIndex of packages installed in lgopath.
+func NewPackageIndex(lgopath string) *PackageIndex {
+	return &PackageIndex{lgopath: lgopath}
+}
+
+// Lookup returns paths of std packages named name and paths of packages installed in LGOPATH that may be named name.
+// Names of packages installed in LGOPATH are guessed from their paths (See guessPackageName).
+// Internal packages and vendored packages are excluded.
+func (idx *PackageIndex) Lookup(name string) []string {
+	idx.stdOnce.Do(idx.loadStd)
+	paths := append([]string(nil), idx.std[name]...)
+	for _, path := range idx.installedPackages() {
+		if guessPackageName(path) == name {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (idx *PackageIndex) loadStd() {
+	idx.std = make(map[string][]string)
+	out, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", "std").Output()
+	if err != nil {
+		return
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 || isInternalPkg(fields[0]) {
+			continue
+		}
+		idx.std[fields[1]] = append(idx.std[fields[1]], fields[0])
+	}
+}
+
+// InvalidatePackageIndex makes PackageIndex of lgopath reload installed packages in the next Lookup.
+// This also works for PackageIndex in other processes (e.g. running kernels).
+func InvalidatePackageIndex(lgopath string) error {
+	return touchIndexStamp(pkgDir(lgopath))
+}
+
+func touchIndexStamp(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, indexStampFile), nil, 0644)
+}
+
+// installedPackages returns the cache of installed(). The cache is reloaded if it's invalidated by InvalidatePackageIndex.
+func (idx *PackageIndex) installedPackages() []string {
+	var stamp time.Time
+	if info, err := os.Stat(filepath.Join(pkgDir(idx.lgopath), indexStampFile)); err == nil {
+		stamp = info.ModTime()
+	}
+	idx.installedMu.Lock()
+	defer idx.installedMu.Unlock()
+	if !idx.installedLoaded || !stamp.Equal(idx.installedStamp) {
+		idx.installedPaths = idx.installed()
+		idx.installedStamp = stamp
+		idx.installedLoaded = true
+	}
+	return idx.installedPaths
+}
+
+// installed returns paths of non-std packages installed in LGOPATH.
+func (idx *PackageIndex) installed() []string {
+	dir := pkgDir(idx.lgopath)
+	var paths []string
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".a") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, strings.TrimSuffix(file, ".a"))
+		if err != nil {
+			return nil
+		}
+		path := filepath.ToSlash(rel)
+		if IsStdPkg(path) || isInternalPkg(path) || !IsSOInstalled(idx.lgopath, path) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	return paths
+}
+
+// isInternalPkg returns true if path can not be imported from lgo code because it's internal or vendored.
+func isInternalPkg(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" || elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+var majorVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+// guessPackageName guesses the name of the package of path in the same way as goimports.
+// For example, the name of "gopkg.in/yaml.v2" is yaml and the name of "github.com/mattn/go-runewidth" is runewidth.
+func guessPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersionPattern.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	return strings.Replace(name, "-", "_", -1)
+}
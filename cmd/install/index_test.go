@@ -0,0 +1,74 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGuessPackageName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"strings", "strings"},
+		{"github.com/yunabe/lgo/core", "core"},
+		{"gopkg.in/yaml.v2", "yaml"},
+		{"github.com/mattn/go-runewidth", "runewidth"},
+		{"github.com/go-yaml/yaml/v3", "yaml"},
+		{"github.com/foo/bar-go", "bar"},
+		{"github.com/foo/my-pkg", "my_pkg"},
+	}
+	for _, tc := range tests {
+		if got := guessPackageName(tc.path); got != tc.want {
+			t.Errorf("guessPackageName(%q) = %q; want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPackageIndex(t *testing.T) {
+	lgopath, err := ioutil.TempDir("", "lgo-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	for _, pkg := range []string{"github.com/mattn/go-runewidth", "github.com/foo/internal/runewidth", "github.com/bar/runewidth"} {
+		file := filepath.Join(pkgDir(lgopath), pkg+".a")
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if pkg == "github.com/bar/runewidth" {
+			// .so file is not installed.
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgDir(lgopath), soFileName(pkg)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := NewPackageIndex(lgopath)
+	if got, want := idx.Lookup("runewidth"), []string{"github.com/mattn/go-runewidth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup(\"runewidth\") = %v; want %v", got, want)
+	}
+	if got, want := idx.Lookup("strings"), []string{"strings"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup(\"strings\") = %v; want %v", got, want)
+	}
+
+	// Installed packages are cached until the index is invalidated.
+	if err := ioutil.WriteFile(filepath.Join(pkgDir(lgopath), soFileName("github.com/bar/runewidth")), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idx.Lookup("runewidth"), []string{"github.com/mattn/go-runewidth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup(\"runewidth\") = %v; want %v", got, want)
+	}
+	if err := InvalidatePackageIndex(lgopath); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idx.Lookup("runewidth"), []string{"github.com/bar/runewidth", "github.com/mattn/go-runewidth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup(\"runewidth\") = %v; want %v", got, want)
+	}
+}
@@ -48,6 +48,9 @@ func IsSOInstalled(lgopath string, pkg string) bool {
 }
 
 type SOInstaller struct {
+	// Env is the environment of go commands run by the installer. The environment of the process is used if it's nil.
+	Env []string
+
 	cache  map[string]*packageInfo
 	pkgDir string
 }
@@ -94,6 +97,10 @@ func (si *SOInstaller) Install(patterns ...string) error {
 			ok = false
 		}
 	}
+	// Some packages may be installed even if others fail.
+	if err := touchIndexStamp(si.pkgDir); err != nil {
+		return fmt.Errorf("failed to update the package index: %v", err)
+	}
 	if !ok {
 		return errors.New("failed to install .so files")
 	}
@@ -117,6 +124,7 @@ func (si *SOInstaller) getPackage(path string) (*packageInfo, error) {
 
 func (si *SOInstaller) getPackageList(args ...string) (infos []*packageInfo, err error) {
 	cmd := exec.Command("go", append([]string{"list", "-json"}, args...)...)
+	cmd.Env = si.Env
 	cmd.Stderr = os.Stderr
 	// We do not need to close r (https://golang.org/pkg/os/exec/#Cmd.StdoutPipe).
 	r, err := cmd.StdoutPipe()
@@ -207,6 +215,7 @@ func (w *walker) walk(path string) bool {
 		return true
 	}
 	cmd := exec.Command("go", "install", "-buildmode=shared", "-linkshared", "-pkgdir", w.si.pkgDir, path)
+	cmd.Env = w.si.Env
 	cmd.Stdout = w.stdout
 	cmd.Stderr = w.stderr
 	if err := cmd.Run(); err != nil {
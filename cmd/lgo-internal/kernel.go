@@ -10,6 +10,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -23,6 +24,13 @@ import (
 type handlers struct {
 	runner    *runner.LgoRunner
 	execCount int
+	comms     *core.CommManager
+	inspector *core.VarInspector
+
+	// cellRunning is true while lgo code of execute_request is running with os.Stdout and os.Stderr
+	// redirected to the frontend. It is protected by cellMu, which is also locked while comm handlers run.
+	cellRunning bool
+	cellMu      sync.Mutex
 }
 
 func (*handlers) HandleKernelInfo() scaffold.KernelInfo {
@@ -71,6 +79,32 @@ func pipeOutput(send func(string), file **os.File, done chan<- struct{}) (close
 	return close, nil
 }
 
+// captureOutputs redirects os.Stdout and os.Stderr to stream. The returned function restores them and
+// waits until all outputs are sent.
+func captureOutputs(stream func(name, text string)) (restore func(), err error) {
+	done := make(chan struct{})
+	soClose, err := pipeOutput(func(msg string) {
+		stream("stdout", msg)
+	}, &os.Stdout, done)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout pipe: %v", err)
+	}
+	seClose, err := pipeOutput(func(msg string) {
+		stream("stderr", msg)
+	}, &os.Stderr, done)
+	if err != nil {
+		soClose()
+		<-done
+		return nil, fmt.Errorf("failed to open stderr pipe: %v", err)
+	}
+	return func() {
+		soClose()
+		seClose()
+		<-done
+		<-done
+	}, nil
+}
+
 type jupyterDisplayer func(data *scaffold.DisplayData, update bool)
 
 func init() {
@@ -127,53 +161,63 @@ func (d jupyterDisplayer) GIF(b []byte, id *string)      { d.displayBytes("image
 func (d jupyterDisplayer) PDF(b []byte, id *string)      { d.displayBytes("application/pdf", b, id) }
 func (d jupyterDisplayer) Text(s string, id *string)     { d.displayString("text/plain", s, id) }
 
-func (h *handlers) HandleExecuteRequest(ctx context.Context, r *scaffold.ExecuteRequest, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool)) *scaffold.ExecuteResult {
-	h.execCount++
-	rDone := make(chan struct{})
-	soClose, err := pipeOutput(func(msg string) {
-		stream("stdout", msg)
-	}, &os.Stdout, rDone)
-	if err != nil {
-		glog.Errorf("Failed to open stdout pipe: %v", err)
-		return &scaffold.ExecuteResult{
-			Status:         "error",
-			ExecutionCount: h.execCount,
-		}
+func (d jupyterDisplayer) MIME(data, metadata map[string]interface{}, id *string) {
+	d.display(&scaffold.DisplayData{
+		Data:     data,
+		Metadata: metadata,
+	}, id)
+}
+
+// jupyterInput reads input from users with input_request.
+type jupyterInput func(ctx context.Context, prompt string, password bool) (string, error)
+
+func (in jupyterInput) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	s, err := in(ctx, prompt, password)
+	if err == scaffold.ErrStdinNotAllowed {
+		// Reading input fails immediately like reading a closed stdin (See core.InputReader).
+		return "", io.EOF
 	}
-	seClose, err := pipeOutput(func(msg string) {
-		stream("stderr", msg)
-	}, &os.Stderr, rDone)
+	return s, err
+}
+
+func (h *handlers) HandleExecuteRequest(ctx context.Context, r *scaffold.ExecuteRequest, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool), readInput func(ctx context.Context, prompt string, password bool) (string, error)) *scaffold.ExecuteResult {
+	h.execCount++
+	finish, err := h.startCell(stream)
 	if err != nil {
-		glog.Errorf("Failed to open stderr pipe: %v", err)
+		glog.Error(err)
 		return &scaffold.ExecuteResult{
 			Status:         "error",
 			ExecutionCount: h.execCount,
+			Ename:          runner.UnknownErrorName,
+			Evalue:         err.Error(),
+			Traceback:      []string{err.Error()},
 		}
 	}
 	lgoCtx := core.LgoContext{
-		Context: ctx, Display: jupyterDisplayer(displayData),
+		Context: ctx, Display: jupyterDisplayer(displayData), Comms: h.comms, Input: jupyterInput(readInput),
 	}
 	func() {
 		defer func() {
 			p := recover()
 			if p != nil {
-				// The return value of debug.Stack() ends with \n.
-				fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", p, debug.Stack())
+				err = &core.ExecError{
+					Panics:  []core.PanicInfo{{Value: fmt.Sprint(p), Stack: string(debug.Stack())}},
+					Message: "lgo kernel panicked",
+				}
 			}
 		}()
-		// Print the err in the notebook
-		if err = h.runner.Run(lgoCtx, r.Code); err != nil {
-			runner.PrintError(os.Stderr, err)
-		}
+		// err is sent to the notebook as an error message.
+		err = h.runner.Run(lgoCtx, r.Code)
 	}()
-	soClose()
-	seClose()
-	<-rDone
-	<-rDone
+	finish()
+	h.inspector.Update(ctx)
 	if err != nil {
 		return &scaffold.ExecuteResult{
 			Status:         "error",
 			ExecutionCount: h.execCount,
+			Ename:          runner.ErrorName(err),
+			Evalue:         runner.ErrorValue(err),
+			Traceback:      runner.ErrorLines(err),
 		}
 	}
 	return &scaffold.ExecuteResult{
@@ -242,6 +286,97 @@ func (*handlers) HandleIsComplete(req *scaffold.IsCompleteRequest) *scaffold.IsC
 	}
 }
 
+// startCell redirects os.Stdout and os.Stderr to stream and marks a cell running.
+// finish restores the outputs and marks the cell finished. It waits for comm handlers that joined the cell.
+func (h *handlers) startCell(stream func(string, string)) (finish func(), err error) {
+	h.cellMu.Lock()
+	defer h.cellMu.Unlock()
+	restore, err := captureOutputs(stream)
+	if err != nil {
+		return nil, err
+	}
+	h.cellRunning = true
+	return func() {
+		h.cellMu.Lock()
+		defer h.cellMu.Unlock()
+		h.cellRunning = false
+		restore()
+	}, nil
+}
+
+// runCommHandlers runs handle, which invokes comm handlers of lgo code, with the context of a comm message.
+// If lgo code of execute_request is running, handlers join the execution and their outputs go to the cell (See core.Comm.OnMsg).
+// Otherwise, stdout and stderr of handlers are sent for the comm message.
+// Whether the cell is running is decided and kept under cellMu until handle returns.
+func (h *handlers) runCommHandlers(ctx context.Context, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool), handle func(ctx core.LgoContext) error) error {
+	lgoCtx := core.LgoContext{Context: ctx, Display: jupyterDisplayer(displayData), Comms: h.comms}
+	h.cellMu.Lock()
+	defer h.cellMu.Unlock()
+	if h.cellRunning {
+		return handle(lgoCtx)
+	}
+	restore, err := captureOutputs(stream)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return handle(lgoCtx)
+}
+
+func (h *handlers) HandleCommOpen(ctx context.Context, req *scaffold.CommOpen, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool)) {
+	if err := h.runCommHandlers(ctx, stream, displayData, func(ctx core.LgoContext) error {
+		return h.comms.HandleOpen(ctx, req.CommID, req.TargetName, req.Data)
+	}); err != nil {
+		glog.Warningf("Failed to open a comm: %v", err)
+	}
+}
+
+func (h *handlers) HandleCommMsg(ctx context.Context, req *scaffold.CommMsg, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool)) {
+	if err := h.runCommHandlers(ctx, stream, displayData, func(ctx core.LgoContext) error {
+		return h.comms.HandleMsg(ctx, req.CommID, req.Data)
+	}); err != nil {
+		glog.Warningf("Failed to handle comm_msg: %v", err)
+	}
+}
+
+func (h *handlers) HandleCommClose(ctx context.Context, req *scaffold.CommClose, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool)) {
+	if err := h.runCommHandlers(ctx, stream, displayData, func(ctx core.LgoContext) error {
+		return h.comms.HandleClose(ctx, req.CommID, req.Data)
+	}); err != nil {
+		glog.Warningf("Failed to handle comm_close: %v", err)
+	}
+}
+
+func (h *handlers) HandleCommInfo(req *scaffold.CommInfoRequest) *scaffold.CommInfoReply {
+	comms := make(map[string]scaffold.CommInfo)
+	for id, target := range h.comms.Comms() {
+		if req.TargetName == "" || req.TargetName == target {
+			comms[id] = scaffold.CommInfo{TargetName: target}
+		}
+	}
+	return &scaffold.CommInfoReply{
+		Status: "ok",
+		Comms:  comms,
+	}
+}
+
+// commSender sends comm messages from core.CommManager to the frontend.
+type commSender struct {
+	server *scaffold.Server
+}
+
+func (s *commSender) SendCommOpen(ctx context.Context, id, target string, data map[string]interface{}) error {
+	return s.server.SendCommOpen(ctx, &scaffold.CommOpen{CommID: id, TargetName: target, Data: data})
+}
+
+func (s *commSender) SendCommMsg(ctx context.Context, id string, data map[string]interface{}) error {
+	return s.server.SendCommMsg(ctx, &scaffold.CommMsg{CommID: id, Data: data})
+}
+
+func (s *commSender) SendCommClose(ctx context.Context, id string, data map[string]interface{}) error {
+	return s.server.SendCommClose(ctx, &scaffold.CommClose{CommID: id, Data: data})
+}
+
 // kernelLogWriter forwards messages to the current os.Stderr, which is change on every execution.
 type kernelLogWriter struct{}
 
@@ -249,14 +384,25 @@ func (kernelLogWriter) Write(p []byte) (n int, err error) {
 	return os.Stderr.Write(p)
 }
 
-func kernelMain(lgopath string, sessID *runner.SessionID) {
+func kernelMain(lgopath string, sessID *runner.SessionID, rn *runner.LgoRunner) {
 	log.SetOutput(kernelLogWriter{})
-	server, err := scaffold.NewServer(*connectionFile, &handlers{
-		runner: runner.NewLgoRunner(lgopath, sessID),
-	})
+	// lgo code reads core.Stdin instead of os.Stdin (See converter/stdin.go).
+	// Replace os.Stdin so that other reads of os.Stdin do not block forever.
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		glog.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	os.Stdin = devNull
+	h := &handlers{
+		runner: rn,
+	}
+	setJournal(h.runner)
+	server, err := scaffold.NewServer(*connectionFile, h)
 	if err != nil {
 		glog.Fatalf("Failed to create a server: %v", err)
 	}
+	h.comms = core.NewCommManager(&commSender{server})
+	h.inspector = core.NewVarInspector(h.comms)
 
 	// Start the server loop
 	server.Loop()
@@ -1,10 +1,10 @@
 package liner
 
 import (
-	"go/ast"
 	"go/scanner"
 	"go/token"
 	"io"
+	"regexp"
 	"strings"
 
 	"github.com/peterh/liner"
@@ -60,46 +60,39 @@ func dropEmptyLine(lines []string) []string {
 	return r
 }
 
-func hasTypeOrMethodDecl(b *parser.LGOBlock) bool {
-	for _, s := range b.Stmts {
-		decl, _ := s.(*ast.DeclStmt)
-		if decl == nil {
-			continue
-		}
-		if f, _ := decl.Decl.(*ast.FuncDecl); f != nil && f.Recv != nil {
-			// methods
-			return true
-		}
-		if gen, _ := decl.Decl.(*ast.GenDecl); gen != nil && gen.Tok == token.TYPE {
-			// type decl
-			return true
-		}
+// continueForMagic returns whether lines starting with a magic command (e.g. %whos) need more lines.
+// A line magic is a single line. A cell magic (e.g. %%bash) continues until an empty line.
+func continueForMagic(lines []string) (bool, int) {
+	if !strings.HasPrefix(strings.TrimSpace(lines[0]), "%%") {
+		return false, 0
 	}
-	return false
+	return strings.TrimSpace(lines[len(lines)-1]) != "", 0
 }
 
-// continueForMethods returns true if lines have type or method declaration and the last line is not empty.
-func continueForMethods(b *parser.LGOBlock, lines []string) (bool, int) {
-	if len(lines) == 0 || !hasTypeOrMethodDecl(b) {
-		// Note: len(lines) check exists just for safety.
-		return false, 0
-	}
-	last := lines[len(lines)-1]
-	if strings.TrimSpace(last) == "" {
-		return false, 0
-	}
-	return true, 0
+// shellCapturePattern matches shell escapes whose output is assigned to a variable (e.g. files := !ls).
+var shellCapturePattern = regexp.MustCompile(`^\s*[\pL_][\pL\pN_]*\s*:?=\s*!`)
+
+// isShellEscape returns whether line is a shell escape (e.g. !ls), which is always a single line.
+func isShellEscape(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "!") || shellCapturePattern.MatchString(line)
 }
 
 func continueLine(lines []string) (bool, int) {
+	if len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[0]), "%") {
+		return continueForMagic(lines)
+	}
+	if len(lines) == 1 && isShellEscape(lines[0]) {
+		return false, 0
+	}
 	dropped := dropEmptyLine(lines)
 	src := strings.Join(dropped, "\n")
-	b, err := parseLesserGoString(src)
+	_, err := parseLesserGoString(src)
 	if err == nil {
-		return continueForMethods(b, lines)
+		return false, 0
 	}
 	if errs, ok := err.(scanner.ErrorList); !ok || !isUnexpectedEOF(errs, dropped) {
-		return continueForMethods(b, lines)
+		// Syntax errors are reported when the code is executed.
+		return false, 0
 	}
 	return true, nextIndent(src)
 }
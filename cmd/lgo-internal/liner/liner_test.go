@@ -94,8 +94,9 @@ func TestContinueLine(t *testing.T) {
 		expect: true,
 		indent: 1,
 	}, {
+		// Methods can be declared in later cells.
 		lines:  []string{"type s struct {}"},
-		expect: true,
+		expect: false,
 	}, {
 		lines:  []string{"type s struct {}", ""},
 		expect: false,
@@ -104,10 +105,28 @@ func TestContinueLine(t *testing.T) {
 		expect: false,
 	}, {
 		lines:  []string{"func (s) f(){}"},
-		expect: true,
+		expect: false,
 	}, {
 		lines:  []string{"func (s) f(){}", ""},
 		expect: false,
+	}, {
+		lines:  []string{"%whos"},
+		expect: false,
+	}, {
+		lines:  []string{"%%bash"},
+		expect: true,
+	}, {
+		lines:  []string{"%%bash", "echo hello"},
+		expect: true,
+	}, {
+		lines:  []string{"%%bash", "echo hello", ""},
+		expect: false,
+	}, {
+		lines:  []string{"!echo \"unterminated"},
+		expect: false,
+	}, {
+		lines:  []string{"files := !ls {"},
+		expect: false,
 	},
 	}
 
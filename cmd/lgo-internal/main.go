@@ -10,13 +10,13 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"syscall"
 
 	"github.com/golang/glog"
+	"github.com/yunabe/lgo/cmd/install"
 	"github.com/yunabe/lgo/cmd/lgo-internal/liner"
 	"github.com/yunabe/lgo/cmd/runner"
 	"github.com/yunabe/lgo/converter"
@@ -28,13 +28,34 @@ var (
 	subcomandFlag  = flag.String("subcommand", "", "lgo subcommand")
 	sessIDFlag     = flag.String("sess_id", "", "lgo session id")
 	connectionFile = flag.String("connection_file", "", "jupyter kernel connection file path. This flag is used with kernel subcommand")
+
+	execTimeout   = flag.Duration("exec_timeout", 0, "the maximum wall-clock time of each execution. 0 means no limit")
+	maxHeapMB     = flag.Uint64("max_heap_mb", 0, "the maximum heap size of the process in MiB. An execution is canceled if the heap exceeds this limit. 0 means no limit")
+	maxGoroutines = flag.Int("max_goroutines", 0, "the maximum number of goroutines started in each execution. 0 means no limit")
+
+	journalFlag = flag.String("journal", "", "a file to which lgo code executed successfully is appended. The file is read by lgo export")
+	exportOut   = flag.String("export_out", "", "the output directory of the exported program. This flag is used with export subcommand")
+
+	nbParams    = make(paramsFlag)
+	cellTimeout = flag.Duration("cell_timeout", 0, "the maximum wall-clock time of each cell. 0 means no limit. This flag is used with nbexec subcommand")
+	allowErrors = flag.Bool("allow_errors", false, "execute cells after a cell fails. This flag is used with nbexec subcommand")
+
+	nbSanitizers    stringsFlag
+	defaultSanitize = flag.Bool("default_sanitizers", true, "sanitize addresses, times, durations and goroutine IDs in outputs. This flag is used with nbtest subcommand")
 )
 
+func init() {
+	flag.Var(nbParams, "param", "a parameter of the notebook in the form of name=value. This flag can be repeated and is used with nbexec subcommand")
+	flag.Var(&nbSanitizers, "sanitize", "a regular expression of substrings of outputs ignored in comparison. This flag can be repeated and is used with nbtest subcommand")
+}
+
+// printer prints values of the last expressions in cells with the pretty printer in core.
+// Use core.SetPrettyConfig in a cell to change how values are printed.
 type printer struct{}
 
 func (*printer) Println(args ...interface{}) {
 	for _, arg := range args {
-		fmt.Println(arg)
+		fmt.Println(core.PrettySprint(arg))
 	}
 }
 
@@ -88,7 +109,7 @@ func fromFiles(ctx context.Context, rn *runner.LgoRunner) {
 			return
 		}
 		if err = rn.Run(core.LgoContext{Context: ctx}, string(src)); err != nil {
-			glog.Error(err)
+			runner.PrintError(os.Stderr, err)
 			return
 		}
 	}
@@ -150,20 +171,51 @@ loop:
 				}
 			}()
 			if err := rn.Run(core.LgoContext{Context: runCtx}, src); err != nil {
-				glog.Error(err)
+				runner.PrintError(os.Stderr, err)
 			}
 		}()
 	}
 }
 
-func installPkgArchive(pkgDir string, paths []string) error {
-	return exec.Command("go", append([]string{"install", "-linkshared", "-pkgdir", pkgDir}, paths...)...).Run()
+// setJournal makes rn record executed code to the file specified by --journal.
+func setJournal(rn *runner.LgoRunner) {
+	if *journalFlag == "" {
+		return
+	}
+	f, err := os.OpenFile(*journalFlag, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		glog.Fatalf("Failed to open the journal: %v", err)
+	}
+	rn.SetJournal(f)
+}
+
+func exportMain() {
+	if len(flag.Args()) != 1 {
+		glog.Fatal("export takes exactly one notebook or journal")
+	}
+	src := flag.Arg(0)
+	out := *exportOut
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	}
+	if err := runner.Export(src, out, os.Stderr); err != nil {
+		runner.PrintError(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %s to %s\n", src, out)
+}
+
+// installPkgArchive installs .a files of paths into the pkg directory of the session of rn.
+func installPkgArchive(rn *runner.LgoRunner, paths []string) error {
+	cmd := exec.Command("go", append([]string{"install", "-linkshared", "-pkgdir", rn.PkgDir()}, paths...)...)
+	cmd.Env = rn.GoEnv()
+	return cmd.Run()
 }
 
-type packageArchiveInstaller struct{ pkgDir string }
+type packageArchiveInstaller struct{ rn *runner.LgoRunner }
 
 func (in *packageArchiveInstaller) Install(pkgs []string) error {
-	return installPkgArchive(in.pkgDir, pkgs)
+	return installPkgArchive(in.rn, pkgs)
 }
 
 func main() {
@@ -188,26 +240,50 @@ func main() {
 		glog.Fatalf("Failed to get the absolute path of LGOPATH: %v", err)
 	}
 	core.RegisterLgoPrinter(&printer{})
-	pkgDir := path.Join(lgopath, "pkg")
+	core.SetSessionLimits(core.Limits{
+		Timeout:       *execTimeout,
+		MaxHeap:       *maxHeapMB << 20,
+		MaxGoroutines: *maxGoroutines,
+	})
+	rn := runner.NewLgoRunner(lgopath, &sessID)
 	// Fom go1.10, go install does not install .a files into GOPATH.
-	// We need to read package information from .a files installed in LGOPATH instead.
+	// We need to read package information from .a files installed in LGOPATH (or the pkg directory of the session
+	// if the session requires modules) instead.
 	converter.SetLGOImporter(importer.For("gc", func(path string) (io.ReadCloser, error) {
-		abs := filepath.Join(lgopath, "pkg", path+".a")
+		abs := filepath.Join(rn.PkgDir(), path+".a")
 		if _, err := os.Stat(abs); os.IsNotExist(err) {
-			installPkgArchive(pkgDir, []string{path})
+			installPkgArchive(rn, []string{path})
 		}
 		return os.Open(abs)
 	}))
-	converter.SetPackageArchiveInstaller(&packageArchiveInstaller{
-		pkgDir: pkgDir,
-	})
+	converter.SetPackageArchiveInstaller(&packageArchiveInstaller{rn})
+	converter.SetPackageIndex(install.NewPackageIndex(lgopath))
 
 	if *subcomandFlag == "kernel" {
-		kernelMain(lgopath, &sessID)
+		kernelMain(lgopath, &sessID, rn)
+		exitProcess()
+	}
+	if *subcomandFlag == "export" {
+		exportMain()
 		exitProcess()
 	}
 
-	rn := runner.NewLgoRunner(lgopath, &sessID)
+	setJournal(rn)
+	if *subcomandFlag == "nbexec" || *subcomandFlag == "nbtest" {
+		var err error
+		if *subcomandFlag == "nbexec" {
+			err = nbexecMain(createProcessContext(true), rn)
+		} else {
+			err = nbtestMain(createProcessContext(true), rn)
+		}
+		runner.CleanSession(lgopath, &sessID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", *subcomandFlag, err)
+			glog.Flush()
+			os.Exit(1)
+		}
+		exitProcess()
+	}
 	useFiles := len(flag.Args()) > 0
 	ctx := createProcessContext(useFiles)
 
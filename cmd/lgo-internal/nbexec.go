@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunabe/lgo/cmd/runner"
+	"github.com/yunabe/lgo/core"
+	scaffold "github.com/yunabe/lgo/jupyter/gojupyterscaffold"
+)
+
+// Tags of cells used by nbexec. These are compatible with papermill.
+const (
+	parametersTag         = "parameters"
+	injectedParametersTag = "injected-parameters"
+)
+
+// paramsFlag is a flag.Value of parameters of nbexec in the form of name=value. The flag can be repeated.
+type paramsFlag map[string]string
+
+func (p paramsFlag) String() string {
+	var s []string
+	for name, value := range p {
+		s = append(s, name+"="+value)
+	}
+	return strings.Join(s, ",")
+}
+
+func (p paramsFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("parameter must be name=value: %q", s)
+	}
+	p[s[:i]] = s[i+1:]
+	return nil
+}
+
+// cellRunner runs lgo code of cells. It's implemented by *runner.LgoRunner.
+type cellRunner interface {
+	Run(ctx core.LgoContext, src string) error
+	ParameterCode(params map[string]string) (string, error)
+}
+
+// nbExecutor executes code cells of a notebook in nbformat v4 and stores their outputs to the notebook.
+type nbExecutor struct {
+	runner cellRunner
+	params map[string]string
+	// timeout is the maximum execution time of each cell. 0 means no limit.
+	timeout time.Duration
+	// allowErrors makes nbExecutor execute cells after a cell fails.
+	allowErrors bool
+
+	// mu protects outputs of the current cell and displays.
+	mu sync.Mutex
+	// displays maps display IDs to outputs to update them. Outputs of previous cells can be updated too.
+	displays map[string]map[string]interface{}
+}
+
+// cellTags returns tags in the metadata of cell.
+func cellTags(cell map[string]interface{}) []string {
+	md, _ := cell["metadata"].(map[string]interface{})
+	tags, _ := md["tags"].([]interface{})
+	var s []string
+	for _, tag := range tags {
+		if tag, ok := tag.(string); ok {
+			s = append(s, tag)
+		}
+	}
+	return s
+}
+
+func hasTag(cell map[string]interface{}, tag string) bool {
+	for _, t := range cellTags(cell) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// cellSource returns the source of cell, which is a string or a list of lines in nbformat.
+func cellSource(cell map[string]interface{}) string {
+	switch src := cell["source"].(type) {
+	case string:
+		return src
+	case []interface{}:
+		var lines []string
+		for _, line := range src {
+			if line, ok := line.(string); ok {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "")
+	}
+	return ""
+}
+
+// multiline splits s into lines that keep newlines, as Jupyter saves multiline strings.
+func multiline(s string) []interface{} {
+	lines := []interface{}{}
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// injectParameters replaces cells tagged injected-parameters with a new cell and returns the index of the new cell.
+// The new cell is inserted after the cell tagged parameters or at the top if no cell is tagged.
+// The source of the cell is generated when the cell is executed because types of parameters are known then.
+func injectParameters(cells []interface{}) ([]interface{}, int) {
+	injected := map[string]interface{}{
+		"cell_type":       "code",
+		"execution_count": nil,
+		"metadata":        map[string]interface{}{"tags": []interface{}{injectedParametersTag}},
+		"outputs":         []interface{}{},
+		"source":          []interface{}{},
+	}
+	var result []interface{}
+	pos := 0
+	for _, c := range cells {
+		cell, _ := c.(map[string]interface{})
+		if cell != nil && hasTag(cell, injectedParametersTag) {
+			continue
+		}
+		result = append(result, c)
+		if cell != nil && hasTag(cell, parametersTag) && pos == 0 {
+			pos = len(result)
+		}
+	}
+	result = append(result, nil)
+	copy(result[pos+1:], result[pos:])
+	result[pos] = injected
+	return result, pos
+}
+
+// execute executes code cells in nb and returns the number of failed cells.
+// If a cell fails, the following cells are not executed unless allowErrors is set.
+func (e *nbExecutor) execute(ctx context.Context, nb map[string]interface{}) (failed int, err error) {
+	if v, _ := nb["nbformat"].(float64); v != 4 {
+		return 0, fmt.Errorf("nbformat %v is not supported. Only nbformat v4 is supported", nb["nbformat"])
+	}
+	cells, ok := nb["cells"].([]interface{})
+	if !ok {
+		return 0, errors.New("cells not found in the notebook")
+	}
+	injected := -1
+	if len(e.params) > 0 {
+		cells, injected = injectParameters(cells)
+		nb["cells"] = cells
+	}
+	if e.displays == nil {
+		e.displays = make(map[string]map[string]interface{})
+	}
+	count := 0
+	for i, c := range cells {
+		cell, ok := c.(map[string]interface{})
+		if !ok || cell["cell_type"] != "code" {
+			continue
+		}
+		cell["outputs"] = []interface{}{}
+		cell["execution_count"] = nil
+		if (failed > 0 && !e.allowErrors) || ctx.Err() != nil {
+			continue
+		}
+		src := cellSource(cell)
+		var perr error
+		if i == injected {
+			src, perr = e.runner.ParameterCode(e.params)
+			cell["source"] = multiline(src)
+		}
+		if strings.TrimSpace(src) == "" && perr == nil {
+			continue
+		}
+		count++
+		cell["execution_count"] = count
+		var outputs []interface{}
+		var err error
+		if perr != nil {
+			err = perr
+		} else {
+			outputs, err = e.runCell(ctx, src)
+		}
+		if err != nil {
+			failed++
+			outputs = append(outputs, map[string]interface{}{
+				"output_type": "error",
+				"ename":       runner.ErrorName(err),
+				"evalue":      runner.ErrorValue(err),
+				"traceback":   stringsToInterfaces(runner.ErrorLines(err)),
+			})
+			fmt.Fprintf(os.Stderr, "Cell %d failed: %s: %s\n", i+1, runner.ErrorName(err), runner.ErrorValue(err))
+		}
+		cell["outputs"] = outputs
+	}
+	return failed, nil
+}
+
+func stringsToInterfaces(s []string) []interface{} {
+	r := []interface{}{}
+	for _, e := range s {
+		r = append(r, e)
+	}
+	return r
+}
+
+// runCell runs src and returns outputs of the execution in nbformat v4.
+func (e *nbExecutor) runCell(ctx context.Context, src string) (outputs []interface{}, err error) {
+	// The current output of streams. text is converted to a list of lines after the execution.
+	var streams []map[string]interface{}
+	stream := func(name, text string) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if n := len(outputs); n > 0 {
+			if last, _ := outputs[n-1].(map[string]interface{}); last["output_type"] == "stream" && last["name"] == name {
+				last["text"] = last["text"].(string) + text
+				return
+			}
+		}
+		out := map[string]interface{}{"output_type": "stream", "name": name, "text": text}
+		streams = append(streams, out)
+		outputs = append(outputs, out)
+	}
+	display := func(data *scaffold.DisplayData, update bool) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		id, _ := data.Transient["display_id"].(string)
+		metadata := data.Metadata
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		if update {
+			if out := e.displays[id]; out != nil {
+				out["data"] = data.Data
+				out["metadata"] = metadata
+			}
+			return
+		}
+		out := map[string]interface{}{"output_type": "display_data", "data": data.Data, "metadata": metadata}
+		if id != "" {
+			e.displays[id] = out
+		}
+		outputs = append(outputs, out)
+	}
+
+	done := make(chan struct{})
+	soClose, err := pipeOutput(func(msg string) { stream("stdout", msg) }, &os.Stdout, done)
+	if err != nil {
+		return nil, err
+	}
+	seClose, err := pipeOutput(func(msg string) { stream("stderr", msg) }, &os.Stderr, done)
+	if err != nil {
+		soClose()
+		<-done
+		return nil, err
+	}
+	runCtx, cancel := ctx, func() {}
+	if e.timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, e.timeout)
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				err = &core.ExecError{
+					Panics:  []core.PanicInfo{{Value: fmt.Sprint(p), Stack: string(debug.Stack())}},
+					Message: "lgo nbexec panicked",
+				}
+			}
+		}()
+		err = e.runner.Run(core.LgoContext{Context: runCtx, Display: jupyterDisplayer(display)}, src)
+	}()
+	if err != nil && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("the cell timed out after %v: %v", e.timeout, err)
+	}
+	cancel()
+	soClose()
+	seClose()
+	<-done
+	<-done
+	for _, s := range streams {
+		s["text"] = multiline(s["text"].(string))
+	}
+	return outputs, err
+}
+
+// readNotebook reads a notebook in JSON.
+func readNotebook(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nb map[string]interface{}
+	if err := json.Unmarshal(b, &nb); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nb, nil
+}
+
+// encodeNotebook encodes nb in JSON without escaping HTML as Jupyter does.
+func encodeNotebook(nb interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(nb); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// nbexecMain executes a notebook at the first argument and writes the result to the second argument.
+// It returns an error if a cell fails. The output notebook is written even if cells fail.
+func nbexecMain(ctx context.Context, rn *runner.LgoRunner) error {
+	if len(flag.Args()) != 2 {
+		return errors.New("nbexec takes an input notebook and an output notebook")
+	}
+	in, out := flag.Arg(0), flag.Arg(1)
+	nb, err := readNotebook(in)
+	if err != nil {
+		return err
+	}
+	e := &nbExecutor{
+		runner:      rn,
+		params:      nbParams,
+		timeout:     *cellTimeout,
+		allowErrors: *allowErrors,
+	}
+	failed, err := e.execute(ctx, nb)
+	if err != nil {
+		return fmt.Errorf("failed to execute %s: %v", in, err)
+	}
+	b, err := encodeNotebook(nb, " ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the notebook: %v", err)
+	}
+	if err := ioutil.WriteFile(out, b, 0644); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d cell(s) failed in %s", failed, in)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("execution of %s was canceled", in)
+	}
+	return nil
+}
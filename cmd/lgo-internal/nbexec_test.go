@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// fakeCellRunner prints the source of cells. A cell fails if it contains "fail", shows HTML instead of printing the source
+// if it contains "html" and blocks until the cancellation if it contains "sleep".
+type fakeCellRunner struct {
+	srcs []string
+}
+
+func (r *fakeCellRunner) Run(ctx core.LgoContext, src string) error {
+	r.srcs = append(r.srcs, src)
+	if strings.Contains(src, "html") {
+		// Do not print the source because the order of outputs in stdout and displays is not deterministic.
+		ctx.Display.HTML("<b>hello</b>", nil)
+		return nil
+	}
+	fmt.Print(src)
+	switch {
+	case strings.Contains(src, "fail"):
+		return errors.New("failed")
+	case strings.Contains(src, "sleep"):
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (r *fakeCellRunner) ParameterCode(params map[string]string) (string, error) {
+	return fmt.Sprintf("x = %s", params["x"]), nil
+}
+
+func parseNotebook(t *testing.T, s string) map[string]interface{} {
+	var nb map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &nb); err != nil {
+		t.Fatal(err)
+	}
+	return nb
+}
+
+func TestNbExecute(t *testing.T) {
+	nb := parseNotebook(t, `{
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# title"]},
+  {"cell_type": "code", "execution_count": 5, "metadata": {"tags": ["parameters"]}, "outputs": [], "source": ["x := 1\n"]},
+  {"cell_type": "code", "execution_count": 6, "metadata": {"tags": ["injected-parameters"]}, "outputs": [], "source": ["x = 0"]},
+  {"cell_type": "code", "execution_count": 7, "metadata": {}, "outputs": [{"output_type": "stream", "name": "stdout", "text": ["old"]}], "source": ["html\n", "y := x"]},
+  {"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": []}
+ ],
+ "metadata": {},
+ "nbformat": 4,
+ "nbformat_minor": 2
+}`)
+	r := &fakeCellRunner{}
+	e := &nbExecutor{runner: r, params: map[string]string{"x": "10"}}
+	failed, err := e.execute(context.Background(), nb)
+	if err != nil || failed != 0 {
+		t.Fatalf("execute() = %d, %v", failed, err)
+	}
+	if want := []string{"x := 1\n", "x = 10", "html\ny := x"}; !reflect.DeepEqual(r.srcs, want) {
+		t.Errorf("got %q; want %q", r.srcs, want)
+	}
+	b, err := encodeNotebook(nb["cells"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"cell_type":"markdown","metadata":{},"source":["# title"]},` +
+		`{"cell_type":"code","execution_count":1,"metadata":{"tags":["parameters"]},"outputs":[{"name":"stdout","output_type":"stream","text":["x := 1\n"]}],"source":["x := 1\n"]},` +
+		`{"cell_type":"code","execution_count":2,"metadata":{"tags":["injected-parameters"]},"outputs":[{"name":"stdout","output_type":"stream","text":["x = 10"]}],"source":["x = 10"]},` +
+		`{"cell_type":"code","execution_count":3,"metadata":{},"outputs":[{"data":{"text/html":"<b>hello</b>"},"metadata":{},"output_type":"display_data"}],"source":["html\n","y := x"]},` +
+		`{"cell_type":"code","execution_count":null,"metadata":{},"outputs":[],"source":[]}]`
+	if got := strings.TrimSpace(string(b)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNbExecute_failure(t *testing.T) {
+	src := `{
+ "cells": [
+  {"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": "fail"},
+  {"cell_type": "code", "execution_count": 3, "metadata": {}, "outputs": [{"output_type": "stream", "name": "stdout", "text": "old"}], "source": "ok"}
+ ],
+ "metadata": {},
+ "nbformat": 4,
+ "nbformat_minor": 2
+}`
+	nb := parseNotebook(t, src)
+	r := &fakeCellRunner{}
+	failed, err := (&nbExecutor{runner: r}).execute(context.Background(), nb)
+	if err != nil || failed != 1 {
+		t.Fatalf("execute() = %d, %v", failed, err)
+	}
+	cells := nb["cells"].([]interface{})
+	outputs := cells[0].(map[string]interface{})["outputs"].([]interface{})
+	if len(outputs) != 2 || outputs[1].(map[string]interface{})["output_type"] != "error" {
+		t.Errorf("unexpected outputs: %v", outputs)
+	}
+	second := cells[1].(map[string]interface{})
+	if second["execution_count"] != nil || len(second["outputs"].([]interface{})) != 0 {
+		t.Errorf("the cell after the failure was not cleared: %v", second)
+	}
+
+	// allowErrors executes all cells.
+	nb = parseNotebook(t, src)
+	r = &fakeCellRunner{}
+	failed, err = (&nbExecutor{runner: r, allowErrors: true}).execute(context.Background(), nb)
+	if err != nil || failed != 1 || len(r.srcs) != 2 {
+		t.Errorf("execute() = %d, %v; executed %q", failed, err, r.srcs)
+	}
+}
+
+func TestNbExecute_timeout(t *testing.T) {
+	nb := parseNotebook(t, `{"cells": [{"cell_type": "code", "metadata": {}, "outputs": [], "source": "sleep"}], "nbformat": 4}`)
+	start := time.Now()
+	failed, err := (&nbExecutor{runner: &fakeCellRunner{}, timeout: 50 * time.Millisecond}).execute(context.Background(), nb)
+	if err != nil || failed != 1 {
+		t.Fatalf("execute() = %d, %v", failed, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the timeout did not work: %v", d)
+	}
+	outputs := nb["cells"].([]interface{})[0].(map[string]interface{})["outputs"].([]interface{})
+	last := outputs[len(outputs)-1].(map[string]interface{})
+	if v, _ := last["evalue"].(string); !strings.Contains(v, "timed out after 50ms") {
+		t.Errorf("unexpected error: %v", last)
+	}
+}
+
+func TestNbExecute_unsupportedFormat(t *testing.T) {
+	nb := parseNotebook(t, `{"cells": [], "nbformat": 3}`)
+	if _, err := (&nbExecutor{runner: &fakeCellRunner{}}).execute(context.Background(), nb); err == nil {
+		t.Error("no error for nbformat 3")
+	}
+}
+
+func TestInjectParameters(t *testing.T) {
+	cells := []interface{}{
+		map[string]interface{}{"cell_type": "code", "metadata": map[string]interface{}{}},
+		map[string]interface{}{"cell_type": "code", "metadata": map[string]interface{}{}},
+	}
+	got, pos := injectParameters(cells)
+	if len(got) != 3 || pos != 0 || !hasTag(got[0].(map[string]interface{}), injectedParametersTag) {
+		t.Errorf("got %v, %d", got, pos)
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	for s, want := range map[string][]interface{}{
+		"":        {},
+		"a":       {"a"},
+		"a\nb\n":  {"a\n", "b\n"},
+		"a\n\nbc": {"a\n", "\n", "bc"},
+	} {
+		if got := multiline(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("multiline(%q) = %q; want %q", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/yunabe/lgo/cmd/runner"
+)
+
+// ignoreOutputTag is the tag of cells whose outputs are not compared by nbtest (e.g. cells that print random values).
+const ignoreOutputTag = "nbtest-ignore-output"
+
+// sanitizer replaces substrings of outputs that change in every execution before nbtest compares outputs.
+type sanitizer struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// defaultSanitizers replace memory addresses, times, durations and goroutine IDs.
+var defaultSanitizers = []sanitizer{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?( ?(Z|[+-]\d{2}:?\d{2}))?( [A-Z]{3,5})?( m=[+-]\d+\.\d+)?`), "<time>"},
+	{regexp.MustCompile(`\b(\d+(\.\d+)?(ns|µs|us|ms|s|m|h))+\b`), "<duration>"},
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "0x<addr>"},
+	{regexp.MustCompile(`goroutine \d+`), "goroutine <id>"},
+}
+
+// stringsFlag is a flag.Value of a list of strings. The flag can be repeated.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// newSanitizers returns sanitizers that replace matches of patterns with "<sanitized>".
+// defaultSanitizers are prepended if withDefaults is true.
+func newSanitizers(patterns []string, withDefaults bool) ([]sanitizer, error) {
+	var s []sanitizer
+	if withDefaults {
+		s = append(s, defaultSanitizers...)
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sanitizer: %v", err)
+		}
+		s = append(s, sanitizer{re, "<sanitized>"})
+	}
+	return s, nil
+}
+
+// textOf returns a multiline string in nbformat, which is a string or a list of lines.
+func textOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var lines []string
+		for _, line := range v {
+			if line, ok := line.(string); ok {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "")
+	}
+	return ""
+}
+
+// outputText returns the text compared by nbtest from outputs of a cell.
+// stdout, stderr and text/plain of displayed data are grouped because their order is not deterministic.
+func outputText(outputs []interface{}) string {
+	var stdout, stderr, plain string
+	for _, o := range outputs {
+		out, _ := o.(map[string]interface{})
+		switch out["output_type"] {
+		case "stream":
+			if out["name"] == "stderr" {
+				stderr += textOf(out["text"])
+			} else {
+				stdout += textOf(out["text"])
+			}
+		case "display_data", "execute_result":
+			data, _ := out["data"].(map[string]interface{})
+			if t, ok := data["text/plain"]; ok {
+				plain += textOf(t)
+				if !strings.HasSuffix(plain, "\n") {
+					plain += "\n"
+				}
+			}
+		}
+	}
+	text := stdout
+	if stderr != "" {
+		text += "[stderr]\n" + stderr
+	}
+	if plain != "" {
+		text += "[text/plain]\n" + plain
+	}
+	return text
+}
+
+// errorOutput returns the error output of a cell or nil if the cell succeeded.
+func errorOutput(outputs []interface{}) map[string]interface{} {
+	for _, o := range outputs {
+		if out, _ := o.(map[string]interface{}); out["output_type"] == "error" {
+			return out
+		}
+	}
+	return nil
+}
+
+func sanitize(s string, sanitizers []sanitizer) string {
+	for _, san := range sanitizers {
+		s = san.re.ReplaceAllString(s, san.repl)
+	}
+	return s
+}
+
+// lineDiff returns the diff of lines in a and b. Lines are prefixed with " ", "-" or "+".
+func lineDiff(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	return diff
+}
+
+func splitOutputLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// cellMismatch is a cell whose outputs in the executed notebook do not match the stored outputs.
+type cellMismatch struct {
+	// index is the index of the cell in the notebook.
+	index int
+	// message describes the mismatch.
+	message string
+	// diff is the diff of outputs from the stored outputs.
+	diff []string
+}
+
+// compareNotebooks compares outputs of code cells in stored with outputs in actual, which is stored executed again.
+// Cells that have neither an execution count nor outputs in stored (i.e. cells that have not been executed) and cells
+// tagged nbtest-ignore-output are skipped. Cells whose execution counts were cleared are compared if they have outputs.
+// It returns mismatches and the number of compared cells.
+func compareNotebooks(stored, actual map[string]interface{}, sanitizers []sanitizer) (mismatches []cellMismatch, compared int) {
+	scells, _ := stored["cells"].([]interface{})
+	acells, _ := actual["cells"].([]interface{})
+	for i, c := range scells {
+		scell, _ := c.(map[string]interface{})
+		if scell["cell_type"] != "code" || hasTag(scell, ignoreOutputTag) || i >= len(acells) {
+			continue
+		}
+		souts, _ := scell["outputs"].([]interface{})
+		if scell["execution_count"] == nil && len(souts) == 0 {
+			continue
+		}
+		acell, _ := acells[i].(map[string]interface{})
+		compared++
+		aouts, _ := acell["outputs"].([]interface{})
+		serr, aerr := errorOutput(souts), errorOutput(aouts)
+		if acell["execution_count"] == nil {
+			mismatches = append(mismatches, cellMismatch{index: i, message: "not executed"})
+			continue
+		}
+		if serr == nil && aerr != nil {
+			mismatches = append(mismatches, cellMismatch{
+				index:   i,
+				message: fmt.Sprintf("failed: %v: %v", aerr["ename"], aerr["evalue"]),
+			})
+			continue
+		}
+		if serr != nil && aerr == nil {
+			mismatches = append(mismatches, cellMismatch{index: i, message: fmt.Sprintf("expected to fail with %v but succeeded", serr["ename"])})
+			continue
+		}
+		want := sanitize(outputText(souts), sanitizers)
+		got := sanitize(outputText(aouts), sanitizers)
+		if want != got {
+			mismatches = append(mismatches, cellMismatch{
+				index:   i,
+				message: "outputs differ",
+				diff:    lineDiff(splitOutputLines(want), splitOutputLines(got)),
+			})
+		}
+	}
+	return
+}
+
+// reportMismatches writes mismatches in a human-readable format to w.
+func reportMismatches(w io.Writer, stored map[string]interface{}, mismatches []cellMismatch) {
+	cells, _ := stored["cells"].([]interface{})
+	for _, m := range mismatches {
+		cell, _ := cells[m.index].(map[string]interface{})
+		count := " "
+		if c := cell["execution_count"]; c != nil {
+			count = fmt.Sprint(c)
+		}
+		fmt.Fprintf(w, "--- cell %d (In [%s]): %s\n", m.index+1, count, m.message)
+		for _, line := range m.diff {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+}
+
+// nbtestMain executes a notebook at the first argument and compares outputs of cells with the outputs stored in the
+// notebook. It returns an error if outputs do not match.
+func nbtestMain(ctx context.Context, rn *runner.LgoRunner) error {
+	if len(flag.Args()) != 1 {
+		return errors.New("nbtest takes a notebook")
+	}
+	path := flag.Arg(0)
+	sanitizers, err := newSanitizers(nbSanitizers, *defaultSanitize)
+	if err != nil {
+		return err
+	}
+	stored, err := readNotebook(path)
+	if err != nil {
+		return err
+	}
+	actual, err := readNotebook(path)
+	if err != nil {
+		return err
+	}
+	// Cells expected to fail are executed too.
+	e := &nbExecutor{runner: rn, timeout: *cellTimeout, allowErrors: true}
+	if _, err := e.execute(ctx, actual); err != nil {
+		return fmt.Errorf("failed to execute %s: %v", path, err)
+	}
+	mismatches, compared := compareNotebooks(stored, actual, sanitizers)
+	reportMismatches(os.Stdout, stored, mismatches)
+	if len(mismatches) > 0 {
+		return fmt.Errorf("FAIL: %s: %d of %d cells do not match", path, len(mismatches), compared)
+	}
+	fmt.Printf("PASS: %s: %d cells\n", path, compared)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	sanitizers, err := newSanitizers([]string{`r = \d+`}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"p = 0xc420010000", "p = 0x<addr>"},
+		{"2018-01-02 15:04:05.123456 +0900 JST m=+0.001", "<time>"},
+		{"took 1.5ms", "took <duration>"},
+		{"elapsed: 1m30.5s", "elapsed: <duration>"},
+		{"goroutine 18 [running]:", "goroutine <id> [running]:"},
+		{"r = 12345", "<sanitized>"},
+		{"10 items", "10 items"},
+	}
+	for _, tc := range tests {
+		if got := sanitize(tc.in, sanitizers); got != tc.want {
+			t.Errorf("sanitize(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+	if _, err := newSanitizers([]string{"("}, false); err == nil {
+		t.Error("no error for an invalid regexp")
+	}
+}
+
+func TestOutputText(t *testing.T) {
+	outputs := []interface{}{
+		map[string]interface{}{"output_type": "stream", "name": "stdout", "text": []interface{}{"a\n", "b"}},
+		map[string]interface{}{"output_type": "display_data", "data": map[string]interface{}{"text/html": "<b>x</b>", "text/plain": "x"}},
+		map[string]interface{}{"output_type": "stream", "name": "stderr", "text": "warn\n"},
+		map[string]interface{}{"output_type": "display_data", "data": map[string]interface{}{"image/png": "..."}},
+		map[string]interface{}{"output_type": "stream", "name": "stdout", "text": "c\n"},
+	}
+	if got, want := outputText(outputs), "a\nbc\n[stderr]\nwarn\n[text/plain]\nx\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff([]string{"a", "b", "c", "d"}, []string{"a", "c", "x", "d"})
+	want := []string{" a", "-b", " c", "+x", " d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := lineDiff(nil, []string{"a"}); !reflect.DeepEqual(got, []string{"+a"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func codeCell(count interface{}, outputs ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"cell_type":       "code",
+		"execution_count": count,
+		"metadata":        map[string]interface{}{},
+		"outputs":         append([]interface{}{}, outputs...),
+	}
+}
+
+func stdoutOutput(text string) interface{} {
+	return map[string]interface{}{"output_type": "stream", "name": "stdout", "text": text}
+}
+
+func TestCompareNotebooks(t *testing.T) {
+	errOutput := map[string]interface{}{"output_type": "error", "ename": "Panic", "evalue": "boom"}
+	ignored := codeCell(6.0, stdoutOutput("random 1\n"))
+	ignored["metadata"] = map[string]interface{}{"tags": []interface{}{ignoreOutputTag}}
+	stored := map[string]interface{}{"cells": []interface{}{
+		map[string]interface{}{"cell_type": "markdown"},
+		codeCell(1.0, stdoutOutput("same\n")),
+		codeCell(2.0, stdoutOutput("a\nb\n")),
+		codeCell(3.0, errOutput),
+		codeCell(4.0),
+		codeCell(5.0, errOutput),
+		ignored,
+		codeCell(nil),
+		codeCell(7.0, stdoutOutput("took 10ms\n")),
+		codeCell(nil, stdoutOutput("cleared\n")),
+	}}
+	actual := map[string]interface{}{"cells": []interface{}{
+		map[string]interface{}{"cell_type": "markdown"},
+		codeCell(1, stdoutOutput("same\n")),
+		codeCell(2, stdoutOutput("a\nc\n")),
+		codeCell(3, errOutput),
+		codeCell(4, errOutput),
+		codeCell(5),
+		codeCell(6, stdoutOutput("random 2\n")),
+		codeCell(7, stdoutOutput("new\n")),
+		codeCell(8, stdoutOutput("took 12ms\n")),
+		codeCell(9, stdoutOutput("changed\n")),
+	}}
+	mismatches, compared := compareNotebooks(stored, actual, defaultSanitizers)
+	if compared != 7 {
+		t.Errorf("compared %d cells; want 7", compared)
+	}
+	var buf bytes.Buffer
+	reportMismatches(&buf, stored, mismatches)
+	want := strings.Join([]string{
+		"--- cell 3 (In [2]): outputs differ",
+		"     a",
+		"    -b",
+		"    +c",
+		"--- cell 5 (In [4]): failed: Panic: boom",
+		"--- cell 6 (In [5]): expected to fail with Panic but succeeded",
+		"--- cell 10 (In [ ]): outputs differ",
+		"    -cleared",
+		"    +changed",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
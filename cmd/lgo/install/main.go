@@ -120,6 +120,10 @@ func InstallMain() {
 	if err := cmd.Run(); err != nil {
 		log.Fatalf("Failed to build lgo-internal: %v", err)
 	}
+	// Running kernels reload packages installed in pkgDir.
+	if err := install.InvalidatePackageIndex(root); err != nil {
+		log.Fatalf("Failed to update the package index: %v", err)
+	}
 	log.Printf("lgo was installed in %s successfully", root)
 }
 
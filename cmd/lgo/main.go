@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/yunabe/lgo/cmd/lgo/install"
@@ -28,6 +29,9 @@ The commands are;
 	installpkg    install packages into $LGOPATH. This operation is optional.
 	kernel        run a jupyter notebook kernel
 	run           run Go code defined in files
+	export        export a notebook or a session journal to a standalone Go program
+	nbexec        execute a notebook without Jupyter and save the outputs to another notebook
+	nbtest        execute a notebook and compare the outputs with the outputs saved in the notebook
 	repl          ...
 	clean         clean temporary files created by lgo
 `
@@ -39,7 +43,8 @@ func printUsageAndExit() {
 	os.Exit(1)
 }
 
-func runLgoInternal(subcommand string, extraArgs []string) {
+// runLgoInternal runs lgo-internal with subcommand and returns an error if lgo-internal fails.
+func runLgoInternal(subcommand string, extraArgs []string) error {
 	// TODO: Consolidate this logic to check env variables.
 	if runtime.GOOS != "linux" {
 		log.Fatal("lgo only supports Linux")
@@ -72,24 +77,140 @@ func runLgoInternal(subcommand string, extraArgs []string) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	err = cmd.Run()
+	if err != nil {
 		log.Printf("lgo-internal failed: %v", err)
 	}
 	// In case lgo-internal exists before cleaning files (e.g. os.Exit is called)
 	runner.CleanSession(lgopath, sessID)
+	return err
+}
+
+// limitFlags defines flags to limit resources of executions in fs.
+// The returned function returns args to pass the flags to lgo-internal.
+func limitFlags(fs *flag.FlagSet) func() []string {
+	timeout := fs.Duration("exec_timeout", 0, "the maximum wall-clock time of each execution. 0 means no limit")
+	maxHeapMB := fs.Uint64("max_heap_mb", 0, "the maximum heap size in MiB. An execution is canceled if the heap exceeds this limit. 0 means no limit")
+	maxGoroutines := fs.Int("max_goroutines", 0, "the maximum number of goroutines started in each execution. 0 means no limit")
+	return func() []string {
+		return []string{
+			fmt.Sprintf("--exec_timeout=%v", *timeout),
+			fmt.Sprintf("--max_heap_mb=%d", *maxHeapMB),
+			fmt.Sprintf("--max_goroutines=%d", *maxGoroutines),
+		}
+	}
+}
+
+// journalFlag defines a flag to record executed code in fs.
+// The returned function returns args to pass the flag to lgo-internal.
+func journalFlag(fs *flag.FlagSet) func() []string {
+	journal := fs.String("journal", "", "a file to which code executed successfully is appended. Use lgo export to convert the file into a Go program")
+	return func() []string {
+		if *journal == "" {
+			return nil
+		}
+		abs, err := filepath.Abs(*journal)
+		if err != nil {
+			log.Fatalf("Failed to get the absolute path of %s: %v", *journal, err)
+		}
+		return []string{"--journal=" + abs}
+	}
 }
 
 func runMain() {
 	fs := flag.NewFlagSet("lgo run", flag.ExitOnError)
+	limitArgs := limitFlags(fs)
+	journalArgs := journalFlag(fs)
 	fs.Parse(os.Args[2:])
-	runLgoInternal("run", fs.Args())
+	args := append(limitArgs(), journalArgs()...)
+	runLgoInternal("run", append(args, fs.Args()...))
 }
 
 func kernelMain() {
 	fs := flag.NewFlagSet("lgo kernel", flag.ExitOnError)
 	connectionFile := fs.String("connection_file", "", "jupyter kernel connection file path.")
+	limitArgs := limitFlags(fs)
+	journalArgs := journalFlag(fs)
+	fs.Parse(os.Args[2:])
+	args := append([]string{"--connection_file=" + *connectionFile}, limitArgs()...)
+	runLgoInternal("kernel", append(args, journalArgs()...))
+}
+
+func exportMain() {
+	fs := flag.NewFlagSet("lgo export", flag.ExitOnError)
+	out := fs.String("o", "", "the output directory. The name of the input file without the extension is used by default")
 	fs.Parse(os.Args[2:])
-	runLgoInternal("kernel", []string{"--connection_file=" + *connectionFile})
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lgo export [-o dir] notebook.ipynb|journal")
+		os.Exit(1)
+	}
+	var args []string
+	if *out != "" {
+		args = append(args, "--export_out="+*out)
+	}
+	if runLgoInternal("export", append(args, fs.Arg(0))) != nil {
+		os.Exit(1)
+	}
+}
+
+// stringsFlag is a flag.Value of a list of strings. The flag can be repeated.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func nbexecMain() {
+	fs := flag.NewFlagSet("lgo nbexec", flag.ExitOnError)
+	// Parameters are passed to lgo-internal as is. They are parsed with paramsFlag of lgo-internal.
+	var params stringsFlag
+	fs.Var(&params, "p", "a parameter in the form of name=value. The value is assigned to the variable in the cell tagged \"parameters\". This flag can be repeated")
+	cellTimeout := fs.Duration("cell_timeout", 0, "the maximum wall-clock time of each cell. 0 means no limit")
+	allowErrors := fs.Bool("allow_errors", false, "execute the following cells even if a cell fails")
+	limitArgs := limitFlags(fs)
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: lgo nbexec [-p name=value]... in.ipynb out.ipynb")
+		os.Exit(1)
+	}
+	args := append(limitArgs(), fmt.Sprintf("--cell_timeout=%v", *cellTimeout), fmt.Sprintf("--allow_errors=%v", *allowErrors))
+	for _, p := range params {
+		args = append(args, "--param="+p)
+	}
+	if runLgoInternal("nbexec", append(args, fs.Args()...)) != nil {
+		os.Exit(1)
+	}
+}
+
+func nbtestMain() {
+	fs := flag.NewFlagSet("lgo nbtest", flag.ExitOnError)
+	var sanitizers stringsFlag
+	fs.Var(&sanitizers, "sanitize", "a regular expression of substrings of outputs ignored in comparison (e.g. random values). This flag can be repeated")
+	defaults := fs.Bool("default_sanitizers", true, "ignore memory addresses, times, durations and goroutine IDs in outputs")
+	cellTimeout := fs.Duration("cell_timeout", 0, "the maximum wall-clock time of each cell. 0 means no limit")
+	limitArgs := limitFlags(fs)
+	fs.Parse(os.Args[2:])
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: lgo nbtest [-sanitize regexp]... notebook.ipynb...")
+		os.Exit(1)
+	}
+	args := append(limitArgs(), fmt.Sprintf("--cell_timeout=%v", *cellTimeout), fmt.Sprintf("--default_sanitizers=%v", *defaults))
+	for _, s := range sanitizers {
+		args = append(args, "--sanitize="+s)
+	}
+	failed := false
+	// Each notebook is tested in a new session.
+	for _, nb := range fs.Args() {
+		if runLgoInternal("nbtest", append(args, nb)) != nil {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func main() {
@@ -109,6 +230,12 @@ func main() {
 		kernelMain()
 	case "run":
 		runMain()
+	case "export":
+		exportMain()
+	case "nbexec":
+		nbexecMain()
+	case "nbtest":
+		nbtestMain()
 	case "clean":
 		fmt.Fprint(os.Stderr, "not implemented")
 	case "help":
@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// runTime implements %time and %%time, which execute lgo code once and report the wall time,
+// memory allocations and GC cycles of the execution.
+func runTime(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	src := m.Body
+	if !m.Cell {
+		src = m.Args
+	} else if m.Args != "" {
+		return fmt.Errorf("%s does not take arguments", m)
+	}
+	if strings.TrimSpace(src) == "" {
+		return fmt.Errorf("%s: no code to execute", m)
+	}
+	return rn.runCode(ctx, src, func(exec func() error) error {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		err := exec()
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		fmt.Fprintf(os.Stdout, "Wall time: %v, allocations: %d (%s), GC cycles: %d\n",
+			elapsed, after.Mallocs-before.Mallocs, formatBytes(float64(after.TotalAlloc-before.TotalAlloc)),
+			after.NumGC-before.NumGC)
+		return err
+	})
+}
+
+// benchContextKey is the key of the context value that runs the loop of %%bench.
+// Code generated for %%bench gets the function from _ctx without importing packages.
+const benchContextKey = "github.com/yunabe/lgo/cmd/runner.bench"
+
+// benchResult keeps the results of a %%bench cell.
+type benchResult struct {
+	name    string
+	samples []testing.BenchmarkResult
+}
+
+// benchCode returns lgo code that runs body b.N times in the loop of testing.Benchmark.
+// The loop is prepended to the first line of body to keep line numbers in body.
+func benchCode(body string) string {
+	return fmt.Sprintf("_ctx.Value(%q).(func(func(int)))(func(lgoBenchN int) { for lgoBenchI := 0; lgoBenchI < lgoBenchN; lgoBenchI++ {%s\n}})",
+		benchContextKey, body)
+}
+
+// benchmark runs f with testing.Benchmark count times.
+// testing.Benchmark calls f in another goroutine. A panic in f (e.g. cancellation) is propagated to the caller.
+func benchmark(f func(n int), count int) []testing.BenchmarkResult {
+	var results []testing.BenchmarkResult
+	var panicked interface{}
+	for i := 0; i < count && panicked == nil; i++ {
+		r := testing.Benchmark(func(b *testing.B) {
+			if panicked != nil {
+				return
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = p
+				}
+			}()
+			b.ReportAllocs()
+			f(b.N)
+		})
+		results = append(results, r)
+	}
+	if panicked != nil {
+		panic(panicked)
+	}
+	return results
+}
+
+// runBench implements %%bench (and its alias %%timeit), which runs the body of the cell in the loop of testing.Benchmark.
+// The body must consist of statements. Use -count to repeat the benchmark.
+// The result is named after the cell (e.g. cell3) or the name in the arguments and compared with %benchcmp.
+func runBench(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	fs := flag.NewFlagSet(m.String(), flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	count := fs.Int("count", 1, "the number of times to run the benchmark")
+	if err := fs.Parse(strings.Fields(m.Args)); err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	if *count < 1 {
+		return fmt.Errorf("%s: -count must be positive", m)
+	}
+	res := &benchResult{name: fmt.Sprintf("cell%d", rn.execCount)}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		res.name = fs.Arg(0)
+	default:
+		return fmt.Errorf("%s: too many arguments: %s", m, strings.Join(fs.Args(), " "))
+	}
+	run := func(f func(n int)) {
+		res.samples = benchmark(f, *count)
+	}
+	ctx.Context = context.WithValue(ctx.Context, benchContextKey, run)
+	if err := rn.runCode(ctx, benchCode(m.Body), nil); err != nil {
+		return err
+	}
+	if len(res.samples) == 0 {
+		return nil
+	}
+	for _, r := range res.samples {
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", res.name, r.String(), r.MemString())
+	}
+	rn.benchResults = append(rn.benchResults, res)
+	return nil
+}
+
+// runBenchCmp implements %benchcmp, which compares the results of two %%bench cells.
+// %benchcmp compares the last two results if names are not specified.
+func runBenchCmp(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	args := strings.Fields(m.Args)
+	var old, cur *benchResult
+	switch len(args) {
+	case 0:
+		if len(rn.benchResults) < 2 {
+			return fmt.Errorf("%s: run %%%%bench twice to compare results", m)
+		}
+		old, cur = rn.benchResults[len(rn.benchResults)-2], rn.benchResults[len(rn.benchResults)-1]
+	case 2:
+		old, cur = rn.findBenchResult(args[0]), rn.findBenchResult(args[1])
+		for i, r := range []*benchResult{old, cur} {
+			if r == nil {
+				return fmt.Errorf("%s: benchmark %q not found", m, args[i])
+			}
+		}
+	default:
+		return fmt.Errorf("usage: %s [old new]", m)
+	}
+	fmt.Fprint(os.Stdout, formatBenchCmp(old, cur))
+	return nil
+}
+
+// findBenchResult returns the latest result named name.
+func (rn *LgoRunner) findBenchResult(name string) *benchResult {
+	for i := len(rn.benchResults) - 1; i >= 0; i-- {
+		if rn.benchResults[i].name == name {
+			return rn.benchResults[i]
+		}
+	}
+	return nil
+}
+
+// benchMetric is a metric of benchmarks compared by %benchcmp.
+type benchMetric struct {
+	name   string
+	value  func(r testing.BenchmarkResult) float64
+	format func(v float64) string
+}
+
+var benchMetrics = []benchMetric{
+	{"time/op", func(r testing.BenchmarkResult) float64 { return float64(r.T.Nanoseconds()) / float64(r.N) }, formatNanos},
+	{"alloc/op", func(r testing.BenchmarkResult) float64 { return float64(r.AllocedBytesPerOp()) }, formatBytes},
+	{"allocs/op", func(r testing.BenchmarkResult) float64 { return float64(r.AllocsPerOp()) }, formatCount},
+}
+
+// summarize returns the mean of values of samples and the maximum deviation from the mean in percent.
+func summarize(samples []testing.BenchmarkResult, value func(r testing.BenchmarkResult) float64) (mean, diff float64) {
+	for _, s := range samples {
+		mean += value(s)
+	}
+	mean /= float64(len(samples))
+	if mean == 0 {
+		return 0, 0
+	}
+	for _, s := range samples {
+		diff = math.Max(diff, math.Abs(value(s)-mean)/mean*100)
+	}
+	return mean, diff
+}
+
+func formatSummary(mean, diff float64, format func(float64) string) string {
+	s := format(mean)
+	if diff > 0 {
+		s += fmt.Sprintf(" ± %.0f%%", diff)
+	}
+	return s
+}
+
+// formatBenchCmp formats the comparison of two results in a table like benchstat.
+func formatBenchCmp(old, cur *benchResult) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\tdelta\n", old.name, cur.name)
+	for _, mt := range benchMetrics {
+		om, od := summarize(old.samples, mt.value)
+		nm, nd := summarize(cur.samples, mt.value)
+		delta := "~"
+		if om != 0 {
+			delta = fmt.Sprintf("%+.2f%%", (nm-om)/om*100)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", mt.name, formatSummary(om, od, mt.format), formatSummary(nm, nd, mt.format), delta)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// formatScaled formats v with the largest unit in units that keeps v >= 1. base is the ratio of adjacent units.
+func formatScaled(v, base float64, units []string) string {
+	i := 0
+	for ; i < len(units)-1 && math.Abs(v) >= base; i++ {
+		v /= base
+	}
+	return fmt.Sprintf("%.3g%s", v, units[i])
+}
+
+func formatNanos(v float64) string {
+	return formatScaled(v, 1000, []string{"ns", "µs", "ms", "s"})
+}
+
+func formatBytes(v float64) string {
+	return formatScaled(v, 1000, []string{"B", "kB", "MB", "GB"})
+}
+
+func formatCount(v float64) string {
+	return fmt.Sprintf("%.0f", v)
+}
@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchCode(t *testing.T) {
+	got := benchCode("\nx++")
+	want := `_ctx.Value("github.com/yunabe/lgo/cmd/runner.bench").(func(func(int)))(func(lgoBenchN int) { for lgoBenchI := 0; lgoBenchI < lgoBenchN; lgoBenchI++ {
+x++
+}})`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmarks take seconds")
+	}
+	var total int
+	results := benchmark(func(n int) {
+		for i := 0; i < n; i++ {
+			total++
+		}
+	}, 2)
+	if len(results) != 2 {
+		t.Fatalf("got %d results; want 2", len(results))
+	}
+	if results[0].N == 0 {
+		t.Errorf("N is not selected: %+v", results[0])
+	}
+}
+
+func TestBenchmarkPanic(t *testing.T) {
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("got %v; want boom", p)
+		}
+	}()
+	benchmark(func(n int) { panic("boom") }, 3)
+	t.Error("benchmark did not panic")
+}
+
+func TestFormatBenchCmp(t *testing.T) {
+	old := &benchResult{name: "cell3", samples: []testing.BenchmarkResult{
+		{N: 1000, T: 2 * time.Millisecond, MemAllocs: 2000, MemBytes: 64000},
+		{N: 1000, T: 2 * time.Millisecond, MemAllocs: 2000, MemBytes: 64000},
+	}}
+	cur := &benchResult{name: "fast", samples: []testing.BenchmarkResult{
+		{N: 1000, T: 900 * time.Microsecond},
+		{N: 1000, T: 1100 * time.Microsecond},
+	}}
+	want := strings.Join([]string{
+		"           cell3  fast       delta",
+		"time/op    2µs    1µs ± 10%  -50.00%",
+		"alloc/op   64B    0B         -100.00%",
+		"allocs/op  2      0          -100.00%",
+		"",
+	}, "\n")
+	if got := formatBenchCmp(old, cur); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatScaled(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{formatNanos(0.25), "0.25ns"},
+		{formatNanos(1500), "1.5µs"},
+		{formatNanos(2.5e9), "2.5s"},
+		{formatBytes(999), "999B"},
+		{formatBytes(12345678), "12.3MB"},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("got %q; want %q", tc.got, tc.want)
+		}
+	}
+}
@@ -33,8 +33,12 @@ func cleanSharedLibs(lgopath string, sessID *SessionID) error {
 func CleanSession(lgopath string, sessID *SessionID) error {
 	srcErr := os.RemoveAll(path.Join(build.Default.GOPATH, "src/github.com/yunabe/lgo", sessID.Marshal()))
 	soErr := cleanSharedLibs(lgopath, sessID)
+	modErr := os.RemoveAll(moduleDir(lgopath, sessID))
 	if srcErr != nil {
 		return srcErr
 	}
-	return soErr
+	if soErr != nil {
+		return soErr
+	}
+	return modErr
 }
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// displayMagics maps the names of cell magics that show the bodies of cells to methods of DataDisplayer.
+var displayMagics = map[string]func(d core.DataDisplayer, s string){
+	"html":       func(d core.DataDisplayer, s string) { d.HTML(s, nil) },
+	"markdown":   func(d core.DataDisplayer, s string) { d.Markdown(s, nil) },
+	"svg":        func(d core.DataDisplayer, s string) { d.SVG(s, nil) },
+	"javascript": func(d core.DataDisplayer, s string) { d.JavaScript(s, nil) },
+	"latex":      func(d core.DataDisplayer, s string) { d.Latex(s, nil) },
+}
+
+// expandTemplate expands body as a Go template with values of variables in the session (e.g. {{.x}}).
+// Values in %%html are escaped with html/template.
+func expandTemplate(name, body string, vars map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if name == "html" {
+		tmpl, err := htmltemplate.New(name).Option("missingkey=error").Parse(body)
+		if err != nil {
+			return "", err
+		}
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// runDisplay implements cell magics that show the bodies of cells (e.g. %%html) without compiling them.
+// Bodies are expanded as Go templates with variables in the session unless -raw is specified.
+func runDisplay(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	fs := flag.NewFlagSet(m.String(), flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	raw := fs.Bool("raw", false, "show the body without expanding it as a template")
+	if err := fs.Parse(strings.Fields(m.Args)); err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%s: unexpected arguments: %s", m, strings.Join(fs.Args(), " "))
+	}
+	body := m.Body
+	if !*raw {
+		var err error
+		if body, err = expandTemplate(m.Name, body, core.VarValues()); err != nil {
+			return fmt.Errorf("%s: %v", m, err)
+		}
+	}
+	if ctx.Display == nil {
+		// The interactive shell can not render the content. Print it as is.
+		fmt.Fprintln(os.Stdout, body)
+		return nil
+	}
+	displayMagics[m.Name](ctx.Display, body)
+	return nil
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// htmlDisplayer records contents shown with HTML and SVG.
+type htmlDisplayer struct {
+	core.DataDisplayer
+	shown []string
+}
+
+func (d *htmlDisplayer) HTML(s string, id *string) { d.shown = append(d.shown, "html:"+s) }
+func (d *htmlDisplayer) SVG(s string, id *string)  { d.shown = append(d.shown, "svg:"+s) }
+
+func TestRunDisplay(t *testing.T) {
+	name := "<b>lgo</b>"
+	core.LgoRegisterVar("LgoExport_displayTestName", &name)
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"%%html\n<p>{{.displayTestName}}</p>", "html:<p>&lt;b&gt;lgo&lt;/b&gt;</p>"},
+		{"%%svg\n<text>{{.displayTestName}}</text>", "svg:<text><b>lgo</b></text>"},
+		{"%%html -raw\n<p>{{.displayTestName}}</p>", "html:<p>{{.displayTestName}}</p>"},
+	}
+	for _, tc := range tests {
+		d := &htmlDisplayer{}
+		m := parseMagic(tc.src)
+		if err := runDisplay(nil, core.LgoContext{Display: d}, m); err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if len(d.shown) != 1 || d.shown[0] != tc.want {
+			t.Errorf("%q: got %q; want %q", tc.src, d.shown, tc.want)
+		}
+	}
+}
+
+func TestRunDisplayError(t *testing.T) {
+	for _, src := range []string{
+		"%%html -foo\n<p></p>",
+		"%%html\n{{.noSuchVariable}}",
+		"%%markdown\n{{if}}",
+	} {
+		if err := runDisplay(nil, core.LgoContext{Display: &htmlDisplayer{}}, parseMagic(src)); err == nil {
+			t.Errorf("%q: runDisplay succeeded unexpectedly", src)
+		}
+	}
+}
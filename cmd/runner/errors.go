@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"fmt"
+	"go/scanner"
+	"io"
+	"strings"
+
+	"github.com/yunabe/lgo/converter"
+	"github.com/yunabe/lgo/core"
+)
+
+const maxErrLines = 5
+
+// BuildError is returned from LgoRunner.Run when lgo fails to build converted code or its dependencies.
+type BuildError struct {
+	// Output is the output of the build command.
+	Output string
+	Err    error
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+// Names of kinds of errors returned from ErrorName.
+const (
+	// CompileErrorName is the name of errors in lgo code detected by the parser or the type checker.
+	CompileErrorName = "CompileError"
+	// BuildErrorName is the name of failures of building converted code.
+	BuildErrorName = "BuildError"
+	// PanicErrorName is the name of executions that panicked.
+	PanicErrorName = "Panic"
+	// CanceledErrorName is the name of executions canceled by users or limits.
+	CanceledErrorName = "Canceled"
+	// UnknownErrorName is the name of other errors.
+	UnknownErrorName = "Error"
+)
+
+// ErrorName returns the name of the kind of err returned from LgoRunner.Run.
+func ErrorName(err error) string {
+	switch err := err.(type) {
+	case scanner.ErrorList, converter.ErrorList:
+		return CompileErrorName
+	case *BuildError:
+		return BuildErrorName
+	case *core.ExecError:
+		if err.Canceled() {
+			return CanceledErrorName
+		}
+		return PanicErrorName
+	}
+	return UnknownErrorName
+}
+
+// ErrorLines returns lines that describe err in detail.
+// If err is scanner.ErrorList or convert.ErrorList, it expands internal errors.
+// If err is *core.ExecError, it includes stack traces of panics.
+func ErrorLines(err error) []string {
+	var length int
+	var get func(int) error
+	switch e := err.(type) {
+	case scanner.ErrorList:
+		length = len(e)
+		get = func(i int) error { return e[i] }
+	case converter.ErrorList:
+		length = len(e)
+		get = func(i int) error { return e[i] }
+	case *BuildError:
+		return append(splitLines(e.Output), e.Error())
+	case *core.ExecError:
+		var lines []string
+		for _, p := range e.Panics {
+			lines = append(lines, "panic: "+p.Value, "")
+			lines = append(lines, splitLines(p.Stack)...)
+			lines = append(lines, "")
+		}
+		return append(lines, e.Error())
+	default:
+		return []string{err.Error()}
+	}
+	var lines []string
+	for i := 0; i < maxErrLines && i < length; i++ {
+		msg := get(i).Error()
+		if i == maxErrLines-1 && i != length-1 {
+			msg += fmt.Sprintf(" (and %d more errors)", length-1-i)
+		}
+		lines = append(lines, msg)
+	}
+	return lines
+}
+
+// ErrorValue returns a short description of err.
+// For compile errors, it is the first error. For panics, it is the value of the first panic.
+func ErrorValue(err error) string {
+	switch e := err.(type) {
+	case scanner.ErrorList:
+		if len(e) > 0 {
+			return e[0].Error()
+		}
+	case converter.ErrorList:
+		if len(e) > 0 {
+			return e[0].Error()
+		}
+	case *core.ExecError:
+		if len(e.Panics) > 0 {
+			return e.Panics[0].Value
+		}
+	}
+	return err.Error()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// PrintError prints err to w. PrintError prints lines returned from ErrorLines.
+func PrintError(w io.Writer, err error) {
+	for _, line := range ErrorLines(err) {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"errors"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+func TestErrorNameAndLines(t *testing.T) {
+	var compileErr scanner.ErrorList
+	for i := 1; i <= 7; i++ {
+		compileErr.Add(token.Position{Line: i, Column: 1}, "undefined: x")
+	}
+	tests := []struct {
+		err   error
+		name  string
+		value string
+		lines []string
+	}{
+		{
+			err:   compileErr,
+			name:  CompileErrorName,
+			value: "1:1: undefined: x",
+			lines: []string{
+				"1:1: undefined: x",
+				"2:1: undefined: x",
+				"3:1: undefined: x",
+				"4:1: undefined: x",
+				"5:1: undefined: x (and 2 more errors)",
+			},
+		}, {
+			err:   &BuildError{Output: "ld: error\n", Err: errors.New("Failed to build")},
+			name:  BuildErrorName,
+			value: "Failed to build",
+			lines: []string{"ld: error", "Failed to build"},
+		}, {
+			err: &core.ExecError{
+				Panics:  []core.PanicInfo{{Value: "fail", Stack: "goroutine 1 [running]:\nmain.f()\n"}},
+				Message: "main routine failed",
+			},
+			name:  PanicErrorName,
+			value: "fail",
+			lines: []string{"panic: fail", "", "goroutine 1 [running]:", "main.f()", "", "main routine failed"},
+		}, {
+			err:   &core.ExecError{Message: "main routine canceled"},
+			name:  CanceledErrorName,
+			value: "main routine canceled",
+			lines: []string{"main routine canceled"},
+		}, {
+			err:   errors.New("unknown"),
+			name:  UnknownErrorName,
+			value: "unknown",
+			lines: []string{"unknown"},
+		},
+	}
+	for _, tc := range tests {
+		if got := ErrorName(tc.err); got != tc.name {
+			t.Errorf("ErrorName(%v) = %q; want %q", tc.err, got, tc.name)
+		}
+		if got := ErrorValue(tc.err); got != tc.value {
+			t.Errorf("ErrorValue(%v) = %q; want %q", tc.err, got, tc.value)
+		}
+		if got := ErrorLines(tc.err); !reflect.DeepEqual(got, tc.lines) {
+			t.Errorf("ErrorLines(%v) = %#v; want %#v", tc.err, got, tc.lines)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+// journalCellHeader is the line that starts each cell in a session journal.
+const journalCellHeader = "// lgo:cell "
+
+// SetJournal makes rn record lgo code executed successfully to w.
+// The journal is read by ReadJournal to export the session with lgo export.
+func (rn *LgoRunner) SetJournal(w io.Writer) {
+	rn.journal = w
+}
+
+// record writes src executed successfully to the journal.
+func (rn *LgoRunner) record(src string) {
+	if rn.journal == nil {
+		return
+	}
+	src = strings.TrimRight(src, " \t\r\n")
+	if _, err := fmt.Fprintf(rn.journal, "%s%d\n%s\n", journalCellHeader, rn.execCount, src); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the journal: %v\n", err)
+		rn.journal = nil
+	}
+}
+
+// ReadJournal reads cells recorded in a session journal.
+func ReadJournal(r io.Reader) ([]string, error) {
+	var cells []string
+	var cur []string
+	inCell := false
+	flush := func() {
+		if inCell {
+			cells = append(cells, strings.Join(cur, "\n"))
+		}
+		cur = nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, journalCellHeader) {
+			flush()
+			inCell = true
+			continue
+		}
+		if !inCell {
+			return nil, fmt.Errorf("not an lgo journal: the first line must start with %q", journalCellHeader)
+		}
+		cur = append(cur, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return cells, nil
+}
+
+// notebookSource is the source of a cell in .ipynb, which is a string or a list of lines.
+type notebookSource string
+
+func (s *notebookSource) UnmarshalJSON(b []byte) error {
+	var lines []string
+	if err := json.Unmarshal(b, &lines); err == nil {
+		*s = notebookSource(strings.Join(lines, ""))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	*s = notebookSource(str)
+	return nil
+}
+
+type notebookCell struct {
+	CellType       string         `json:"cell_type"`
+	ExecutionCount *int           `json:"execution_count"`
+	Source         notebookSource `json:"source"`
+	Outputs        []struct {
+		OutputType string `json:"output_type"`
+	} `json:"outputs"`
+}
+
+// ReadNotebook reads code cells executed successfully in a Jupyter notebook (.ipynb) in the order of executions.
+// Cells that were not executed or failed are skipped.
+func ReadNotebook(r io.Reader) ([]string, error) {
+	var nb struct {
+		Cells []notebookCell `json:"cells"`
+	}
+	if err := json.NewDecoder(r).Decode(&nb); err != nil {
+		return nil, fmt.Errorf("failed to parse the notebook: %v", err)
+	}
+	var executed []notebookCell
+	for _, c := range nb.Cells {
+		if c.CellType != "code" || c.ExecutionCount == nil {
+			continue
+		}
+		failed := false
+		for _, out := range c.Outputs {
+			if out.OutputType == "error" {
+				failed = true
+			}
+		}
+		if !failed {
+			executed = append(executed, c)
+		}
+	}
+	sort.SliceStable(executed, func(i, j int) bool {
+		return *executed[i].ExecutionCount < *executed[j].ExecutionCount
+	})
+	var cells []string
+	for _, c := range executed {
+		cells = append(cells, string(c.Source))
+	}
+	return cells, nil
+}
+
+// exportableCells returns cells that consist of lgo code and requirements of modules declared with %require.
+// Other magic commands and shell commands are skipped with warnings written to warn because they can not be
+// converted into Go.
+func exportableCells(cells []string, warn io.Writer) (code []string, reqs []requirement) {
+	for i, src := range cells {
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+		if m := parseMagic(src); m != nil {
+			if m.Name == "require" && !m.Cell && m.Args != "" {
+				if r, err := parseRequirements(m.Args); err == nil {
+					reqs = append(reqs, r...)
+					continue
+				}
+			}
+			fmt.Fprintf(warn, "Skipped cell %d: %s is not exported\n", i+1, m)
+			continue
+		}
+		if parseShellCapture(src) != nil || parseShellEscapes(src) != nil {
+			fmt.Fprintf(warn, "Skipped cell %d: shell commands are not exported\n", i+1)
+			continue
+		}
+		code = append(code, src)
+	}
+	return code, reqs
+}
+
+var goVersionPattern = regexp.MustCompile(`^go(\d+\.\d+)`)
+
+// goMod returns the content of go.mod of the exported program named module.
+func goMod(module string) string {
+	mod := "module " + module + "\n"
+	if m := goVersionPattern.FindStringSubmatch(runtime.Version()); m != nil {
+		mod += "\ngo " + m[1] + "\n"
+	}
+	return mod
+}
+
+// isStdPackage returns true if path looks like a package in the standard library.
+func isStdPackage(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+// Export converts an lgo session journal or a Jupyter notebook (.ipynb) at path into a standalone Go program
+// and writes main.go and go.mod to outDir. go.mod is not overwritten if it exists.
+// Cells that can not be exported are reported to warn.
+func Export(path, outDir string, warn io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var cells []string
+	if strings.HasSuffix(path, ".ipynb") {
+		cells, err = ReadNotebook(f)
+	} else {
+		cells, err = ReadJournal(f)
+	}
+	if err != nil {
+		return err
+	}
+	code, reqs := exportableCells(cells, warn)
+	src, deps, err := converter.Export(code)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, "main.go"), []byte(src), 0644); err != nil {
+		return err
+	}
+	modFile := filepath.Join(outDir, "go.mod")
+	if _, err := os.Stat(modFile); os.IsNotExist(err) {
+		abs, err := filepath.Abs(outDir)
+		if err != nil {
+			return err
+		}
+		requires, replaces := make(map[string]string), make(map[string]string)
+		for _, r := range reqs {
+			if r.Replace != "" {
+				replaces[r.Path] = r.Replace
+				if _, ok := requires[r.Path]; !ok {
+					requires[r.Path] = localReplacementVersion
+				}
+			} else {
+				requires[r.Path] = r.Version
+			}
+		}
+		if err := ioutil.WriteFile(modFile, []byte(formatGoMod(filepath.Base(abs), requires, replaces)), 0644); err != nil {
+			return err
+		}
+	} else if len(reqs) > 0 {
+		fmt.Fprintf(warn, "%s exists. Add requirements of %%require to it: %v\n", modFile, reqs)
+	}
+	var external []string
+	for _, dep := range deps {
+		if !isStdPackage(dep) {
+			external = append(external, dep)
+		}
+	}
+	if len(external) > 0 {
+		fmt.Fprintf(warn, "Run `go mod tidy` in %s to add requirements of %s\n", outDir, strings.Join(external, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	var buf bytes.Buffer
+	rn := &LgoRunner{journal: &buf}
+	for i, src := range []string{"x := 10\n", "func f() {\n\n}", ""} {
+		rn.execCount = int64(i + 1)
+		rn.record(src)
+	}
+	want := "// lgo:cell 1\nx := 10\n// lgo:cell 2\nfunc f() {\n\n}\n// lgo:cell 3\n\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	cells, err := ReadJournal(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x := 10", "func f() {\n\n}", ""}; !reflect.DeepEqual(cells, want) {
+		t.Errorf("got %q; want %q", cells, want)
+	}
+}
+
+func TestReadJournal_invalid(t *testing.T) {
+	if _, err := ReadJournal(strings.NewReader("x := 10\n")); err == nil {
+		t.Error("no error for a file that is not a journal")
+	}
+}
+
+func TestReadNotebook(t *testing.T) {
+	nb := `{
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# title"]},
+  {"cell_type": "code", "execution_count": 3, "metadata": {}, "outputs": [], "source": ["y := x * 2\n", "y"]},
+  {"cell_type": "code", "execution_count": 1, "metadata": {}, "outputs": [], "source": "x := 10"},
+  {"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["z := 1"]},
+  {"cell_type": "code", "execution_count": 2, "metadata": {}, "outputs": [{"output_type": "error", "ename": "ERROR"}], "source": ["x := undefined"]}
+ ],
+ "nbformat": 4,
+ "nbformat_minor": 2
+}`
+	cells, err := ReadNotebook(strings.NewReader(nb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x := 10", "y := x * 2\ny"}; !reflect.DeepEqual(cells, want) {
+		t.Errorf("got %q; want %q", cells, want)
+	}
+}
+
+func TestExportableCells(t *testing.T) {
+	var warn bytes.Buffer
+	got, reqs := exportableCells([]string{"x := 10", "%%time\nf()", "!ls", "files := !ls", "  \n", "%require example.com/m@v1.2.0", "y := x"}, &warn)
+	if want := []string{"x := 10", "y := x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if want := []requirement{{Path: "example.com/m", Version: "v1.2.0"}}; !reflect.DeepEqual(reqs, want) {
+		t.Errorf("got %v; want %v", reqs, want)
+	}
+	want := "Skipped cell 2: %%time is not exported\nSkipped cell 3: shell commands are not exported\nSkipped cell 4: shell commands are not exported\n"
+	if warn.String() != want {
+		t.Errorf("got %q; want %q", warn.String(), want)
+	}
+}
+
+func TestIsStdPackage(t *testing.T) {
+	for path, want := range map[string]bool{
+		"fmt":                        true,
+		"net/http":                   true,
+		"github.com/yunabe/lgo/core": false,
+		"golang.org/x/sys/unix":      false,
+	} {
+		if got := isStdPackage(path); got != want {
+			t.Errorf("isStdPackage(%q) = %v; want %v", path, got, want)
+		}
+	}
+}
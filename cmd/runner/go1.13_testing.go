@@ -0,0 +1,10 @@
+// +build go1.13
+
+package runner
+
+import "testing"
+
+// initTesting registers the flags of the testing package, which are registered by testing.Init since go1.13.
+func initTesting() {
+	testing.Init()
+}
@@ -0,0 +1,7 @@
+// +build !go1.13
+
+package runner
+
+func initTesting() {
+	// Do nothing before go1.13, which registers the flags of the testing package when it is initialized.
+}
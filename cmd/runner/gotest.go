@@ -0,0 +1,407 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/parser"
+)
+
+// testContextKey is the key of the context value that runs tests in %test.
+// Code generated for %test passes test functions to the value without importing packages.
+const testContextKey = "github.com/yunabe/lgo/cmd/runner.test"
+
+// exampleOutput is the expected output of an example function written in "// Output:" comment.
+type exampleOutput struct {
+	output    string
+	unordered bool
+}
+
+// exampleOutputs returns expected outputs of example functions declared in src.
+// Examples without output comments are not included.
+func exampleOutputs(src string) map[string]exampleOutput {
+	blk, err := parser.ParseLesserGoFile(token.NewFileSet(), "", src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	outputs := make(map[string]exampleOutput)
+	for _, stmt := range blk.Stmts {
+		decl, ok := stmt.(*ast.DeclStmt)
+		if !ok {
+			continue
+		}
+		fn, ok := decl.Decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || !isTestName(fn.Name.Name, "Example") {
+			continue
+		}
+		// Like go test, the last comment in the body is the expected output.
+		var last *ast.CommentGroup
+		for _, c := range blk.Comments {
+			if c.Pos() > fn.Body.Lbrace && c.End() < fn.Body.Rbrace {
+				last = c
+			}
+		}
+		if last == nil {
+			continue
+		}
+		text := last.Text()
+		for _, prefix := range []string{"Output:", "Unordered output:"} {
+			if strings.HasPrefix(strings.ToLower(text), strings.ToLower(prefix)) {
+				outputs[fn.Name.Name] = exampleOutput{
+					output:    strings.TrimSpace(text[len(prefix):]),
+					unordered: prefix != "Output:",
+				}
+				break
+			}
+		}
+	}
+	return outputs
+}
+
+// isTestName reports whether name is the name of a test function with prefix (e.g. TestParse, but not Testparse).
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// testKind returns the kind of the test function of obj ("Test", "Benchmark" or "Example").
+// testKind returns "" if obj is not a test function.
+func testKind(obj types.Object) string {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return ""
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() != nil || sig.Results().Len() != 0 {
+		return ""
+	}
+	params := sig.Params()
+	switch {
+	case isTestName(fn.Name(), "Test"):
+		if params.Len() == 1 && types.TypeString(params.At(0).Type(), nil) == "*testing.T" {
+			return "Test"
+		}
+	case isTestName(fn.Name(), "Benchmark"):
+		if params.Len() == 1 && types.TypeString(params.At(0).Type(), nil) == "*testing.B" {
+			return "Benchmark"
+		}
+	case isTestName(fn.Name(), "Example"):
+		if params.Len() == 0 {
+			return "Example"
+		}
+	}
+	return ""
+}
+
+// testFuncs returns the sorted names of test functions declared in the session whose names match pattern.
+// Benchmarks are included only if bench is true.
+func (rn *LgoRunner) testFuncs(pattern *regexp.Regexp, bench bool) []string {
+	var names []string
+	for name, obj := range rn.vars {
+		kind := testKind(obj)
+		if kind == "" || (kind == "Benchmark" && !bench) || !pattern.MatchString(name) {
+			continue
+		}
+		if _, ok := rn.examples[name]; kind == "Example" && !ok {
+			// Like go test, examples without output comments are not run.
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// testCode returns lgo code that passes test functions to the function in the context value of testContextKey.
+func testCode(names []string) string {
+	var elems []string
+	for _, name := range names {
+		elems = append(elems, fmt.Sprintf("%q: %s", name, name))
+	}
+	return fmt.Sprintf("_ctx.Value(%q).(func(map[string]interface{}))(map[string]interface{}{%s})",
+		testContextKey, strings.Join(elems, ", "))
+}
+
+// testOutcome is the result of a top-level test function.
+type testOutcome struct {
+	Name    string
+	Status  string
+	Elapsed string
+}
+
+// testResultPattern matches lines that report results of top-level tests in verbose mode (e.g. "--- FAIL: TestA (0.00s)").
+var testResultPattern = regexp.MustCompile(`^--- (PASS|FAIL|SKIP): (\S+) \(([^)]*)\)`)
+
+// testNamePattern matches lines that show which test the following lines belong to.
+var testNamePattern = regexp.MustCompile(`^\s*(?:=== (?:RUN|CONT|NAME|PAUSE)\s+|--- (?:PASS|FAIL|SKIP): )(\S+)`)
+
+// testPositionPattern matches positions in the output of tests (e.g. "src.go:12:").
+// testing shows only the base names of files, which are src.go for all cells.
+var testPositionPattern = regexp.MustCompile(`\bsrc\.go:(\d+)`)
+
+// testOutputRewriter rewrites positions in the output of tests to positions in cells and collects results of tests.
+// Positions are mapped with the cell where the running test is declared.
+type testOutputRewriter struct {
+	rn *LgoRunner
+	// cells maps the names of test functions to the cells where they are declared.
+	cells map[string]int
+	// pkgs maps the names of test functions to the names of packages where they are declared if the packages
+	// are not execN of cells (e.g. exec3_1 of %rerun).
+	pkgs     map[string]string
+	current  string
+	outcomes []testOutcome
+}
+
+func (w *testOutputRewriter) rewriteLine(line string) string {
+	if m := testResultPattern.FindStringSubmatch(line); m != nil {
+		w.outcomes = append(w.outcomes, testOutcome{Name: m[2], Status: m[1], Elapsed: m[3]})
+	}
+	if m := testNamePattern.FindStringSubmatch(line); m != nil {
+		w.current = strings.SplitN(m[1], "/", 2)[0]
+	}
+	cell, ok := w.cells[w.current]
+	if !ok {
+		return line
+	}
+	return testPositionPattern.ReplaceAllStringFunc(line, func(pos string) string {
+		n, err := strconv.Atoi(testPositionPattern.FindStringSubmatch(pos)[1])
+		if err != nil {
+			return pos
+		}
+		pkg, ok := w.pkgs[w.current]
+		if !ok {
+			pkg = fmt.Sprintf("exec%d", cell)
+		}
+		return w.rn.cellPosition(pkg, cell, n)
+	})
+}
+
+// copy copies the output of tests from r to out line by line.
+func (w *testOutputRewriter) copy(out io.Writer, r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			io.WriteString(out, w.rewriteLine(line))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// cellOf returns the cell where obj is declared.
+func cellOf(obj types.Object) int {
+	if obj.Pkg() == nil {
+		return 0
+	}
+	p := obj.Pkg().Path()
+	i := strings.LastIndex(p, "/exec")
+	if i < 0 {
+		return 0
+	}
+	p = p[i+len("/exec"):]
+	if j := strings.IndexByte(p, '_'); j >= 0 {
+		p = p[:j]
+	}
+	n, _ := strconv.Atoi(p)
+	return n
+}
+
+var testingInit sync.Once
+
+// runTestFuncs runs funcs with the testing package. The output is written to os.Stdout with positions in cells.
+func (rn *LgoRunner) runTestFuncs(funcs map[string]interface{}) ([]testOutcome, error) {
+	testingInit.Do(func() {
+		initTesting()
+		// Show results of all tests to render them as pass/fail.
+		flag.Set("test.v", "true")
+	})
+	var names []string
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var tests []testing.InternalTest
+	var benchmarks []testing.InternalBenchmark
+	var examples []testing.InternalExample
+	w := &testOutputRewriter{rn: rn, cells: make(map[string]int), pkgs: make(map[string]string)}
+	for _, name := range names {
+		if obj := rn.vars[name]; obj != nil {
+			w.cells[name] = cellOf(obj)
+			if obj.Pkg() != nil {
+				w.pkgs[name] = path.Base(obj.Pkg().Path())
+			}
+		}
+		switch f := funcs[name].(type) {
+		case func(*testing.T):
+			tests = append(tests, testing.InternalTest{Name: name, F: func(t *testing.T) {
+				defer rn.recoverTest(t)
+				f(t)
+			}})
+		case func(*testing.B):
+			benchmarks = append(benchmarks, testing.InternalBenchmark{Name: name, F: func(b *testing.B) {
+				defer rn.recoverTest(b)
+				f(b)
+			}})
+		case func():
+			out := rn.examples[name]
+			examples = append(examples, testing.InternalExample{Name: name, F: func() {
+				defer func() {
+					if p := recover(); p != nil {
+						fmt.Printf("panic: %v\n", p)
+					}
+				}()
+				f()
+			}, Output: out.output, Unordered: out.unordered})
+		}
+	}
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	orig := os.Stdout
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		w.copy(orig, r)
+		close(done)
+	}()
+	matchAll := func(pat, str string) (bool, error) { return true, nil }
+	testing.RunTests(matchAll, tests)
+	testing.RunExamples(matchAll, examples)
+	if len(benchmarks) > 0 {
+		flag.Set("test.bench", ".")
+		testing.RunBenchmarks(matchAll, benchmarks)
+		flag.Set("test.bench", "")
+	}
+	os.Stdout = orig
+	pw.Close()
+	<-done
+	r.Close()
+	return w.outcomes, nil
+}
+
+// recoverTest reports a panic in a test as a failure instead of crashing the process.
+func (rn *LgoRunner) recoverTest(t testing.TB) {
+	if p := recover(); p != nil {
+		if p == core.Bailout {
+			t.Fatal("canceled")
+		}
+		t.Helper()
+		stack := string(debug.Stack())
+		// Show the stack from the function that panicked. Each frame consists of two lines.
+		lines := strings.Split(stack, "\n")
+		for i, l := range lines {
+			if strings.HasPrefix(l, "panic(") && i+2 <= len(lines) {
+				stack = strings.Join(lines[i+2:], "\n")
+				break
+			}
+		}
+		t.Fatalf("panic: %v\n%s", p, rn.RewriteTraceback(stack))
+	}
+}
+
+// runTest implements %test, which runs Test, Example and Benchmark functions declared in cells with the testing package.
+// %test takes a regular expression to select tests by names. Benchmarks are run only with -bench like go test.
+func runTest(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	fs := flag.NewFlagSet(m.String(), flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	bench := fs.Bool("bench", false, "run benchmarks too")
+	if err := fs.Parse(strings.Fields(m.Args)); err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	pattern := regexp.MustCompile("")
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		var err error
+		if pattern, err = regexp.Compile(fs.Arg(0)); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", m, err)
+		}
+	default:
+		return fmt.Errorf("%s: too many arguments: %s", m, strings.Join(fs.Args(), " "))
+	}
+	names := rn.testFuncs(pattern, *bench)
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stdout, "no tests to run")
+		return nil
+	}
+	var outcomes []testOutcome
+	var runErr error
+	run := func(funcs map[string]interface{}) {
+		outcomes, runErr = rn.runTestFuncs(funcs)
+	}
+	ctx.Context = context.WithValue(ctx.Context, testContextKey, run)
+	if err := rn.runCode(ctx, testCode(names), nil); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return reportTests(ctx, outcomes)
+}
+
+// reportTests shows the summary of tests and returns an error if some tests failed.
+func reportTests(ctx core.LgoContext, outcomes []testOutcome) error {
+	failed := 0
+	for _, o := range outcomes {
+		if o.Status == "FAIL" {
+			failed++
+		}
+	}
+	if ctx.Display != nil {
+		ctx.Display.HTML(testsHTML(outcomes), nil)
+	}
+	if failed > 0 {
+		return fmt.Errorf("FAIL: %d of %d tests failed", failed, len(outcomes))
+	}
+	fmt.Fprintf(os.Stdout, "PASS: %d tests\n", len(outcomes))
+	return nil
+}
+
+var testStatusColors = map[string]string{
+	"PASS": "green",
+	"FAIL": "red",
+	"SKIP": "gray",
+}
+
+// testsHTML renders results of tests as an HTML table.
+func testsHTML(outcomes []testOutcome) string {
+	var buf bytes.Buffer
+	buf.WriteString("<table><thead><tr><th>test</th><th>result</th><th>time</th></tr></thead><tbody>")
+	for _, o := range outcomes {
+		fmt.Fprintf(&buf, "<tr><td style=\"text-align:left\">%s</td><td style=\"color:%s\">%s</td><td>%s</td></tr>",
+			html.EscapeString(o.Name), testStatusColors[o.Status], o.Status, html.EscapeString(o.Elapsed))
+	}
+	buf.WriteString("</tbody></table>")
+	return buf.String()
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+func TestExampleOutputs(t *testing.T) {
+	src := `import "fmt"
+
+func ExampleHello() {
+	fmt.Println("hello")
+	// Output: hello
+}
+
+func ExampleMap() {
+	// comment
+	fmt.Println(1)
+	fmt.Println(2)
+	// Unordered output:
+	// 2
+	// 1
+}
+
+func ExampleNoOutput() {
+	fmt.Println("x")
+}
+
+func Examplelower() {
+	// Output: x
+}
+
+x := 10`
+	got := exampleOutputs(src)
+	want := map[string]exampleOutput{
+		"ExampleHello": {output: "hello"},
+		"ExampleMap":   {output: "2\n1", unordered: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+// testFunc returns a function object named name whose parameters are of types named by params (e.g. "testing.T").
+func testFunc(name string, params ...string) types.Object {
+	testingPkg := types.NewPackage("testing", "testing")
+	var vars []*types.Var
+	for _, p := range params {
+		typ := types.NewNamed(types.NewTypeName(token.NoPos, testingPkg, p, nil), types.NewStruct(nil, nil), nil)
+		vars = append(vars, types.NewParam(token.NoPos, nil, "", types.NewPointer(typ)))
+	}
+	sig := types.NewSignature(nil, types.NewTuple(vars...), nil, false)
+	return types.NewFunc(token.NoPos, types.NewPackage("github.com/yunabe/lgo/sess1/exec4", "lgo_exec"), name, sig)
+}
+
+func TestTestKind(t *testing.T) {
+	tests := []struct {
+		obj  types.Object
+		want string
+	}{
+		{testFunc("TestParse", "T"), "Test"},
+		{testFunc("Test", "T"), "Test"},
+		{testFunc("Testparse", "T"), ""},
+		{testFunc("TestParse", "B"), ""},
+		{testFunc("BenchmarkParse", "B"), "Benchmark"},
+		{testFunc("ExampleParse"), "Example"},
+		{testFunc("ExampleParse", "T"), ""},
+		{testFunc("Parse", "T"), ""},
+		{types.NewVar(token.NoPos, nil, "TestVar", types.Typ[types.Int]), ""},
+	}
+	for _, tc := range tests {
+		if got := testKind(tc.obj); got != tc.want {
+			t.Errorf("testKind(%v) = %q; want %q", tc.obj, got, tc.want)
+		}
+	}
+}
+
+func TestTestFuncs(t *testing.T) {
+	rn := &LgoRunner{
+		vars: map[string]types.Object{
+			"TestA":         testFunc("TestA", "T"),
+			"TestB":         testFunc("TestB", "T"),
+			"BenchmarkA":    testFunc("BenchmarkA", "B"),
+			"ExampleA":      testFunc("ExampleA"),
+			"ExampleNoOut":  testFunc("ExampleNoOut"),
+			"helper":        testFunc("helper", "T"),
+			"TestNotAFunc":  types.NewVar(token.NoPos, nil, "TestNotAFunc", types.Typ[types.Int]),
+			"BenchmarkNoop": testFunc("BenchmarkNoop"),
+		},
+		examples: map[string]exampleOutput{"ExampleA": {output: "a"}},
+	}
+	if got, want := rn.testFuncs(regexp.MustCompile(""), false), []string{"ExampleA", "TestA", "TestB"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got, want := rn.testFuncs(regexp.MustCompile("A$"), true), []string{"BenchmarkA", "ExampleA", "TestA"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got, want := cellOf(rn.vars["TestA"]), 4; got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
+
+func TestTestCode(t *testing.T) {
+	got := testCode([]string{"TestA", "ExampleB"})
+	want := `_ctx.Value("github.com/yunabe/lgo/cmd/runner.test").(func(map[string]interface{}))(map[string]interface{}{"TestA": TestA, "ExampleB": ExampleB})`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestTestOutputRewriter(t *testing.T) {
+	rn := &LgoRunner{
+		sessID:   &SessionID{Time: 1234},
+		lineMaps: make(map[string]converter.LineMap),
+	}
+	rn.setLineMap(rn.sessPkgPrefix()+"exec3", converter.LineMap{0, 0, 1, 2, 0, 5})
+	w := &testOutputRewriter{rn: rn, cells: map[string]int{"TestA": 3}}
+	var got []string
+	for _, line := range []string{
+		"=== RUN   TestA\n",
+		"=== RUN   TestA/sub\n",
+		"    src.go:4: want 1\n",
+		"--- FAIL: TestA (0.01s)\n",
+		"    --- PASS: TestA/sub (0.00s)\n",
+		"=== RUN   TestB\n",
+		"    src.go:4: unknown\n",
+		"--- PASS: TestB (0.00s)\n",
+	} {
+		got = append(got, w.rewriteLine(line))
+	}
+	if got[2] != "    cell 3, line 2: want 1\n" {
+		t.Errorf("got %q", got[2])
+	}
+	if got[6] != "    src.go:4: unknown\n" {
+		t.Errorf("got %q", got[6])
+	}
+	want := []testOutcome{{"TestA", "FAIL", "0.01s"}, {"TestB", "PASS", "0.00s"}}
+	if !reflect.DeepEqual(w.outcomes, want) {
+		t.Errorf("got %+v; want %+v", w.outcomes, want)
+	}
+}
+
+func TestRunTestFuncs(t *testing.T) {
+	rn := &LgoRunner{
+		sessID:   &SessionID{Time: 1234},
+		lineMaps: make(map[string]converter.LineMap),
+		examples: map[string]exampleOutput{"ExampleHello": {output: "hello"}},
+	}
+	outcomes, err := rn.runTestFuncs(map[string]interface{}{
+		"TestPass":     func(t *testing.T) {},
+		"TestFail":     func(t *testing.T) { t.Error("failed") },
+		"TestPanic":    func(t *testing.T) { panic("boom") },
+		"ExampleHello": func() { fmt.Println("hello") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []testOutcome{
+		{"TestFail", "FAIL", ""},
+		{"TestPanic", "FAIL", ""},
+		{"TestPass", "PASS", ""},
+		{"ExampleHello", "PASS", ""},
+	}
+	for i := range outcomes {
+		outcomes[i].Elapsed = ""
+	}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("got %+v; want %+v", outcomes, want)
+	}
+}
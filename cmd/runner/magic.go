@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// magic represents a magic command in a cell.
+// A line magic (e.g. %whos) is a line starting with %. A cell magic (e.g. %%bash) is the first line of a cell
+// starting with %% and the rest of the cell is passed to the magic as Body.
+// Go code never starts with %, so magic commands are distinguished from lgo code unambiguously.
+type magic struct {
+	Name string
+	Cell bool
+	// Args is the rest of the first line.
+	Args string
+	// Body is the lines after the first line. Body is always empty for line magics.
+	Body string
+}
+
+func (m *magic) String() string {
+	if m.Cell {
+		return "%%" + m.Name
+	}
+	return "%" + m.Name
+}
+
+// parseMagic parses src as a magic command. parseMagic returns nil if src is not a magic command.
+func parseMagic(src string) *magic {
+	src = strings.TrimLeft(src, " \t\r\n")
+	if !strings.HasPrefix(src, "%") {
+		return nil
+	}
+	line, body := src, ""
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		line, body = src[:i], src[i+1:]
+	}
+	m := &magic{}
+	if strings.HasPrefix(line, "%%") {
+		m.Cell = true
+		line = line[2:]
+	} else {
+		line = line[1:]
+	}
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		m.Name, m.Args = line[:i], strings.TrimSpace(line[i+1:])
+	} else {
+		m.Name = line
+	}
+	m.Body = body
+	return m
+}
+
+// magicFunc implements a magic command.
+type magicFunc func(rn *LgoRunner, ctx core.LgoContext, m *magic) error
+
+var (
+	// lineMagics and cellMagics are registered in init to avoid an initialization loop
+	// because some magics call LgoRunner.Run.
+	lineMagics map[string]magicFunc
+	cellMagics map[string]magicFunc
+)
+
+func init() {
+	lineMagics = map[string]magicFunc{
+		"whos":     runWhos,
+		"time":     runTime,
+		"benchcmp": runBenchCmp,
+		"test":     runTest,
+		"require":  runRequire,
+		"rerun":    runRerun,
+		"migrate":  runMigrate,
+	}
+	cellMagics = map[string]magicFunc{
+		"bash":   runBash,
+		"time":   runTime,
+		"bench":  runBench,
+		"timeit": runBench,
+	}
+	for name := range profilers {
+		cellMagics[name] = runProfile
+	}
+	for name := range displayMagics {
+		cellMagics[name] = runDisplay
+	}
+}
+
+func (rn *LgoRunner) runMagic(ctx core.LgoContext, m *magic) error {
+	magics := lineMagics
+	if m.Cell {
+		magics = cellMagics
+	}
+	fn := magics[m.Name]
+	if fn == nil {
+		return fmt.Errorf("unknown magic command: %s", m)
+	}
+	if !m.Cell && strings.TrimSpace(m.Body) != "" {
+		return fmt.Errorf("%s can not be used with other code in a cell", m)
+	}
+	return fn(rn, ctx, m)
+}
+
+// runWhos implements %whos, which prints variables defined in the session.
+func runWhos(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	if m.Args != "" {
+		return fmt.Errorf("%s does not take arguments", m)
+	}
+	fmt.Fprint(os.Stdout, core.FormatVars(core.Vars()))
+	return nil
+}
@@ -0,0 +1,25 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagic(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *magic
+	}{
+		{"x := 10", nil},
+		{"%whos", &magic{Name: "whos"}},
+		{"\n  %whos  \n", &magic{Name: "whos", Body: ""}},
+		{"%time  f(x) ", &magic{Name: "time", Args: "f(x)"}},
+		{"%%bash -e\necho hello\n", &magic{Name: "bash", Cell: true, Args: "-e", Body: "echo hello\n"}},
+	}
+	for _, tc := range tests {
+		got := parseMagic(tc.src)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseMagic(%q) = %+v; want %+v", tc.src, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/converter"
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/parser"
+)
+
+// typeSource is the source of a type declared in the session and the sources of its methods.
+// Go does not allow declaring methods of types in other packages. To add methods to a type declared in a previous
+// cell, the type is declared again with all of its methods in the package of the current cell.
+type typeSource struct {
+	decl string
+	// methods maps names of methods to their sources.
+	methods map[string]string
+}
+
+// declSources returns sources of types declared in src and sources of methods declared in src.
+// The methods are keyed by the names of their receiver types and then by their names.
+func declSources(src string) (decls map[string]string, methods map[string]map[string]string) {
+	decls = make(map[string]string)
+	methods = make(map[string]map[string]string)
+	fset := token.NewFileSet()
+	blk, err := parser.ParseLesserGoFile(fset, "", src, 0)
+	if err != nil {
+		return
+	}
+	text := func(n ast.Node) string {
+		return src[fset.Position(n.Pos()).Offset:fset.Position(n.End()).Offset]
+	}
+	for _, stmt := range blk.Stmts {
+		decl, ok := stmt.(*ast.DeclStmt)
+		if !ok {
+			continue
+		}
+		switch d := decl.Decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				// Aliases do not have their own methods.
+				if spec := spec.(*ast.TypeSpec); !spec.Assign.IsValid() {
+					decls[spec.Name.Name] = "type " + text(spec)
+				}
+			}
+		case *ast.FuncDecl:
+			recv := receiverTypeName(d)
+			if recv == "" {
+				continue
+			}
+			if methods[recv] == nil {
+				methods[recv] = make(map[string]string)
+			}
+			methods[recv][d.Name.Name] = text(d)
+		}
+	}
+	return
+}
+
+// receiverTypeName returns the name of the receiver type of fn or "" if fn is not a method.
+func receiverTypeName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return ""
+	}
+	typ := fn.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.ParenExpr:
+			typ = t.X
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// recordTypeSources records sources of types declared in src and their methods.
+func (rn *LgoRunner) recordTypeSources(src string) {
+	decls, methods := declSources(src)
+	if len(decls) > 0 && rn.typeSrcs == nil {
+		rn.typeSrcs = make(map[string]*typeSource)
+	}
+	for name, decl := range decls {
+		rn.typeSrcs[name] = &typeSource{decl: decl, methods: methods[name]}
+	}
+}
+
+// extendTypes returns names of types declared in previous cells that src declares methods of and the source
+// that declares the types again with their methods declared previously. Methods declared in src replace
+// the methods of the same names.
+func (rn *LgoRunner) extendTypes(src string) (extended []string, extra string) {
+	decls, methods := declSources(src)
+	var parts []string
+	for _, name := range sortedMethodRecvs(methods) {
+		if _, ok := decls[name]; ok {
+			continue
+		}
+		ts := rn.typeSrcs[name]
+		if tn, ok := rn.vars[name].(*types.TypeName); !ok || ts == nil || !rn.isSessionPackage(tn.Pkg()) {
+			continue
+		}
+		extended = append(extended, name)
+		parts = append(parts, ts.decl)
+		for _, m := range sortedKeys(ts.methods) {
+			if _, ok := methods[name][m]; !ok {
+				parts = append(parts, ts.methods[m])
+			}
+		}
+	}
+	return extended, strings.Join(parts, "\n\n")
+}
+
+func sortedMethodRecvs(methods map[string]map[string]string) []string {
+	var names []string
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dropExtraLines drops lines of code appended to src by extendTypes from m so that they are not reported as lines
+// in the cell.
+func dropExtraLines(m converter.LineMap, src string) {
+	lines := strings.Count(src, "\n") + 1
+	for i, line := range m {
+		if line > lines {
+			m[i] = 0
+		}
+	}
+}
+
+// rebindVars converts variables marked stale by adding methods to extended types to the new definitions of
+// the types. Other definitions that still refer to the old definitions are reported.
+func (rn *LgoRunner) rebindVars(ctx core.LgoContext, extended, marked []string) error {
+	var vars, others []string
+	for _, name := range marked {
+		if v, ok := rn.vars[name].(*types.Var); ok && rn.checkMigratable(v.Type()) == nil {
+			vars = append(vars, name)
+		} else {
+			others = append(others, fmt.Sprintf("%s (%s)", name, objectKind(rn.vars[name])))
+		}
+	}
+	if len(vars) > 0 {
+		if rn.subExec == 0 {
+			defer func() { rn.subExec = 0 }()
+		}
+		rn.subExec++
+		// The conversion is not recorded in the journal because types are not redefined by adding methods in
+		// programs exported by lgo export.
+		if _, err := rn.migrateVars(ctx, vars); err != nil {
+			return fmt.Errorf("failed to convert variables to %s with new methods: %v", strings.Join(extended, ", "), err)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(os.Stderr, "Added methods to %s. These still refer to the definitions without the methods: %s\n"+
+			"Run %%rerun to execute their cells again.\n", strings.Join(extended, ", "), strings.Join(others, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+func TestDeclSources(t *testing.T) {
+	decls, methods := declSources(`type (
+	Point struct{ X, Y int }
+	Alias = Point
+)
+func (p *Point) Scale(k int) { p.X *= k; p.Y *= k }
+func (p Point) String() string { return "point" }
+func norm(p Point) int { return p.X }
+x := 10`)
+	if want := map[string]string{"Point": "type Point struct{ X, Y int }"}; !reflect.DeepEqual(decls, want) {
+		t.Errorf("got %q; want %q", decls, want)
+	}
+	want := map[string]map[string]string{
+		"Point": {
+			"Scale":  "func (p *Point) Scale(k int) { p.X *= k; p.Y *= k }",
+			"String": `func (p Point) String() string { return "point" }`,
+		},
+	}
+	if !reflect.DeepEqual(methods, want) {
+		t.Errorf("got %q; want %q", methods, want)
+	}
+}
+
+func TestExtendTypes(t *testing.T) {
+	rn := &LgoRunner{sessID: &SessionID{Time: 1}, vars: make(map[string]types.Object)}
+	pkg := types.NewPackage(rn.sessPkgPrefix()+"exec1", "lgo_exec")
+	rn.vars["Point"] = types.NewTypeName(token.NoPos, pkg, "Point", nil)
+	rn.vars["x"] = types.NewVar(token.NoPos, pkg, "x", types.Typ[types.Int])
+	rn.recordTypeSources("type Point struct{ X int }\nfunc (p Point) Get() int { return p.X }\nfunc (p Point) String() string { return \"\" }")
+
+	extended, extra := rn.extendTypes("func (p Point) String() string { return \"p\" }\nfunc (p *Point) Set(x int) { p.X = x }")
+	if want := []string{"Point"}; !reflect.DeepEqual(extended, want) {
+		t.Errorf("got %v; want %v", extended, want)
+	}
+	// String is replaced with the new method.
+	if want := "type Point struct{ X int }\n\nfunc (p Point) Get() int { return p.X }"; extra != want {
+		t.Errorf("got %q; want %q", extra, want)
+	}
+
+	// Types redeclared in the cell, types not declared in the session and non-types are not extended.
+	for _, src := range []string{
+		"type Point int\nfunc (p Point) String() string { return \"\" }",
+		"func (u Unknown) String() string { return \"\" }",
+		"func (x x) String() string { return \"\" }",
+	} {
+		if extended, extra := rn.extendTypes(src); extended != nil || extra != "" {
+			t.Errorf("extendTypes(%q) = %v, %q", src, extended, extra)
+		}
+	}
+}
+
+func TestDropExtraLines(t *testing.T) {
+	m := converter.LineMap{0, 1, 2, 4, 0, 5}
+	dropExtraLines(m, "a\nb")
+	if want := (converter.LineMap{0, 1, 2, 0, 0, 0}); !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v; want %v", m, want)
+	}
+}
@@ -0,0 +1,543 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yunabe/lgo/cmd/install"
+	"github.com/yunabe/lgo/core"
+)
+
+// requirement is a module requirement declared with %require.
+type requirement struct {
+	Path string
+	// Version is a version or a version query (e.g. latest) of the module. It's empty if Replace is set.
+	Version string
+	// Replace is a local directory or path@version that replaces the module.
+	Replace string
+}
+
+func (r requirement) String() string {
+	if r.Replace != "" {
+		return r.Path + " => " + r.Replace
+	}
+	return r.Path + "@" + r.Version
+}
+
+// parseRequirements parses arguments of %require, which are a list of path@version or path => replacement.
+func parseRequirements(args string) ([]requirement, error) {
+	fields := strings.Fields(args)
+	var reqs []requirement
+	for i := 0; i < len(fields); i++ {
+		if i+1 < len(fields) && fields[i+1] == "=>" {
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("missing the replacement of %s", fields[i])
+			}
+			reqs = append(reqs, requirement{Path: fields[i], Replace: fields[i+2]})
+			i += 2
+			continue
+		}
+		at := strings.LastIndexByte(fields[i], '@')
+		if at <= 0 || at == len(fields[i])-1 {
+			return nil, fmt.Errorf("%q must be module@version or module => replacement", fields[i])
+		}
+		reqs = append(reqs, requirement{Path: fields[i][:at], Version: fields[i][at+1:]})
+	}
+	if len(reqs) == 0 {
+		return nil, errors.New("no module is specified")
+	}
+	return reqs, nil
+}
+
+// isLocalPath returns true if a replacement of a module is a local directory.
+// This is same as the rule of replace directives in go.mod.
+func isLocalPath(replace string) bool {
+	return strings.HasPrefix(replace, "./") || strings.HasPrefix(replace, "../") || filepath.IsAbs(replace)
+}
+
+// localReplacementVersion is the version used in go.mod to require a module replaced with a local directory.
+const localReplacementVersion = "v0.0.0-00010101000000-000000000000"
+
+// formatGoMod returns the content of go.mod of module that requires modules in requires and replaces modules
+// in replaces. Keys of requires and replaces are module paths.
+func formatGoMod(module string, requires, replaces map[string]string) string {
+	mod := goMod(module)
+	if len(requires) > 0 {
+		mod += "\nrequire (\n"
+		for _, path := range sortedKeys(requires) {
+			mod += fmt.Sprintf("\t%s %s\n", path, requires[path])
+		}
+		mod += ")\n"
+	}
+	if len(replaces) > 0 {
+		mod += "\n"
+		for _, path := range sortedKeys(replaces) {
+			target := replaces[path]
+			if i := strings.LastIndexByte(target, '@'); i > 0 && !isLocalPath(target) {
+				target = target[:i] + " " + target[i+1:]
+			}
+			mod += fmt.Sprintf("replace %s => %s\n", path, target)
+		}
+	}
+	return mod
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sessionModule is the module context of a session, which is created by the first %require.
+// lgo builds code in GOPATH mode because shared libraries can not be built in module mode.
+// Thus, modules in the build list of go.mod in dir are linked into a GOPATH (dir/gopath) that is
+// prepended to GOPATH of go commands run by the session. Packages are installed into a pkg directory of
+// the session (dir/pkg) so that packages built from different versions of modules in other sessions are not
+// overwritten.
+type sessionModule struct {
+	dir      string
+	requires map[string]string
+	replaces map[string]string
+	// versions keeps versions of modules in the build list.
+	versions map[string]string
+}
+
+func (mod *sessionModule) gopath() string {
+	return filepath.Join(mod.dir, "gopath")
+}
+
+// pkgDir returns the directory into which packages of the session are installed.
+// This is pkgDir(mod.dir) of the install package.
+func (mod *sessionModule) pkgDir() string {
+	return filepath.Join(mod.dir, "pkg")
+}
+
+// buildRoot returns the directory whose pkg directory keeps .a and .so files of the session.
+// It's LGOPATH unless modules are required in the session.
+func (rn *LgoRunner) buildRoot() string {
+	if rn.module != nil {
+		return rn.module.dir
+	}
+	return rn.lgopath
+}
+
+// PkgDir returns the directory of .a and .so files used to build code of the session (-pkgdir of go install).
+func (rn *LgoRunner) PkgDir() string {
+	return filepath.Join(rn.buildRoot(), "pkg")
+}
+
+// GoEnv returns the environment of go commands that build code of the session.
+// It returns nil, which means the environment of the process, unless modules are required in the session.
+func (rn *LgoRunner) GoEnv() []string {
+	if rn.module == nil {
+		return nil
+	}
+	return append(os.Environ(), "GOPATH="+rn.module.gopath()+string(filepath.ListSeparator)+build.Default.GOPATH)
+}
+
+// moduleInfo is the output of go list -m -json and go mod download -json.
+type moduleInfo struct {
+	Path    string
+	Version string
+	Dir     string
+	Main    bool
+	Replace *moduleInfo
+	Error   interface{}
+}
+
+// minModuleGoMinor is the minimum minor version of go1 that %require supports.
+// go commands run by %require need GOFLAGS=-mod=mod, which is available from go1.14.
+const minModuleGoMinor = 14
+
+var goCommandVersionPattern = regexp.MustCompile(`\bgo1\.(\d+)`)
+
+// checkGoVersion returns an error if version, which is the output of go version, is older than go1.14.
+func checkGoVersion(version string) error {
+	m := goCommandVersionPattern.FindStringSubmatch(version)
+	if m == nil {
+		// Development versions (e.g. "go version devel +8b8f3e6 ...") are assumed to be new enough.
+		return nil
+	}
+	if minor, err := strconv.Atoi(m[1]); err != nil || minor < minModuleGoMinor {
+		return fmt.Errorf("%%require needs go1.%d or later: %s", minModuleGoMinor, version)
+	}
+	return nil
+}
+
+// checkModuleSupport returns an error if go command does not support modules in the way %require uses them.
+func checkModuleSupport(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "go", "version").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get the version of go: %v", err)
+	}
+	return checkGoVersion(strings.TrimSpace(string(out)))
+}
+
+// modCommand returns go command that runs in module mode in dir.
+// The module cache in the original GOPATH is used. To work offline, set GOPROXY to off or a local directory
+// (file:///path). The checksum database is not used in that case because it's not reachable.
+func modCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOPATH="+build.Default.GOPATH)
+	if proxy := os.Getenv("GOPROXY"); (proxy == "off" || strings.HasPrefix(proxy, "file://")) && os.Getenv("GOSUMDB") == "" {
+		cmd.Env = append(cmd.Env, "GOSUMDB=off")
+	}
+	return cmd
+}
+
+// runModCommand runs go command in module mode and decodes JSON outputs of the command with decode.
+func runModCommand(ctx context.Context, dir string, decode func(*json.Decoder) error, args ...string) error {
+	cmd := modCommand(ctx, dir, args...)
+	// go mod download -json reports errors to stdout.
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go %s failed: %v\n%s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+	if decode == nil {
+		return nil
+	}
+	return decode(json.NewDecoder(&stdout))
+}
+
+// resolveVersion downloads the module of path@query and returns its version.
+func resolveVersion(ctx context.Context, dir, path, query string) (string, error) {
+	var info moduleInfo
+	err := runModCommand(ctx, dir, func(dec *json.Decoder) error {
+		return dec.Decode(&info)
+	}, "mod", "download", "-json", path+"@"+query)
+	if err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
+
+// buildList downloads modules in the build list of go.mod in dir and returns them.
+func buildList(ctx context.Context, dir string) ([]*moduleInfo, error) {
+	if err := runModCommand(ctx, dir, nil, "mod", "download", "all"); err != nil {
+		return nil, err
+	}
+	var mods []*moduleInfo
+	err := runModCommand(ctx, dir, func(dec *json.Decoder) error {
+		for {
+			var m moduleInfo
+			err := dec.Decode(&m)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if m.Error != nil {
+				return fmt.Errorf("failed to resolve %s: %v", m.Path, m.Error)
+			}
+			if !m.Main {
+				mods = append(mods, &m)
+			}
+		}
+	}, "list", "-m", "-json", "all")
+	return mods, err
+}
+
+// linkModules links directories of mods into gopath/src.
+func linkModules(gopath string, mods []*moduleInfo) error {
+	src := filepath.Join(gopath, "src")
+	if err := os.RemoveAll(src); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(src, 0755); err != nil {
+		return err
+	}
+	// Link modules that contain other modules (e.g. example.com/m for example.com/m/v2) first.
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+	for _, m := range mods {
+		dir := m.Dir
+		if dir == "" && m.Replace != nil {
+			dir = m.Replace.Dir
+		}
+		if dir == "" {
+			continue
+		}
+		if err := linkModule(src, m.Path, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkModule links dir into src as the directory of the module of modPath.
+// If modPath is nested in a module linked already (e.g. example.com/m/v2 in example.com/m), the link of the
+// outer module is replaced with a directory that has links to files in the module because directories in the
+// module cache are read-only.
+func linkModule(src, modPath, dir string) error {
+	elems := strings.Split(modPath, "/")
+	p := src
+	for _, elem := range elems[:len(elems)-1] {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if err := expandLink(p); err != nil {
+				return err
+			}
+		}
+	}
+	link := filepath.Join(p, elems[len(elems)-1])
+	if _, err := os.Lstat(link); err == nil {
+		return fmt.Errorf("failed to link %s: %s already exists in another module", modPath, modPath)
+	}
+	return os.Symlink(dir, link)
+}
+
+// expandLink replaces a symbolic link to a directory with a directory that has links to files in the directory.
+func expandLink(link string) error {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil {
+		return err
+	}
+	if err := os.Mkdir(link, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Symlink(filepath.Join(target, f.Name()), filepath.Join(link, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorPkgDir creates dst that has links to files in src. go install with -pkgdir dst uses packages installed in
+// src (e.g. std and core) but it installs packages into dst without changing files in src.
+func mirrorPkgDir(src, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		return os.Symlink(p, filepath.Join(dst, rel))
+	})
+}
+
+// moduleVersionFile is the file in <pkg dir>/<module path> that records the version of the module
+// whose packages are installed in the pkg directory.
+const moduleVersionFile = ".lgo-module-version"
+
+// invalidateInstalledModule removes .so and .a files of packages in the module of modPath from pkgDir of a session
+// if they were not built from version so that they are installed again from the version.
+// Files in pkgDir are built in the session or links to files in LGOPATH/pkg (See mirrorPkgDir). Removing them
+// does not affect other sessions.
+func invalidateInstalledModule(pkgDir, modPath, version string) error {
+	stamp := filepath.Join(pkgDir, filepath.FromSlash(modPath), moduleVersionFile)
+	if b, err := ioutil.ReadFile(stamp); err == nil && string(b) == version {
+		return nil
+	}
+	os.Remove(filepath.Join(pkgDir, filepath.FromSlash(modPath)+".a"))
+	if err := os.RemoveAll(filepath.Join(pkgDir, filepath.FromSlash(modPath))); err != nil {
+		return err
+	}
+	lib := "lib" + strings.Replace(modPath, "/", "-", -1)
+	files, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if name := f.Name(); strings.HasSuffix(name, ".so") && (name == lib+".so" || strings.HasPrefix(name, lib+"-")) {
+			os.Remove(filepath.Join(pkgDir, name))
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(stamp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stamp, []byte(version), 0644)
+}
+
+// moduleDir returns the directory of go.mod of the session.
+func moduleDir(lgopath string, sessID *SessionID) string {
+	return filepath.Join(lgopath, "modules", sessID.Marshal())
+}
+
+// importedModule returns a package imported in the session that belongs to the module of modPath.
+func (rn *LgoRunner) importedModule(modPath string) string {
+	for _, im := range rn.imports {
+		if p := im.Imported().Path(); p == modPath || strings.HasPrefix(p, modPath+"/") {
+			return p
+		}
+	}
+	return ""
+}
+
+// require adds reqs to go.mod of the session and makes packages of the build list available in the session.
+// go.mod is not changed if it fails.
+func (rn *LgoRunner) require(ctx context.Context, reqs []requirement) (err error) {
+	if err := checkModuleSupport(ctx); err != nil {
+		return err
+	}
+	mod := rn.module
+	if mod == nil {
+		mod = &sessionModule{
+			dir:      moduleDir(rn.lgopath, rn.sessID),
+			requires: make(map[string]string),
+			replaces: make(map[string]string),
+			versions: make(map[string]string),
+		}
+		if err := os.MkdirAll(mod.dir, 0755); err != nil {
+			return err
+		}
+	}
+	requires := make(map[string]string)
+	for k, v := range mod.requires {
+		requires[k] = v
+	}
+	replaces := make(map[string]string)
+	for k, v := range mod.replaces {
+		replaces[k] = v
+	}
+	module := "github.com/yunabe/lgo/" + rn.sessID.Marshal()
+	modFile := filepath.Join(mod.dir, "go.mod")
+	// Write go.mod before go mod download because it must run in the module.
+	oldMod := formatGoMod(module, requires, replaces)
+	if err := ioutil.WriteFile(modFile, []byte(oldMod), 0644); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ioutil.WriteFile(modFile, []byte(oldMod), 0644)
+		}
+	}()
+	for _, r := range reqs {
+		if r.Replace != "" {
+			target := r.Replace
+			if isLocalPath(target) {
+				abs, err := filepath.Abs(target)
+				if err != nil {
+					return err
+				}
+				target = abs
+			}
+			replaces[r.Path] = target
+			if _, ok := requires[r.Path]; !ok {
+				requires[r.Path] = localReplacementVersion
+			}
+			continue
+		}
+		v, err := resolveVersion(ctx, mod.dir, r.Path, r.Version)
+		if err != nil {
+			return err
+		}
+		requires[r.Path] = v
+	}
+	if err := ioutil.WriteFile(modFile, []byte(formatGoMod(module, requires, replaces)), 0644); err != nil {
+		return err
+	}
+	mods, err := buildList(ctx, mod.dir)
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]string)
+	for _, m := range mods {
+		v := m.Version
+		if m.Replace != nil {
+			v = m.Replace.Path + "@" + m.Replace.Version
+			if m.Replace.Version == "" {
+				v = m.Replace.Path
+			}
+		}
+		versions[m.Path] = v
+		if old, ok := mod.versions[m.Path]; ok && old != v {
+			if p := rn.importedModule(m.Path); p != "" {
+				return fmt.Errorf("can not change the version of %s from %s to %s because %s is already imported. Restart the session", m.Path, old, v, p)
+			}
+		}
+	}
+	if err := linkModules(mod.gopath(), mods); err != nil {
+		return err
+	}
+	if rn.module == nil {
+		if err := mirrorPkgDir(filepath.Join(rn.lgopath, "pkg"), mod.pkgDir()); err != nil {
+			return err
+		}
+	}
+	for _, m := range mods {
+		if err := invalidateInstalledModule(mod.pkgDir(), m.Path, versions[m.Path]); err != nil {
+			return err
+		}
+	}
+	mod.requires, mod.replaces, mod.versions = requires, replaces, versions
+	rn.module = mod
+	// Reload the index of packages imported automatically because installed packages change with modules.
+	if err := install.InvalidatePackageIndex(rn.lgopath); err != nil {
+		return err
+	}
+	for _, r := range reqs {
+		if r.Replace != "" {
+			fmt.Printf("replace %s => %s\n", r.Path, replaces[r.Path])
+		} else {
+			fmt.Printf("require %s %s\n", r.Path, requires[r.Path])
+		}
+	}
+	return nil
+}
+
+// runRequire implements %require, which adds requirements of modules to the session.
+// Without arguments, it prints go.mod of the session.
+func runRequire(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	if m.Args == "" {
+		if rn.module == nil {
+			fmt.Println("No module is required in this session")
+			return nil
+		}
+		b, err := ioutil.ReadFile(filepath.Join(rn.module.dir, "go.mod"))
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(b)
+		return nil
+	}
+	reqs, err := parseRequirements(m.Args)
+	if err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	return rn.require(ctx, reqs)
+}
@@ -0,0 +1,274 @@
+package runner
+
+import (
+	"archive/zip"
+	"context"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequirements(t *testing.T) {
+	tests := []struct {
+		args string
+		want []requirement
+		err  string
+	}{
+		{
+			args: "example.com/a@v1.2.3 example.com/b@latest",
+			want: []requirement{{Path: "example.com/a", Version: "v1.2.3"}, {Path: "example.com/b", Version: "latest"}},
+		},
+		{
+			args: "example.com/a => ../a example.com/b => example.com/c@v1.0.0",
+			want: []requirement{{Path: "example.com/a", Replace: "../a"}, {Path: "example.com/b", Replace: "example.com/c@v1.0.0"}},
+		},
+		{args: "example.com/a", err: `"example.com/a" must be module@version or module => replacement`},
+		{args: "example.com/a@", err: `"example.com/a@" must be module@version or module => replacement`},
+		{args: "example.com/a =>", err: "missing the replacement of example.com/a"},
+	}
+	for _, tc := range tests {
+		got, err := parseRequirements(tc.args)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("parseRequirements(%q) returned %v; want %q", tc.args, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRequirements(%q) failed: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseRequirements(%q) = %v; want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestFormatGoMod(t *testing.T) {
+	got := formatGoMod("m", map[string]string{
+		"example.com/b": "v0.1.0",
+		"example.com/a": localReplacementVersion,
+	}, map[string]string{
+		"example.com/a": "/src/a",
+		"example.com/b": "example.com/c@v1.0.0",
+	})
+	want := goMod("m") + `
+require (
+	example.com/a v0.0.0-00010101000000-000000000000
+	example.com/b v0.1.0
+)
+
+replace example.com/a => /src/a
+replace example.com/b => example.com/c v1.0.0
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCheckGoVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"go version go1.14 linux/amd64", true},
+		{"go version go1.21.5 darwin/arm64", true},
+		{"go version devel +8b8f3e6 Mon Jan 1 00:00:00 2018 +0000 linux/amd64", true},
+		{"go version go1.13.15 linux/amd64", false},
+		{"go version go1.9.7 linux/amd64", false},
+	}
+	for _, tc := range tests {
+		if err := checkGoVersion(tc.version); (err == nil) != tc.ok {
+			t.Errorf("checkGoVersion(%q) returned %v", tc.version, err)
+		}
+	}
+}
+
+func TestLinkModules(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "lgo_link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	files := []string{"m@v1.0.0/m.go", "m@v1.0.0/sub/sub.go", "m/v2@v2.0.0/m.go", "m/v2/sub@v2.1.0/sub.go", "other@v1.0.0/other.go"}
+	for _, f := range files {
+		f = filepath.Join(tmp, "cache", filepath.FromSlash(f))
+		os.MkdirAll(filepath.Dir(f), 0755)
+		ioutil.WriteFile(f, nil, 0644)
+	}
+	cache := filepath.Join(tmp, "cache")
+	gopath := filepath.Join(tmp, "gopath")
+	err = linkModules(gopath, []*moduleInfo{
+		{Path: "example.com/m/v2/sub", Dir: filepath.Join(cache, "m/v2/sub@v2.1.0")},
+		{Path: "example.com/m/v2", Dir: filepath.Join(cache, "m/v2@v2.0.0")},
+		{Path: "example.com/m", Dir: filepath.Join(cache, "m@v1.0.0")},
+		{Path: "example.com/other", Replace: &moduleInfo{Path: "example.com/fork", Dir: filepath.Join(cache, "other@v1.0.0")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"m/m.go", "m/sub/sub.go", "m/v2/m.go", "m/v2/sub/sub.go", "other/other.go"} {
+		if _, err := os.Stat(filepath.Join(gopath, "src", "example.com", filepath.FromSlash(f))); err != nil {
+			t.Errorf("%s is not linked: %v", f, err)
+		}
+	}
+	// A nested module conflicts with a directory of the outer module.
+	err = linkModules(gopath, []*moduleInfo{
+		{Path: "example.com/m", Dir: filepath.Join(cache, "m@v1.0.0")},
+		{Path: "example.com/m/sub", Dir: filepath.Join(cache, "m/v2/sub@v2.1.0")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "example.com/m/sub already exists") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// writeModuleZip writes a module zip of path@version with files to the GOPROXY directory proxy.
+func writeModuleZip(t *testing.T, proxy, path, version string, files map[string]string) {
+	dir := filepath.Join(proxy, filepath.FromSlash(path), "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(path + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, version+".mod"), []byte(files["go.mod"]), 0644)
+	ioutil.WriteFile(filepath.Join(dir, version+".info"), []byte(`{"Version":"`+version+`"}`), 0644)
+	list, _ := ioutil.ReadFile(filepath.Join(dir, "list"))
+	ioutil.WriteFile(filepath.Join(dir, "list"), append(list, version+"\n"...), 0644)
+}
+
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// TestRequire tests %require with a local GOPROXY directory. It does not access the network.
+func TestRequire(t *testing.T) {
+	if err := exec.Command("go", "help", "mod").Run(); err != nil {
+		t.Skip("go does not support modules")
+	}
+	tmp, err := ioutil.TempDir("", "lgo_modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	proxy := filepath.Join(tmp, "proxy")
+	modCache := filepath.Join(tmp, "modcache")
+	for _, restore := range []func(){
+		setenv("GOPROXY", "file://"+filepath.ToSlash(proxy)),
+		setenv("GOMODCACHE", modCache),
+		// GOSUMDB=off is set by lgo for the local GOPROXY if GOSUMDB is empty.
+		setenv("GOSUMDB", ""),
+	} {
+		defer restore()
+	}
+	// Files in the module cache are read-only.
+	defer modCommand(context.Background(), tmp, "clean", "-modcache").Run()
+
+	writeModuleZip(t, proxy, "example.com/greet", "v1.0.0", map[string]string{
+		"go.mod":   "module example.com/greet\n\nrequire example.com/dep v0.1.0\n",
+		"greet.go": "package greet\n",
+	})
+	writeModuleZip(t, proxy, "example.com/greet", "v1.1.0", map[string]string{
+		"go.mod":   "module example.com/greet\n\nrequire example.com/dep v0.1.0\n",
+		"greet.go": "package greet\n\nconst Version = \"v1.1.0\"\n",
+	})
+	writeModuleZip(t, proxy, "example.com/dep", "v0.1.0", map[string]string{
+		"go.mod": "module example.com/dep\n",
+		"dep.go": "package dep\n",
+	})
+	local := filepath.Join(tmp, "local")
+	os.MkdirAll(local, 0755)
+	ioutil.WriteFile(filepath.Join(local, "go.mod"), []byte("module example.com/local\n"), 0644)
+	ioutil.WriteFile(filepath.Join(local, "local.go"), []byte("package local\n"), 0644)
+
+	lgopath := filepath.Join(tmp, "lgopath")
+	pkgDir := filepath.Join(lgopath, "pkg")
+	os.MkdirAll(pkgDir, 0755)
+	// A stale .so built from another version.
+	ioutil.WriteFile(filepath.Join(pkgDir, "libexample.com-greet.so"), nil, 0644)
+
+	rn := &LgoRunner{lgopath: lgopath, sessID: &SessionID{Time: 1}, imports: make(map[string]*types.PkgName)}
+	ctx := context.Background()
+	if err := rn.require(ctx, []requirement{{Path: "example.com/greet", Version: "v1.0.0"}}); err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(moduleDir(lgopath, rn.sessID), "gopath")
+	for _, f := range []string{"example.com/greet/greet.go", "example.com/dep/dep.go"} {
+		if _, err := os.Stat(filepath.Join(gopath, "src", f)); err != nil {
+			t.Errorf("%s is not linked: %v", f, err)
+		}
+	}
+	// The stale .so is removed from the pkg directory of the session, but it's kept in LGOPATH for other sessions.
+	if got, want := rn.PkgDir(), filepath.Join(moduleDir(lgopath, rn.sessID), "pkg"); got != want {
+		t.Errorf("PkgDir() = %s; want %s", got, want)
+	}
+	if _, err := os.Lstat(filepath.Join(rn.PkgDir(), "libexample.com-greet.so")); !os.IsNotExist(err) {
+		t.Errorf("the stale .so was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(pkgDir, "libexample.com-greet.so")); err != nil {
+		t.Errorf("the .so in LGOPATH was removed: %v", err)
+	}
+	env := rn.GoEnv()
+	if last := env[len(env)-1]; !strings.HasPrefix(last, "GOPATH="+gopath+string(filepath.ListSeparator)) {
+		t.Errorf("GOPATH of the session does not start with %s: %s", gopath, last)
+	}
+	if strings.HasPrefix(os.Getenv("GOPATH"), gopath) {
+		t.Errorf("GOPATH of the process was changed: %s", os.Getenv("GOPATH"))
+	}
+
+	if err := rn.require(ctx, []requirement{{Path: "example.com/greet", Version: "latest"}, {Path: "example.com/local", Replace: local}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rn.module.requires["example.com/greet"]; got != "v1.1.0" {
+		t.Errorf("latest was resolved to %s; want v1.1.0", got)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(gopath, "src", "example.com/greet/greet.go"))
+	if err != nil || !strings.Contains(string(b), "v1.1.0") {
+		t.Errorf("example.com/greet@v1.1.0 is not linked: %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(gopath, "src", "example.com/local/local.go")); err != nil {
+		t.Errorf("the local replacement is not linked: %v", err)
+	}
+
+	// The version of an imported module can not be changed.
+	pkg := types.NewPackage("example.com/greet", "greet")
+	rn.imports["greet"] = types.NewPkgName(0, nil, "greet", pkg)
+	modFile := filepath.Join(moduleDir(lgopath, rn.sessID), "go.mod")
+	before, _ := ioutil.ReadFile(modFile)
+	err = rn.require(ctx, []requirement{{Path: "example.com/greet", Version: "v1.0.0"}})
+	if err == nil || !strings.Contains(err.Error(), "example.com/greet is already imported") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if after, _ := ioutil.ReadFile(modFile); string(after) != string(before) {
+		t.Errorf("go.mod was changed by the failure:\n%s", after)
+	}
+	if err := rn.require(ctx, []requirement{{Path: "example.com/missing", Version: "v1.0.0"}}); err == nil {
+		t.Error("no error for a missing module")
+	}
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParameterCode returns lgo code that sets values of parameters of a notebook executed by lgo nbexec.
+// A value is converted to a literal of the type of the variable defined in the session (e.g. in the cell tagged
+// "parameters"). Parameters not defined yet are defined with types guessed from values (bool, int, float64 or string).
+func (rn *LgoRunner) ParameterCode(params map[string]string) (string, error) {
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		value := params[name]
+		obj, ok := rn.vars[name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s := %s", name, guessLiteral(value)))
+			continue
+		}
+		v, ok := obj.(*types.Var)
+		if !ok {
+			return "", fmt.Errorf("parameter %s is not a variable", name)
+		}
+		lit, err := literalOf(v.Type(), value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s: %v", name, err)
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", name, lit))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// guessLiteral returns a literal of value. value is used as is if it's true, false or an int or float literal of Go
+// (e.g. 10, -0x10, 1.5e3). Otherwise, it's quoted. Values like "True" or "Inf" that strconv accepts are quoted too.
+func guessLiteral(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if isNumberLiteral(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// isNumberLiteral reports whether value is an int or float literal of Go, optionally negated, that fits in int64 or float64.
+func isNumberLiteral(value string) bool {
+	abs := strings.TrimPrefix(value, "-")
+	expr, err := parser.ParseExpr(abs)
+	if err != nil {
+		return false
+	}
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Value != abs {
+		return false
+	}
+	switch lit.Kind {
+	case token.INT:
+		_, err = strconv.ParseInt(value, 0, 64)
+	case token.FLOAT:
+		_, err = strconv.ParseFloat(value, 64)
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// literalOf returns an untyped constant of value that is assignable to typ.
+func literalOf(typ types.Type, value string) (string, error) {
+	if named, ok := typ.(*types.Named); ok {
+		if obj := named.Obj(); obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Duration" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatInt(int64(d), 10), nil
+		}
+	}
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return "", fmt.Errorf("unsupported type %s", types.TypeString(typ, nil))
+	}
+	info := basic.Info()
+	invalid := fmt.Errorf("%q is not a valid %s", value, types.TypeString(typ, nil))
+	switch {
+	case info&types.IsString != 0:
+		return strconv.Quote(value), nil
+	case info&types.IsBoolean != 0:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", invalid
+		}
+		return strconv.FormatBool(b), nil
+	case info&types.IsInteger != 0:
+		if n, err := strconv.ParseInt(value, 0, 64); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+		if n, err := strconv.ParseUint(value, 0, 64); err == nil {
+			return strconv.FormatUint(n, 10), nil
+		}
+		return "", invalid
+	case info&types.IsFloat != 0:
+		bitSize := 64
+		if basic.Kind() == types.Float32 {
+			bitSize = 32
+		}
+		f, err := strconv.ParseFloat(value, bitSize)
+		if err != nil {
+			return "", invalid
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// NaN and infinities can not be written as constants.
+			return "", fmt.Errorf("%q is not a finite %s", value, types.TypeString(typ, nil))
+		}
+		return strconv.FormatFloat(f, 'g', -1, bitSize), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", types.TypeString(typ, nil))
+}
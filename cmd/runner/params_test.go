@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestParameterCode(t *testing.T) {
+	timePkg := types.NewPackage("time", "time")
+	duration := types.NewNamed(types.NewTypeName(token.NoPos, timePkg, "Duration", nil), types.Typ[types.Int64], nil)
+	rn := &LgoRunner{
+		vars: map[string]types.Object{
+			"name":    types.NewVar(token.NoPos, nil, "name", types.Typ[types.String]),
+			"n":       types.NewVar(token.NoPos, nil, "n", types.Typ[types.Int]),
+			"rate":    types.NewVar(token.NoPos, nil, "rate", types.Typ[types.Float64]),
+			"verbose": types.NewVar(token.NoPos, nil, "verbose", types.Typ[types.Bool]),
+			"timeout": types.NewVar(token.NoPos, nil, "timeout", duration),
+			"f":       types.NewFunc(token.NoPos, nil, "f", types.NewSignature(nil, nil, nil, false)),
+			"items":   types.NewVar(token.NoPos, nil, "items", types.NewSlice(types.Typ[types.Int])),
+			"ratio":   types.NewVar(token.NoPos, nil, "ratio", types.Typ[types.Float32]),
+		},
+	}
+	got, err := rn.ParameterCode(map[string]string{
+		"name":    "10",
+		"n":       "0x10",
+		"rate":    "0.5",
+		"verbose": "true",
+		"timeout": "1m",
+		"newInt":  "3",
+		"newStr":  "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `n = 16
+name = "10"
+newInt := 3
+newStr := "hello"
+rate = 0.5
+timeout = 60000000000
+verbose = true`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Values accepted by strconv are converted to canonical literals.
+	for _, tc := range []struct {
+		name, value, want string
+	}{
+		{"verbose", "1", "verbose = true"},
+		{"verbose", "T", "verbose = true"},
+		{"verbose", "FALSE", "verbose = false"},
+		{"n", "-0b101", "n = -5"},
+		{"rate", "1E3", "rate = 1000"},
+		{"rate", "0x1p-2", "rate = 0.25"},
+		{"ratio", "0.1", "ratio = 0.1"},
+	} {
+		got, err := rn.ParameterCode(map[string]string{tc.name: tc.value})
+		if err != nil {
+			t.Errorf("%s=%s: %v", tc.name, tc.value, err)
+		} else if got != tc.want {
+			t.Errorf("%s=%s: got %q; want %q", tc.name, tc.value, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, value, want string
+	}{
+		{"n", "abc", `parameter n: "abc" is not a valid int`},
+		{"f", "abc", "parameter f is not a variable"},
+		{"items", "abc", "parameter items: unsupported type []int"},
+		{"verbose", "yes", `parameter verbose: "yes" is not a valid bool`},
+		{"rate", "NaN", `parameter rate: "NaN" is not a finite float64`},
+		{"rate", "-Inf", `parameter rate: "-Inf" is not a finite float64`},
+		{"rate", "infinity", `parameter rate: "infinity" is not a finite float64`},
+		{"ratio", "1e100", `parameter ratio: "1e100" is not a valid float32`},
+	} {
+		_, err := rn.ParameterCode(map[string]string{tc.name: tc.value})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("got %v; want %s", err, tc.want)
+		}
+	}
+}
+
+func TestGuessLiteral(t *testing.T) {
+	for value, want := range map[string]string{
+		"true":     "true",
+		"false":    "false",
+		"10":       "10",
+		"-10":      "-10",
+		"0x10":     "0x10",
+		"1.5e3":    "1.5e3",
+		"-0.5":     "-0.5",
+		"t":        `"t"`,
+		"True":     `"True"`,
+		"FALSE":    `"FALSE"`,
+		"NaN":      `"NaN"`,
+		"Inf":      `"Inf"`,
+		"-Inf":     `"-Inf"`,
+		"infinity": `"infinity"`,
+		"1i":       `"1i"`,
+		" 10":      `" 10"`,
+		"--10":     `"--10"`,
+		"1+2":      `"1+2"`,
+		"1e1000":   `"1e1000"`,
+		"hello":    `"hello"`,
+	} {
+		if got := guessLiteral(value); got != want {
+			t.Errorf("guessLiteral(%q) = %s; want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/profile"
+)
+
+// profiler collects a profile of an execution.
+type profiler struct {
+	// title is shown with the result.
+	title string
+	// sampleType is the type of values shown in the result.
+	sampleType string
+	// start starts profiling and returns a function to stop profiling.
+	start func() (stop func() (*profile.Profile, error), err error)
+}
+
+var profilers = map[string]*profiler{
+	"cpuprofile":   {title: "CPU time", sampleType: "cpu", start: startCPUProfile},
+	"memprofile":   {title: "Allocated memory", sampleType: "alloc_space", start: startMemProfile},
+	"blockprofile": {title: "Blocking time", sampleType: "delay", start: startBlockProfile},
+	"mutexprofile": {title: "Mutex contention", sampleType: "delay", start: startMutexProfile},
+}
+
+func startCPUProfile() (func() (*profile.Profile, error), error) {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	return func() (*profile.Profile, error) {
+		pprof.StopCPUProfile()
+		return profile.Parse(buf.Bytes())
+	}, nil
+}
+
+// lookupProfile returns the current snapshot of the profile of name (e.g. "heap").
+func lookupProfile(name string) (*profile.Profile, error) {
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		return nil, fmt.Errorf("%s profile is not available", name)
+	}
+	var buf bytes.Buffer
+	if err := prof.WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return profile.Parse(buf.Bytes())
+}
+
+// startCumulativeProfile takes a snapshot of the cumulative profile of name
+// and returns a function that returns the difference from the snapshot.
+// reset is called when profiling stops.
+func startCumulativeProfile(name string, reset func()) (func() (*profile.Profile, error), error) {
+	base, err := lookupProfile(name)
+	if err != nil {
+		reset()
+		return nil, err
+	}
+	return func() (*profile.Profile, error) {
+		defer reset()
+		p, err := lookupProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		return p.Sub(base), nil
+	}, nil
+}
+
+// memProfileRate is the sampling rate of memory allocations in %%memprofile.
+// This is smaller than the default to profile small cells accurately.
+const memProfileRate = 4096
+
+func startMemProfile() (func() (*profile.Profile, error), error) {
+	prev := runtime.MemProfileRate
+	// The memory profile is updated at the end of GC cycles.
+	runtime.GC()
+	runtime.MemProfileRate = memProfileRate
+	// The heap profile has alloc_space and alloc_objects. "allocs" profile, which is same as "heap", is not
+	// available before go1.11.
+	return startCumulativeProfile("heap", func() {
+		// Run GC twice to publish all allocations in the execution.
+		runtime.GC()
+		runtime.GC()
+		runtime.MemProfileRate = prev
+	})
+}
+
+func startBlockProfile() (func() (*profile.Profile, error), error) {
+	runtime.SetBlockProfileRate(1)
+	return startCumulativeProfile("block", func() { runtime.SetBlockProfileRate(0) })
+}
+
+func startMutexProfile() (func() (*profile.Profile, error), error) {
+	prev := runtime.SetMutexProfileFraction(1)
+	return startCumulativeProfile("mutex", func() { runtime.SetMutexProfileFraction(prev) })
+}
+
+// runProfile implements profiling cell magics (e.g. %%cpuprofile).
+// It executes the body of the cell as lgo code and shows the top functions and the call graph of the execution.
+func runProfile(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	prof := profilers[m.Name]
+	fs := flag.NewFlagSet(m.String(), flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	top := fs.Int("top", 20, "the number of functions shown in the table")
+	nodes := fs.Int("nodes", 30, "the maximum number of functions shown in the call graph")
+	if err := fs.Parse(strings.Fields(m.Args)); err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	var p *profile.Profile
+	var profErr error
+	err := rn.runCode(ctx, m.Body, func(exec func() error) error {
+		stop, err := prof.start()
+		if err != nil {
+			return fmt.Errorf("failed to start profiling: %v", err)
+		}
+		execErr := exec()
+		p, profErr = stop()
+		return execErr
+	})
+	if profErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to collect a profile: %v\n", profErr)
+	} else if p != nil {
+		rn.showProfile(ctx, prof, p, *top, *nodes)
+	}
+	return err
+}
+
+func (rn *LgoRunner) showProfile(ctx core.LgoContext, prof *profiler, p *profile.Profile, top, nodes int) {
+	index := p.ValueIndex(prof.sampleType)
+	if index < 0 {
+		fmt.Fprintf(os.Stderr, "%s is not found in the profile\n", prof.sampleType)
+		return
+	}
+	unit := p.SampleTypes[index].Unit
+	p = p.Focus(rn.isCellFrame).MapFrames(rn.cellFrame)
+	total := p.Total(index)
+	if total == 0 {
+		fmt.Fprintln(os.Stdout, "No samples were collected.")
+		return
+	}
+	entries := p.Top(index, top)
+	title := fmt.Sprintf("%s: %s", prof.title, profile.FormatValue(total, unit))
+	text := title + "\n" + profile.FormatTop(entries, total, unit)
+	if ctx.Display == nil {
+		fmt.Fprint(os.Stdout, text)
+		return
+	}
+	ctx.Display.MIME(map[string]interface{}{
+		"text/plain": text,
+		"text/html": "<div><b>" + html.EscapeString(title) + "</b>" + profile.TopHTML(entries, total, unit) +
+			profile.CallGraphSVG(p, index, unit, nodes) + "</div>",
+	}, nil, nil)
+}
+
+// sessPkgPrefix returns the prefix of package paths of executions in the session.
+func (rn *LgoRunner) sessPkgPrefix() string {
+	return "github.com/yunabe/lgo/" + rn.sessID.Marshal() + "/"
+}
+
+// parseCellFunc parses the name of a function in lgo code (e.g. "github.com/yunabe/lgo/sess.../exec3.LgoExport_f").
+// It returns the package path, the number of the execution and the name of the function in the package.
+func (rn *LgoRunner) parseCellFunc(fn string) (pkgPath string, cell int, name string, err error) {
+	prefix := rn.sessPkgPrefix()
+	if !strings.HasPrefix(fn, prefix) {
+		return "", 0, "", errors.New("not lgo code")
+	}
+	i := strings.IndexByte(fn[len(prefix):], '.')
+	if i < 0 {
+		return "", 0, "", errors.New("no package name")
+	}
+	pkg := fn[len(prefix) : len(prefix)+i]
+	if !strings.HasPrefix(pkg, "exec") {
+		return "", 0, "", errors.New("not an execution")
+	}
+	n := pkg[len("exec"):]
+	if i := strings.IndexByte(n, '_'); i >= 0 {
+		// Code executed in a cell with other code (e.g. exec3_1 in %rerun).
+		n = n[:i]
+	}
+	cell, err = strconv.Atoi(n)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return prefix + pkg, cell, fn[len(prefix)+i+1:], nil
+}
+
+func (rn *LgoRunner) isCellFrame(f profile.Frame) bool {
+	_, _, _, err := rn.parseCellFunc(f.Func)
+	return err == nil
+}
+
+// cellFrame converts a frame in lgo code to the frame in the cell.
+// For example, "github.com/yunabe/lgo/sess.../exec3.LgoExport_f" at line 10 of src.go becomes "f" at line 2 of "cell3".
+// Code at the top level of the cell is shown as "cell3".
+func (rn *LgoRunner) cellFrame(f profile.Frame) profile.Frame {
+	pkgPath, cell, name, err := rn.parseCellFunc(f.Func)
+	if err != nil {
+		return f
+	}
+	cellName := fmt.Sprintf("cell%d", cell)
+	name = strings.Replace(name, lgoExportPrefix, "", -1)
+	if name == lgoInitFuncName || strings.HasPrefix(name, lgoInitFuncName+".") {
+		name = cellName + name[len(lgoInitFuncName):]
+	}
+	lm := rn.lineMap(pkgPath)
+	return profile.Frame{
+		Func:      name,
+		File:      cellName,
+		Line:      lm.Original(f.Line),
+		StartLine: lm.Original(f.StartLine),
+	}
+}
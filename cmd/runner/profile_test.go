@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+	"github.com/yunabe/lgo/profile"
+)
+
+func TestCellFrame(t *testing.T) {
+	rn := &LgoRunner{
+		sessID:   &SessionID{Time: 1234},
+		lineMaps: make(map[string]converter.LineMap),
+	}
+	prefix := rn.sessPkgPrefix()
+	rn.lineMaps[prefix+"exec3"] = converter.LineMap{0, 0, 1, 2, 0, 5}
+	tests := []struct {
+		in   profile.Frame
+		want profile.Frame
+	}{
+		{
+			in:   profile.Frame{Func: prefix + "exec3.LgoExport_fib", File: "/go/src/x/src.go", Line: 4, StartLine: 3},
+			want: profile.Frame{Func: "fib", File: "cell3", Line: 2, StartLine: 1},
+		}, {
+			in:   profile.Frame{Func: prefix + "exec3.lgo_init.func1", Line: 6, StartLine: 5},
+			want: profile.Frame{Func: "cell3.func1", File: "cell3", Line: 5},
+		}, {
+			in:   profile.Frame{Func: prefix + "exec3.(*LgoExport_T).LgoExport_M", Line: 100},
+			want: profile.Frame{Func: "(*T).M", File: "cell3"},
+		}, {
+			in:   profile.Frame{Func: prefix + "exec4.lgo_init", Line: 3},
+			want: profile.Frame{Func: "cell4", File: "cell4"},
+		}, {
+			in:   profile.Frame{Func: "sort.Slice", File: "/go/src/sort/slice.go", Line: 10},
+			want: profile.Frame{Func: "sort.Slice", File: "/go/src/sort/slice.go", Line: 10},
+		},
+	}
+	for _, tc := range tests {
+		if got := rn.cellFrame(tc.in); got != tc.want {
+			t.Errorf("cellFrame(%+v) = %+v; want %+v", tc.in, got, tc.want)
+		}
+	}
+	if rn.isCellFrame(profile.Frame{Func: "sort.Slice"}) {
+		t.Error("sort.Slice is not a frame in cells")
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	p, err := lookupProfile("heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ValueIndex(profilers["memprofile"].sampleType) < 0 {
+		t.Errorf("heap profile does not have %s: %v", profilers["memprofile"].sampleType, p.SampleTypes)
+	}
+	if _, err := lookupProfile("unknown"); err == nil || err.Error() != "unknown profile is not available" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+package runner
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// executedCell is lgo code executed in the session. executedCells are kept to find definitions that still refer to
+// types redefined in later cells.
+type executedCell struct {
+	// seq is the order of the execution.
+	seq int
+	src string
+	// defs is the objects defined in the cell.
+	defs []types.Object
+	// refs is the set of objects defined in previous cells that the cell refers to.
+	refs map[types.Object]bool
+}
+
+// cellRefs returns the set of objects in olds that are used in a cell. uses is types.Info.Uses of the cell.
+// Objects are compared by identity. Local identifiers that have the same names as olds are not references to olds.
+func cellRefs(uses map[*ast.Ident]types.Object, olds []types.Object) map[types.Object]bool {
+	isOld := make(map[types.Object]bool)
+	for _, old := range olds {
+		isOld[old] = true
+	}
+	refs := make(map[types.Object]bool)
+	for _, obj := range uses {
+		if isOld[obj] {
+			refs[obj] = true
+		}
+	}
+	return refs
+}
+
+// redefinedTypes returns the previous definitions of types that are redefined by types in scope.
+func (rn *LgoRunner) redefinedTypes(scope *types.Scope) []types.Object {
+	var olds []types.Object
+	for _, name := range scope.Names() {
+		if tn, ok := rn.vars[name].(*types.TypeName); ok {
+			olds = append(olds, tn)
+		}
+	}
+	return olds
+}
+
+// isLatest returns true if obj is the latest definition of its name in the session.
+func (rn *LgoRunner) isLatest(obj types.Object) bool {
+	return rn.vars[obj.Name()] == obj
+}
+
+func objectNames(objs []types.Object) []string {
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.Name())
+	}
+	return names
+}
+
+// addExecutedCell records src that defined defs and referred to refs. rn.vars must be updated with defs beforehand.
+func (rn *LgoRunner) addExecutedCell(src string, defs []types.Object, refs map[types.Object]bool) *executedCell {
+	if rn.definedIn == nil {
+		rn.definedIn = make(map[types.Object]*executedCell)
+		rn.stale = make(map[types.Object]bool)
+	}
+	rn.cellSeq++
+	c := &executedCell{seq: rn.cellSeq, src: src, defs: defs, refs: refs}
+	for _, obj := range defs {
+		rn.definedIn[obj] = c
+	}
+	// Definitions replaced by defs are not tracked anymore.
+	for obj := range rn.definedIn {
+		if !rn.isLatest(obj) {
+			delete(rn.definedIn, obj)
+			delete(rn.stale, obj)
+		}
+	}
+	return c
+}
+
+// markStale marks definitions in previous cells as stale if they refer to redefined (or stale) objects
+// and returns the names newly marked.
+func (rn *LgoRunner) markStale(cur *executedCell, redefined []types.Object) []string {
+	changed := make(map[types.Object]bool)
+	for _, obj := range redefined {
+		changed[obj] = true
+	}
+	for obj := range rn.stale {
+		changed[obj] = true
+	}
+	var marked []string
+	for {
+		added := false
+		for obj, c := range rn.definedIn {
+			if c == cur || changed[obj] {
+				continue
+			}
+			for ref := range c.refs {
+				if changed[ref] {
+					changed[obj] = true
+					rn.stale[obj] = true
+					marked = append(marked, obj.Name())
+					added = true
+					break
+				}
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	sort.Strings(marked)
+	return marked
+}
+
+// staleNames returns names of stale definitions in the session.
+func (rn *LgoRunner) staleNames() []string {
+	var names []string
+	for obj := range rn.stale {
+		names = append(names, obj.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportStale warns that definitions in previous cells still refer to the old versions of redefined types.
+func (rn *LgoRunner) reportStale(cur *executedCell, redefined []types.Object) {
+	if len(redefined) == 0 {
+		return
+	}
+	marked := rn.markStale(cur, redefined)
+	if len(marked) == 0 {
+		return
+	}
+	var descs []string
+	for _, name := range marked {
+		descs = append(descs, fmt.Sprintf("%s (%s)", name, objectKind(rn.vars[name])))
+	}
+	fmt.Fprintf(os.Stderr, "Redefined %s. These still refer to the old definitions: %s\n"+
+		"Run %%rerun to execute their cells again or %%migrate to convert variables to the new types.\n",
+		strings.Join(objectNames(redefined), ", "), strings.Join(descs, ", "))
+}
+
+func objectKind(obj types.Object) string {
+	switch obj.(type) {
+	case *types.TypeName:
+		return "type"
+	case *types.Func:
+		return "func"
+	case *types.Const:
+		return "const"
+	}
+	return "var"
+}
+
+// runRerun implements %rerun, which executes cells that define stale names again in the original order.
+// Cells that also define names redefined later are not executed because it would revert the redefinitions.
+func runRerun(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	if m.Args != "" {
+		return fmt.Errorf("%s does not take arguments", m)
+	}
+	seen := make(map[*executedCell]bool)
+	var cells []*executedCell
+	for _, name := range rn.staleNames() {
+		c := rn.definedIn[rn.vars[name]]
+		if c == nil || seen[c] {
+			continue
+		}
+		seen[c] = true
+		cells = append(cells, c)
+	}
+	if len(cells) == 0 {
+		fmt.Fprintln(os.Stdout, "No stale definitions")
+		return nil
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].seq < cells[j].seq })
+	defer func() { rn.subExec = 0 }()
+	for _, c := range cells {
+		var outdated []string
+		for _, obj := range c.defs {
+			if !rn.isLatest(obj) {
+				outdated = append(outdated, obj.Name())
+			}
+		}
+		if len(outdated) > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped a cell that defines %s because it also defines %s, which are redefined later\n",
+				strings.Join(objectNames(c.defs), ", "), strings.Join(outdated, ", "))
+			continue
+		}
+		rn.subExec++
+		if err := rn.runCode(ctx, c.src, nil); err != nil {
+			return err
+		}
+		rn.record(c.src)
+	}
+	if names := rn.staleNames(); len(names) > 0 {
+		fmt.Fprintf(os.Stderr, "%s still refer to the old definitions\n", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// isSessionPackage returns true if pkg is a package of lgo code in the session.
+func (rn *LgoRunner) isSessionPackage(pkg *types.Package) bool {
+	return pkg != nil && strings.HasPrefix(pkg.Path(), rn.sessPkgPrefix())
+}
+
+// latestNamed returns the latest definition of the type redefined from old in the session.
+func (rn *LgoRunner) latestNamed(old *types.Named) *types.Named {
+	if !rn.isSessionPackage(old.Obj().Pkg()) {
+		return old
+	}
+	tn, ok := rn.vars[old.Obj().Name()].(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	n, _ := tn.Type().(*types.Named)
+	return n
+}
+
+// checkMigratable returns an error if values of typ can not be migrated to the type of the same expression
+// that refers to the latest definitions of types.
+func (rn *LgoRunner) checkMigratable(typ types.Type) error {
+	return rn.checkCompatible(nil, typ, make(map[*types.Named]bool))
+}
+
+// checkCompatible returns an error if values of old can not be migrated to values of new by core.MigrateValues.
+// If new is nil, new is the type of the same expression as old with the latest definitions of types.
+func (rn *LgoRunner) checkCompatible(new, old types.Type, seen map[*types.Named]bool) error {
+	if new != nil && types.Identical(new, old) {
+		return nil
+	}
+	switch o := old.(type) {
+	case *types.Named:
+		target := rn.latestNamed(o)
+		if new != nil {
+			n, ok := new.(*types.Named)
+			if !ok || n.Obj().Name() != o.Obj().Name() {
+				break
+			}
+			target = n
+		}
+		if target == nil {
+			return fmt.Errorf("%s is not defined", o.Obj().Name())
+		}
+		if types.Identical(target, o) || seen[o] {
+			return nil
+		}
+		seen[o] = true
+		return rn.checkCompatible(target.Underlying(), o.Underlying(), seen)
+	case *types.Basic:
+		if new == nil {
+			return nil
+		}
+		if _, ok := new.(*types.Basic); ok && types.ConvertibleTo(old, new) {
+			return nil
+		}
+	case *types.Pointer:
+		var elem types.Type
+		if n, ok := new.(*types.Pointer); ok {
+			elem = n.Elem()
+		} else if new != nil {
+			break
+		}
+		return rn.checkCompatible(elem, o.Elem(), seen)
+	case *types.Slice:
+		var elem types.Type
+		if n, ok := new.(*types.Slice); ok {
+			elem = n.Elem()
+		} else if new != nil {
+			break
+		}
+		return rn.checkCompatible(elem, o.Elem(), seen)
+	case *types.Array:
+		var elem types.Type
+		if n, ok := new.(*types.Array); ok && n.Len() == o.Len() {
+			elem = n.Elem()
+		} else if new != nil {
+			break
+		}
+		return rn.checkCompatible(elem, o.Elem(), seen)
+	case *types.Map:
+		var key, elem types.Type
+		if n, ok := new.(*types.Map); ok {
+			key, elem = n.Key(), n.Elem()
+		} else if new != nil {
+			break
+		}
+		if err := rn.checkCompatible(key, o.Key(), seen); err != nil {
+			return err
+		}
+		return rn.checkCompatible(elem, o.Elem(), seen)
+	case *types.Struct:
+		n, ok := new.(*types.Struct)
+		if new != nil && !ok {
+			break
+		}
+		// Fields are copied by names. Fields that exist only in one of the types are ignored.
+		for i := 0; i < o.NumFields(); i++ {
+			of := o.Field(i)
+			var ft types.Type
+			if n != nil {
+				ft = fieldType(n, of.Name())
+				if ft == nil {
+					continue
+				}
+			}
+			if err := rn.checkCompatible(ft, of.Type(), seen); err != nil {
+				return fmt.Errorf("%s: %v", of.Name(), err)
+			}
+		}
+		return nil
+	default:
+		// Functions, interfaces and channels are migrated only if they do not depend on types in the session.
+		if new == nil && !rn.refersToSessionType(old) {
+			return nil
+		}
+	}
+	if new == nil {
+		return fmt.Errorf("%s can not be migrated", types.TypeString(old, rn.qualifier))
+	}
+	return fmt.Errorf("%s is not convertible to %s", types.TypeString(old, rn.qualifier), types.TypeString(new, rn.qualifier))
+}
+
+// qualifier qualifies types in packages other than the session by package names.
+func (rn *LgoRunner) qualifier(pkg *types.Package) string {
+	if rn.isSessionPackage(pkg) {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// fieldType returns the type of the field of name in s or nil if s does not have the field.
+func fieldType(s *types.Struct, name string) types.Type {
+	for i := 0; i < s.NumFields(); i++ {
+		if s.Field(i).Name() == name {
+			return s.Field(i).Type()
+		}
+	}
+	return nil
+}
+
+// refersToSessionType returns true if typ refers to types defined in the session.
+func (rn *LgoRunner) refersToSessionType(typ types.Type) bool {
+	return strings.Contains(types.TypeString(typ, nil), rn.sessPkgPrefix())
+}
+
+// typeExpr returns the expression of typ in lgo code. Types defined in the session are referred to by their
+// names, which resolve to the latest definitions.
+func (rn *LgoRunner) typeExpr(typ types.Type) (string, error) {
+	var err error
+	s := types.TypeString(typ, func(pkg *types.Package) string {
+		if rn.isSessionPackage(pkg) {
+			return ""
+		}
+		for name, im := range rn.imports {
+			if im.Imported().Path() == pkg.Path() {
+				return name
+			}
+		}
+		err = fmt.Errorf("%s is not imported", pkg.Path())
+		return pkg.Name()
+	})
+	return s, err
+}
+
+// runMigrate implements %migrate, which converts values of stale variables (or variables specified by arguments)
+// to the latest definitions of their types. Fields of structs are copied by names.
+func runMigrate(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	names := strings.Fields(m.Args)
+	if len(names) == 0 {
+		for _, name := range rn.staleNames() {
+			if _, ok := rn.vars[name].(*types.Var); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stdout, "No stale variables")
+		return nil
+	}
+	code, err := rn.migrateVars(ctx, names)
+	if err != nil {
+		return err
+	}
+	rn.record(code)
+	fmt.Fprintf(os.Stdout, "Migrated %s\n", strings.Join(names, ", "))
+	if names := rn.staleNames(); len(names) > 0 {
+		fmt.Fprintf(os.Stderr, "%s still refer to the old definitions. Run %%rerun to update them\n", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// migrateVars declares variables of names again with the latest definitions of their types and copies their values.
+// migrateVars returns lgo code that converts the values in the same way. The code is recorded in the journal so that
+// programs exported by lgo export reproduce the migration.
+func (rn *LgoRunner) migrateVars(ctx core.LgoContext, names []string) (string, error) {
+	var decls []string
+	var olds []interface{}
+	var oldTypes []types.Type
+	for _, name := range names {
+		v, ok := rn.vars[name].(*types.Var)
+		if !ok {
+			return "", fmt.Errorf("%s is not a variable", name)
+		}
+		if err := rn.checkMigratable(v.Type()); err != nil {
+			return "", fmt.Errorf("can not migrate %s: %v", name, err)
+		}
+		expr, err := rn.typeExpr(v.Type())
+		if err != nil {
+			return "", fmt.Errorf("can not migrate %s: %v", name, err)
+		}
+		old := core.VarPointer(name)
+		if old == nil {
+			return "", fmt.Errorf("the value of %s is not found", name)
+		}
+		decls = append(decls, fmt.Sprintf("var %s %s", name, expr))
+		olds = append(olds, old)
+		oldTypes = append(oldTypes, v.Type())
+	}
+	if err := rn.runCode(ctx, strings.Join(decls, "\n"), nil); err != nil {
+		return "", err
+	}
+	var news []interface{}
+	var code []string
+	for i, name := range names {
+		news = append(news, core.VarPointer(name))
+		code = append(code, rn.migrationCode(name, oldTypes[i]))
+	}
+	if err := core.MigrateValues(news, olds); err != nil {
+		return "", err
+	}
+	return strings.Join(code, "\n"), nil
+}
+
+// migrationCode returns lgo code that converts the value of the variable of name whose type was old to the latest
+// definition of the variable. If the conversion can not be written in Go (e.g. values of recursive types), it
+// returns the declaration of the variable with the zero value.
+func (rn *LgoRunner) migrationCode(name string, old types.Type) string {
+	typ := rn.vars[name].Type()
+	expr, err := rn.migrationExpr(typ, old, name, make(map[*types.Named]bool))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The value of %s is not recorded in the journal: %v\n", name, err)
+		decl, _ := rn.typeExpr(typ)
+		return fmt.Sprintf("var %s %s", name, decl)
+	}
+	return fmt.Sprintf("%s := %s", name, expr)
+}
+
+// migrationExpr returns an expression that converts x of old to new like core.MigrateValues except that pointers
+// shared in x are not shared in the result. seen keeps named types being converted to detect recursive types.
+func (rn *LgoRunner) migrationExpr(new, old types.Type, x string, seen map[*types.Named]bool) (string, error) {
+	if types.Identical(new, old) {
+		return x, nil
+	}
+	typ, err := rn.typeExpr(new)
+	if err != nil {
+		return "", err
+	}
+	if o, ok := old.(*types.Named); ok {
+		n, ok := new.(*types.Named)
+		if !ok {
+			return "", fmt.Errorf("%s is not convertible to %s", types.TypeString(old, rn.qualifier), typ)
+		}
+		if seen[o] {
+			return "", fmt.Errorf("%s is recursive", o.Obj().Name())
+		}
+		seen[o] = true
+		defer delete(seen, o)
+		new, old = n.Underlying(), o.Underlying()
+	}
+	switch o := old.(type) {
+	case *types.Basic:
+		return fmt.Sprintf("%s(%s)", typ, x), nil
+	case *types.Pointer:
+		elem, err := rn.migrationExpr(new.(*types.Pointer).Elem(), o.Elem(), "*o", seen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func() %s { o := %s; if o == nil { return nil }; v := %s; return &v }()", typ, x, elem), nil
+	case *types.Slice:
+		elem, err := rn.migrationExpr(new.(*types.Slice).Elem(), o.Elem(), "o[i]", seen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func() %s { o := %s; if o == nil { return nil }; v := make(%s, len(o), cap(o)); "+
+			"for i := range o { v[i] = %s }; return v }()", typ, x, typ, elem), nil
+	case *types.Array:
+		elem, err := rn.migrationExpr(new.(*types.Array).Elem(), o.Elem(), "o[i]", seen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func() (v %s) { o := %s; for i := range o { v[i] = %s }; return }()", typ, x, elem), nil
+	case *types.Map:
+		n := new.(*types.Map)
+		key, err := rn.migrationExpr(n.Key(), o.Key(), "k", seen)
+		if err != nil {
+			return "", err
+		}
+		elem, err := rn.migrationExpr(n.Elem(), o.Elem(), "e", seen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func() %s { o := %s; if o == nil { return nil }; v := make(%s, len(o)); "+
+			"for k, e := range o { v[%s] = %s }; return v }()", typ, x, typ, key, elem), nil
+	case *types.Struct:
+		n := new.(*types.Struct)
+		var stmts []string
+		// Fields are copied by names like checkCompatible.
+		for i := 0; i < o.NumFields(); i++ {
+			of := o.Field(i)
+			ft := fieldType(n, of.Name())
+			if ft == nil {
+				continue
+			}
+			f, err := rn.migrationExpr(ft, of.Type(), "o."+of.Name(), seen)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", of.Name(), err)
+			}
+			stmts = append(stmts, fmt.Sprintf("v.%s = %s; ", of.Name(), f))
+		}
+		return fmt.Sprintf("func() (v %s) { o := %s; %sreturn }()", typ, x, strings.Join(stmts, "")), nil
+	}
+	return "", fmt.Errorf("%s is not convertible to %s", types.TypeString(old, rn.qualifier), typ)
+}
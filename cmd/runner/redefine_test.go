@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+// defineCell records a cell of the execution exec that defines names and refers to refs as runCode does.
+// Names starting with upper case letters are types and the others are variables.
+func defineCell(rn *LgoRunner, exec string, names []string, refs ...types.Object) (*executedCell, []types.Object) {
+	pkg := types.NewPackage(rn.sessPkgPrefix()+exec, "lgo_exec")
+	var defs []types.Object
+	for _, name := range names {
+		var obj types.Object
+		if ast.IsExported(name) {
+			obj = types.NewTypeName(token.NoPos, pkg, name, nil)
+		} else {
+			obj = types.NewVar(token.NoPos, pkg, name, types.Typ[types.Int])
+		}
+		rn.vars[name] = obj
+		defs = append(defs, obj)
+	}
+	refSet := make(map[types.Object]bool)
+	for _, ref := range refs {
+		refSet[ref] = true
+	}
+	return rn.addExecutedCell(exec, defs, refSet), defs
+}
+
+func TestMarkStale(t *testing.T) {
+	rn := &LgoRunner{sessID: &SessionID{Time: 1}, vars: make(map[string]types.Object)}
+	_, point := defineCell(rn, "exec1", []string{"Point"})
+	defineCell(rn, "exec2", []string{"p", "norm"}, point[0])
+	_, line := defineCell(rn, "exec3", []string{"Line"}, point[0])
+	defineCell(rn, "exec4", []string{"l"}, line[0])
+	// x refers to another type named Point.
+	imagePoint := types.NewTypeName(token.NoPos, types.NewPackage("image", "image"), "Point", nil)
+	defineCell(rn, "exec5", []string{"x"}, imagePoint)
+	cur, newPoint := defineCell(rn, "exec6", []string{"Point"})
+	if got, want := rn.markStale(cur, point), []string{"Line", "l", "norm", "p"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("markStale() = %v; want %v", got, want)
+	}
+	// Redefinitions clear stale names.
+	cur, _ = defineCell(rn, "exec7", []string{"p"}, newPoint[0])
+	if got, want := rn.staleNames(), []string{"Line", "l", "norm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("staleNames() = %v; want %v", got, want)
+	}
+	if got := rn.markStale(cur, nil); len(got) != 0 {
+		t.Errorf("markStale() = %v; want nothing", got)
+	}
+}
+
+func TestCellRefs(t *testing.T) {
+	fset := token.NewFileSet()
+	check := func(path, src string, imp types.Importer) (*types.Package, *types.Info) {
+		f, err := goparser.ParseFile(fset, "", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := &types.Info{Uses: make(map[*ast.Ident]types.Object)}
+		pkg, err := (&types.Config{Importer: imp}).Check(path, fset, []*ast.File{f}, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkg, info
+	}
+	exec1, _ := check("exec1", "package exec1\n\ntype Point struct{ X int }\n\nvar P Point\n", nil)
+	_, info := check("exec2", `package exec2
+
+import "exec1"
+
+func f() int {
+	// A local variable named like the type in exec1.
+	Point := 1
+	return Point
+}
+
+var q = exec1.P
+`, pkgImporter{"exec1": exec1})
+	point, p := exec1.Scope().Lookup("Point"), exec1.Scope().Lookup("P")
+	got := cellRefs(info.Uses, []types.Object{point, p})
+	if want := map[types.Object]bool{p: true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cellRefs() = %v; want %v", got, want)
+	}
+}
+
+type pkgImporter map[string]*types.Package
+
+func (im pkgImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := im[path]; ok {
+		return pkg, nil
+	}
+	return nil, fmt.Errorf("%s is not found", path)
+}
+
+// sessionStruct defines a struct type of name in the execution of the session of rn.
+func sessionStruct(rn *LgoRunner, exec, name string, fields ...*types.Var) *types.Named {
+	pkg := types.NewPackage(rn.sessPkgPrefix()+exec, "lgo_exec")
+	tn := types.NewTypeName(token.NoPos, pkg, name, nil)
+	return types.NewNamed(tn, types.NewStruct(fields, nil), nil)
+}
+
+func field(name string, typ types.Type) *types.Var {
+	return types.NewField(token.NoPos, nil, name, typ, false)
+}
+
+func TestCheckMigratable(t *testing.T) {
+	rn := &LgoRunner{sessID: &SessionID{Time: 1}, vars: make(map[string]types.Object)}
+	oldPoint := sessionStruct(rn, "exec1", "Point", field("X", types.Typ[types.Int]), field("Name", types.Typ[types.String]))
+	newPoint := sessionStruct(rn, "exec3", "Point", field("X", types.Typ[types.Int64]), field("Y", types.Typ[types.Int]))
+	rn.vars["Point"] = newPoint.Obj()
+
+	typ := types.NewMap(types.Typ[types.String], types.NewSlice(types.NewPointer(oldPoint)))
+	if err := rn.checkMigratable(typ); err != nil {
+		t.Errorf("checkMigratable(%v) failed: %v", typ, err)
+	}
+	if got, err := rn.typeExpr(typ); err != nil || got != "map[string][]*Point" {
+		t.Errorf("typeExpr(%v) = %q, %v", typ, got, err)
+	}
+
+	fn := types.NewSignature(nil, types.NewTuple(types.NewVar(token.NoPos, nil, "p", oldPoint)), nil, false)
+	if err := rn.checkMigratable(fn); err == nil || err.Error() != "func(p Point) can not be migrated" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	rn.vars["Point"] = sessionStruct(rn, "exec4", "Point", field("X", types.NewSlice(types.Typ[types.Int]))).Obj()
+	if err := rn.checkMigratable(oldPoint); err == nil || err.Error() != "X: int is not convertible to []int" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMigrationCode(t *testing.T) {
+	rn := &LgoRunner{sessID: &SessionID{Time: 1}, vars: make(map[string]types.Object)}
+	oldPoint := sessionStruct(rn, "exec1", "Point", field("X", types.Typ[types.Int]), field("Name", types.Typ[types.String]))
+	newPoint := sessionStruct(rn, "exec3", "Point", field("X", types.Typ[types.Int64]), field("Y", types.Typ[types.Int]))
+	rn.vars["Point"] = newPoint.Obj()
+	pkg := types.NewPackage(rn.sessPkgPrefix()+"exec4", "lgo_exec")
+	rn.vars["ps"] = types.NewVar(token.NoPos, pkg, "ps", types.NewSlice(types.NewPointer(newPoint)))
+
+	got := rn.migrationCode("ps", types.NewSlice(types.NewPointer(oldPoint)))
+	want := "ps := func() []*Point { o := ps; if o == nil { return nil }; v := make([]*Point, len(o), cap(o)); " +
+		"for i := range o { v[i] = func() *Point { o := o[i]; if o == nil { return nil }; " +
+		"v := func() (v Point) { o := *o; v.X = int64(o.X); return }(); return &v }() }; return v }()"
+	if got != want {
+		t.Errorf("migrationCode() = %q; want %q", got, want)
+	}
+	if _, err := goparser.ParseExpr(want[len("ps := "):]); err != nil {
+		t.Errorf("migrationCode() returned invalid code: %v", err)
+	}
+
+	// Values of recursive types are declared with the zero values.
+	node := func(exec string, fields ...*types.Var) *types.Named {
+		tn := types.NewTypeName(token.NoPos, types.NewPackage(rn.sessPkgPrefix()+exec, "lgo_exec"), "Node", nil)
+		n := types.NewNamed(tn, nil, nil)
+		n.SetUnderlying(types.NewStruct(append(fields, field("Next", types.NewPointer(n))), nil))
+		return n
+	}
+	newNode := node("exec3", field("Val", types.Typ[types.Int]))
+	rn.vars["Node"] = newNode.Obj()
+	rn.vars["n"] = types.NewVar(token.NoPos, pkg, "n", newNode)
+	if got, want := rn.migrationCode("n", node("exec1")), "var n Node"; got != want {
+		t.Errorf("migrationCode() = %q; want %q", got, want)
+	}
+}
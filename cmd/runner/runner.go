@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"go/build"
-	"go/scanner"
 	"go/token"
 	"go/types"
 	"io"
@@ -14,6 +13,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/yunabe/lgo/cmd/install"
@@ -27,7 +27,11 @@ import (
 */
 import "C"
 
-func loadShared(ctx core.LgoContext, buildPkgDir, pkgPath string) error {
+// execHook wraps the execution of lgo code. A hook must call exec once and return its result.
+// It is used to run code before and after the execution (e.g. profiling).
+type execHook func(exec func() error) error
+
+func loadShared(ctx core.LgoContext, buildPkgDir, pkgPath string, hook execHook) error {
 	// This code is implemented based on https://golang.org/src/plugin/plugin_dlopen.go
 	sofile := "lib" + strings.Replace(pkgPath, "/", "-", -1) + ".so"
 	handle := C.dlopen(C.CString(path.Join(buildPkgDir, sofile)), C.RTLD_NOW|C.RTLD_GLOBAL)
@@ -58,9 +62,15 @@ func loadShared(ctx core.LgoContext, buildPkgDir, pkgPath string) error {
 	}
 	lgoInitFuncP := &lgoInitFuncPC
 	lgoInitFunc := *(*func())(unsafe.Pointer(&lgoInitFuncP))
-	return core.ExecLgoEntryPoint(ctx, func() {
-		lgoInitFunc()
-	})
+	exec := func() error {
+		return core.ExecLgoEntryPoint(ctx, func() {
+			lgoInitFunc()
+		})
+	}
+	if hook != nil {
+		return hook(exec)
+	}
+	return exec()
 }
 
 func loadSharedInternal(buildPkgDir, pkgPath string) {
@@ -72,15 +82,43 @@ type LgoRunner struct {
 	execCount int64
 	vars      map[string]types.Object
 	imports   map[string]*types.PkgName
+	// lineMaps keeps LineMap of each execution. The key is the package path of the execution.
+	// lineMaps is protected by lineMapsMu because stack traces are rewritten in goroutines of lgo code.
+	lineMaps   map[string]converter.LineMap
+	lineMapsMu sync.Mutex
+	// benchResults keeps the results of %%bench in the order of executions.
+	benchResults []*benchResult
+	// examples keeps the expected outputs of example functions declared in cells.
+	examples map[string]exampleOutput
+	// journal records lgo code executed successfully if it's not nil. See SetJournal.
+	journal io.Writer
+	// module is the module context of the session. It's nil until %require is executed.
+	module *sessionModule
+	// definedIn maps the latest definitions in the session to the cells that defined them.
+	definedIn map[types.Object]*executedCell
+	cellSeq   int
+	// stale is the set of definitions that refer to old definitions of redefined types.
+	stale map[types.Object]bool
+	// subExec is the index of lgo code in the current execution if the execution runs code of multiple cells
+	// (e.g. %rerun). It's 0 otherwise.
+	subExec int
+	// typeSrcs keeps sources of types declared in the session to add methods to them in later cells.
+	typeSrcs map[string]*typeSource
 }
 
+// NewLgoRunner returns a new LgoRunner. NewLgoRunner also configures core to show positions in cells
+// in stack traces of lgo code.
 func NewLgoRunner(lgopath string, sessID *SessionID) *LgoRunner {
-	return &LgoRunner{
-		lgopath: lgopath,
-		sessID:  sessID,
-		vars:    make(map[string]types.Object),
-		imports: make(map[string]*types.PkgName),
+	rn := &LgoRunner{
+		lgopath:  lgopath,
+		sessID:   sessID,
+		vars:     make(map[string]types.Object),
+		imports:  make(map[string]*types.PkgName),
+		lineMaps: make(map[string]converter.LineMap),
+		examples: make(map[string]exampleOutput),
 	}
+	core.SetTracebackRewriter(rn.RewriteTraceback)
+	return rn
 }
 
 func (rn *LgoRunner) ExecCount() int64 {
@@ -91,8 +129,8 @@ func (rn *LgoRunner) cleanFiles(pkgPath string) {
 	// Delete src files
 	os.RemoveAll(path.Join(build.Default.GOPATH, "src", pkgPath))
 	libname := "lib" + strings.Replace(pkgPath, "/", "-", -1) + ".so"
-	os.RemoveAll(path.Join(rn.lgopath, "pkg", libname))
-	os.RemoveAll(path.Join(rn.lgopath, "pkg", pkgPath))
+	os.RemoveAll(path.Join(rn.PkgDir(), libname))
+	os.RemoveAll(path.Join(rn.PkgDir(), pkgPath))
 }
 
 func (rn *LgoRunner) isCtxDone(ctx context.Context) bool {
@@ -104,32 +142,6 @@ func (rn *LgoRunner) isCtxDone(ctx context.Context) bool {
 	}
 }
 
-const maxErrLines = 5
-
-// PrintError prints err to w.
-// If err is scanner.ErrorList or convert.ErrorList, it expands internal errors.
-func PrintError(w io.Writer, err error) {
-	var length int
-	var get func(int) error
-	if lst, ok := err.(scanner.ErrorList); ok {
-		length = len(lst)
-		get = func(i int) error { return lst[i] }
-	} else if lst, ok := err.(converter.ErrorList); ok {
-		length = len(lst)
-		get = func(i int) error { return lst[i] }
-	} else {
-		fmt.Fprintln(w, err.Error())
-		return
-	}
-	for i := 0; i < maxErrLines && i < length; i++ {
-		msg := get(i).Error()
-		if i == maxErrLines-1 && i != length-1 {
-			msg += fmt.Sprintf(" (and %d more errors)", length-1-i)
-		}
-		fmt.Fprintln(w, msg)
-	}
-}
-
 // installDeps installs .so files for dependencies if .so files are not installed in $LGOPATH.
 func (rn *LgoRunner) installDeps(deps []string) error {
 	var need []string
@@ -137,7 +149,7 @@ func (rn *LgoRunner) installDeps(deps []string) error {
 		if path == "C" || install.IsStdPkg(path) {
 			continue
 		}
-		if !install.IsSOInstalled(rn.lgopath, path) {
+		if !install.IsSOInstalled(rn.buildRoot(), path) {
 			need = append(need, path)
 		}
 	}
@@ -145,15 +157,48 @@ func (rn *LgoRunner) installDeps(deps []string) error {
 		return nil
 	}
 	fmt.Fprintf(os.Stderr, "found packages not installed in LGOPATH: %v\n", need)
-	return install.NewSOInstaller(rn.lgopath).Install(need...)
+	si := install.NewSOInstaller(rn.buildRoot())
+	si.Env = rn.GoEnv()
+	return si.Install(need...)
 }
 
 const lgoExportPrefix = "LgoExport_"
 
+// lgoInitFuncName is the name of the function that executes code at the top level of a cell.
+const lgoInitFuncName = "lgo_init"
+
+// Run executes src. src is lgo code or a magic command (e.g. %whos).
 func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
+	// Magic commands are counted too to keep the count in sync with execution counts of Jupyter.
 	rn.execCount++
+	if m := parseMagic(src); m != nil {
+		err := rn.runMagic(ctx, m)
+		// %require is recorded because lgo export converts it into go.mod.
+		if err == nil && m.Name == "require" && m.Args != "" {
+			rn.record(src)
+		}
+		return err
+	}
+	if c, cmds := rn.parseShell(src); c != nil {
+		return rn.runShellCapture(ctx, c)
+	} else if len(cmds) > 0 {
+		return rn.runShellEscapes(ctx, cmds)
+	}
+	if err := rn.runCode(ctx, src, nil); err != nil {
+		return err
+	}
+	rn.record(src)
+	return nil
+}
+
+// runCode executes lgo code in src as the current execution. hook is applied to the execution if it's not nil.
+func (rn *LgoRunner) runCode(ctx core.LgoContext, src string, hook execHook) error {
 	sessDir := "github.com/yunabe/lgo/" + rn.sessID.Marshal()
-	pkgPath := path.Join(sessDir, fmt.Sprintf("exec%d", rn.execCount))
+	pkgName := fmt.Sprintf("exec%d", rn.execCount)
+	if rn.subExec > 0 {
+		pkgName += fmt.Sprintf("_%d", rn.subExec)
+	}
+	pkgPath := path.Join(sessDir, pkgName)
 	var olds []types.Object
 	for _, obj := range rn.vars {
 		olds = append(olds, obj)
@@ -162,7 +207,13 @@ func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
 	for _, im := range rn.imports {
 		oldImports = append(oldImports, im)
 	}
-	result := converter.Convert(src, &converter.Config{
+	// Methods of types declared in previous cells are declared with the types and their previous methods.
+	code := src
+	extended, extra := rn.extendTypes(src)
+	if extra != "" {
+		code = src + "\n\n" + extra
+	}
+	result := converter.Convert(code, &converter.Config{
 		Olds:         olds,
 		OldImports:   oldImports,
 		DefPrefix:    lgoExportPrefix,
@@ -175,12 +226,34 @@ func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
 	if result.Err != nil {
 		return result.Err
 	}
+	if extra != "" {
+		dropExtraLines(result.LineMap, src)
+	}
+	rn.setLineMap(pkgPath, result.LineMap)
+	redefined := rn.redefinedTypes(result.Pkg.Scope())
 	for _, name := range result.Pkg.Scope().Names() {
 		rn.vars[name] = result.Pkg.Scope().Lookup(name)
+		delete(rn.examples, name)
+	}
+	rn.recordTypeSources(code)
+	for name, out := range exampleOutputs(src) {
+		rn.examples[name] = out
 	}
 	for _, im := range result.Imports {
 		rn.imports[im.Name()] = im
 	}
+	var defs []types.Object
+	for _, name := range result.Pkg.Scope().Names() {
+		defs = append(defs, result.Pkg.Scope().Lookup(name))
+	}
+	cell := rn.addExecutedCell(src, defs, cellRefs(result.Checker.Uses, olds))
+	var rebind []string
+	if len(extended) > 0 && len(extended) == len(redefined) {
+		// Variables that refer to types extended with methods are converted to the new definitions after the execution.
+		rebind = rn.markStale(cell, redefined)
+	} else {
+		rn.reportStale(cell, redefined)
+	}
 	if len(result.Src) == 0 {
 		// No declarations or expressions in the original source (e.g. only import statements).
 		return nil
@@ -195,18 +268,30 @@ func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
 		return err
 	}
 	if err := rn.installDeps(result.FinalDeps); err != nil {
-		return err
+		return &BuildError{Err: err}
 	}
 
-	buildPkgDir := path.Join(rn.lgopath, "pkg")
+	buildPkgDir := rn.PkgDir()
 	cmd := exec.CommandContext(ctx, "go", "install", "-buildmode=shared", "-linkshared", "-pkgdir", buildPkgDir, pkgPath)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Env = rn.GoEnv()
+	var out bytes.Buffer
+	cmd.Stderr = &out
+	cmd.Stdout = &out
 	err = cmd.Run()
 	if err != nil {
-		return fmt.Errorf("Failed to build a shared library of %s: %v", pkgPath, err)
+		return &BuildError{
+			Output: rn.RewriteTraceback(out.String()),
+			Err:    fmt.Errorf("Failed to build a shared library of %s: %v", pkgPath, err),
+		}
+	}
+	os.Stderr.Write(out.Bytes())
+	if err := loadShared(ctx, buildPkgDir, pkgPath, hook); err != nil {
+		return err
+	}
+	if len(extended) > 0 {
+		return rn.rebindVars(ctx, extended, rebind)
 	}
-	return loadShared(ctx, buildPkgDir, pkgPath)
+	return nil
 }
 
 func (rn *LgoRunner) Complete(ctx context.Context, src string, index int) (matches []string, start, end int) {
@@ -252,6 +337,7 @@ func (rn *LgoRunner) Inspect(ctx context.Context, src string, index int) (string
 		return "", nil
 	}
 	cmd := exec.CommandContext(ctx, "go", "doc", query)
+	cmd.Env = rn.GoEnv()
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	if err := cmd.Run(); err != nil {
@@ -0,0 +1,174 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// shellCapturePattern matches shell escapes whose output is assigned to a variable (e.g. "files := !ls").
+var shellCapturePattern = regexp.MustCompile(`^\s*([\pL_][\pL\pN_]*)\s*(:?=)\s*!(.*)$`)
+
+// shellCapture is a shell command whose output is assigned to a variable.
+type shellCapture struct {
+	Name string
+	// Op is ":=" or "=".
+	Op      string
+	Command string
+}
+
+// parseShellCapture parses src as a shell escape whose output is assigned to a variable.
+// parseShellCapture returns nil if src is not in the form of "name := !command".
+func parseShellCapture(src string) *shellCapture {
+	src = strings.TrimSpace(src)
+	if strings.Contains(src, "\n") {
+		return nil
+	}
+	m := shellCapturePattern.FindStringSubmatch(src)
+	if m == nil || strings.TrimSpace(m[3]) == "" {
+		return nil
+	}
+	return &shellCapture{Name: m[1], Op: m[2], Command: strings.TrimSpace(m[3])}
+}
+
+// parseShellEscapes parses src as lines of shell escapes (e.g. "!ls").
+// parseShellEscapes returns nil if src contains lines that are not shell escapes.
+func parseShellEscapes(src string) []string {
+	var cmds []string
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "!") {
+			return nil
+		}
+		cmds = append(cmds, strings.TrimSpace(line[1:]))
+	}
+	return cmds
+}
+
+// isGoExpr reports whether s is a Go expression that refers only to names defined in the session.
+// This distinguishes Go code like "!ok" and "x := !ok" from shell escapes like "!ls" and "files := !ls".
+func (rn *LgoRunner) isGoExpr(s string) bool {
+	expr, err := goparser.ParseExpr(s)
+	if err != nil {
+		return false
+	}
+	known := true
+	var check func(n ast.Node) bool
+	check = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// Only the operand is resolved in the session (e.g. strings in strings.HasPrefix).
+			ast.Inspect(n.X, check)
+			return false
+		case *ast.Ident:
+			if rn.vars[n.Name] == nil && rn.imports[n.Name] == nil && types.Universe.Lookup(n.Name) == nil {
+				known = false
+			}
+		}
+		return known
+	}
+	ast.Inspect(expr, check)
+	return known
+}
+
+// parseShell parses src as shell escapes. It returns a non-nil capture if src assigns the output of a command
+// to a variable or non-empty cmds if src consists of shell escapes. Go code that looks like shell escapes (e.g. "!ok") is not parsed.
+func (rn *LgoRunner) parseShell(src string) (capture *shellCapture, cmds []string) {
+	if c := parseShellCapture(src); c != nil && !rn.isGoExpr(c.Command) {
+		return c, nil
+	}
+	cmds = parseShellEscapes(src)
+	for _, c := range cmds {
+		if rn.isGoExpr(c) {
+			return nil, nil
+		}
+	}
+	return nil, cmds
+}
+
+// runShell runs script with shell in env. The output of the command is written to stdout and os.Stderr.
+// The command and its child processes are killed when ctx is canceled.
+func runShell(ctx context.Context, env []string, shell, script string, stdout io.Writer) error {
+	cmd := exec.Command(shell, "-c", script)
+	cmd.Env = env
+	cmd.Stdout = stdout
+	// Read os.Stderr when the command runs because the kernel replaces it with a pipe to the notebook.
+	cmd.Stderr = os.Stderr
+	// Run the command in a new process group to kill child processes (e.g. pipelines) on cancellation.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// runShellEscapes runs shell escapes in a cell one by one. It stops at the first command that fails.
+func (rn *LgoRunner) runShellEscapes(ctx core.LgoContext, cmds []string) error {
+	for _, c := range cmds {
+		if err := runShell(ctx, rn.GoEnv(), "sh", c, os.Stdout); err != nil {
+			return fmt.Errorf("!%s: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// runShellCapture runs the command of c and assigns the lines of its output to the variable of c as []string.
+func (rn *LgoRunner) runShellCapture(ctx core.LgoContext, c *shellCapture) error {
+	var buf bytes.Buffer
+	if err := runShell(ctx, rn.GoEnv(), "sh", c.Command, &buf); err != nil {
+		return fmt.Errorf("!%s: %v", c.Command, err)
+	}
+	lines := []string{}
+	if out := strings.TrimSuffix(buf.String(), "\n"); out != "" {
+		lines = strings.Split(out, "\n")
+	}
+	return rn.runCode(ctx, shellCaptureCode(c, lines), nil)
+}
+
+// shellCaptureCode returns lgo code that assigns lines to the variable of c.
+func shellCaptureCode(c *shellCapture, lines []string) string {
+	var quoted []string
+	for _, l := range lines {
+		quoted = append(quoted, strconv.Quote(l))
+	}
+	return fmt.Sprintf("%s %s []string{%s}", c.Name, c.Op, strings.Join(quoted, ", "))
+}
+
+// runBash implements %%bash, which runs the body of the cell with bash.
+func runBash(rn *LgoRunner, ctx core.LgoContext, m *magic) error {
+	if m.Args != "" {
+		return fmt.Errorf("%s does not take arguments", m)
+	}
+	if err := runShell(ctx, rn.GoEnv(), "bash", m.Body, os.Stdout); err != nil {
+		return fmt.Errorf("%s: %v", m, err)
+	}
+	return nil
+}
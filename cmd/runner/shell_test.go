@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseShellCapture(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *shellCapture
+	}{
+		{"files := !ls -l", &shellCapture{Name: "files", Op: ":=", Command: "ls -l"}},
+		{"  x=!echo a=b  ", &shellCapture{Name: "x", Op: "=", Command: "echo a=b"}},
+		{"x := !", nil},
+		{"x := !ok", &shellCapture{Name: "x", Op: ":=", Command: "ok"}},
+		{"x := y != z", nil},
+		{"!ls", nil},
+		{"x := !ls\ny := 10", nil},
+	}
+	for _, tc := range tests {
+		if got := parseShellCapture(tc.src); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseShellCapture(%q) = %+v; want %+v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseShellEscapes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"!ls", []string{"ls"}},
+		{"\n!go list ./...\n\n  ! pwd \n", []string{"go list ./...", "pwd"}},
+		{"!ls\nx := 10", nil},
+		{"(!ok)", nil},
+		{"", nil},
+	}
+	for _, tc := range tests {
+		if got := parseShellEscapes(tc.src); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseShellEscapes(%q) = %q; want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseShell(t *testing.T) {
+	rn := &LgoRunner{
+		vars: map[string]types.Object{
+			"ok": types.NewVar(token.NoPos, nil, "ok", types.Typ[types.Bool]),
+		},
+		imports: map[string]*types.PkgName{
+			"strings": types.NewPkgName(token.NoPos, nil, "strings", types.NewPackage("strings", "strings")),
+		},
+	}
+	tests := []struct {
+		src     string
+		capture bool
+		cmds    []string
+	}{
+		{"!ls", false, []string{"ls"}},
+		{"files := !ls", true, nil},
+		{"!ok", false, nil},
+		{"x := !ok", false, nil},
+		{"!strings.HasPrefix(\"ab\", \"a\")", false, nil},
+		{"!ok\n!ls", false, nil},
+		{"!true", false, nil},
+		{"!unknown.Func()", false, []string{"unknown.Func()"}},
+	}
+	for _, tc := range tests {
+		c, cmds := rn.parseShell(tc.src)
+		if (c != nil) != tc.capture || !reflect.DeepEqual(cmds, tc.cmds) {
+			t.Errorf("parseShell(%q) = %+v, %q; want capture: %v, %q", tc.src, c, cmds, tc.capture, tc.cmds)
+		}
+	}
+}
+
+func TestShellCaptureCode(t *testing.T) {
+	c := &shellCapture{Name: "files", Op: ":="}
+	if got, want := shellCaptureCode(c, []string{"a.go", `"b"`}), `files := []string{"a.go", "\"b\""}`; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := shellCaptureCode(c, nil), "files := []string{}"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestRunShell(t *testing.T) {
+	var buf bytes.Buffer
+	if err := runShell(context.Background(), nil, "sh", "echo hello; echo world", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "hello\nworld\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if err := runShell(context.Background(), nil, "sh", "exit 3", &buf); err == nil || err.Error() != "exit status 3" {
+		t.Errorf("got %v; want exit status 3", err)
+	}
+}
+
+func TestRunShellCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The child process of sh must be killed too.
+	err := runShell(ctx, nil, "sh", "sleep 10 | cat", &bytes.Buffer{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v; want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("runShell took %v after cancellation", d)
+	}
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+// positionPattern matches positions in converted code (e.g. "/go/src/github.com/yunabe/lgo/sess.../exec3/src.go:12:5")
+// in stack traces and build errors. Program counter offsets in stack traces (e.g. " +0x25") are also matched.
+// The package of lgo code is execN or execN_M (the M-th code executed in the N-th cell).
+// Submatches are the session ID, the package, N and the line.
+var positionPattern = regexp.MustCompile(`\S*github\.com/yunabe/lgo/(` + idPrefix + `[0-9a-f]+)/(exec(\d+)(?:_\d+)?)/src\.go:(\d+)(?::\d+)?(?: \+0x[0-9a-f]+)?`)
+
+// cellPkgPattern matches package paths of lgo code (e.g. "github.com/yunabe/lgo/sess.../exec3") and
+// the package qualifiers of names in them (e.g. "github.com/yunabe/lgo/sess.../exec3.").
+// Submatches are the session ID, N and the qualifier or the qualified init function of the package.
+var cellPkgPattern = regexp.MustCompile(`github\.com/yunabe/lgo/(` + idPrefix + `[0-9a-f]+)/exec(\d+)(?:_\d+)?(\.` + lgoInitFuncName + `|\.)?`)
+
+func (rn *LgoRunner) setLineMap(pkgPath string, m converter.LineMap) {
+	rn.lineMapsMu.Lock()
+	defer rn.lineMapsMu.Unlock()
+	rn.lineMaps[pkgPath] = m
+}
+
+func (rn *LgoRunner) lineMap(pkgPath string) converter.LineMap {
+	rn.lineMapsMu.Lock()
+	defer rn.lineMapsMu.Unlock()
+	return rn.lineMaps[pkgPath]
+}
+
+// cellPosition returns the position in the cell that corresponds to line in converted code in pkg (e.g. exec3).
+func (rn *LgoRunner) cellPosition(pkg string, cell, line int) string {
+	orig := rn.lineMap(rn.sessPkgPrefix() + pkg).Original(line)
+	if orig == 0 {
+		return fmt.Sprintf("cell %d", cell)
+	}
+	return fmt.Sprintf("cell %d, line %d", cell, orig)
+}
+
+// RewriteTraceback rewrites positions and names in converted code in s (e.g. stack traces and build errors)
+// to positions and names in cells. For example,
+//
+//	github.com/yunabe/lgo/sess.../exec3.LgoExport_f()
+//		/go/src/github.com/yunabe/lgo/sess.../exec3/src.go:12 +0x25
+//
+// is rewritten to
+//
+//	f()
+//		cell 3, line 2
+func (rn *LgoRunner) RewriteTraceback(s string) string {
+	// Positions and packages of other sessions (e.g. in outputs of lgo code) are not rewritten.
+	sess := rn.sessID.Marshal()
+	s = positionPattern.ReplaceAllStringFunc(s, func(pos string) string {
+		m := positionPattern.FindStringSubmatch(pos)
+		if m[1] != sess {
+			return pos
+		}
+		cell, err := strconv.Atoi(m[3])
+		if err != nil {
+			return pos
+		}
+		line, err := strconv.Atoi(m[4])
+		if err != nil {
+			return pos
+		}
+		return rn.cellPosition(m[2], cell, line)
+	})
+	s = cellPkgPattern.ReplaceAllStringFunc(s, func(pkg string) string {
+		m := cellPkgPattern.FindStringSubmatch(pkg)
+		if m[1] != sess {
+			return pkg
+		}
+		switch m[3] {
+		case "":
+			// Package paths (e.g. "# github.com/yunabe/lgo/sess.../exec3" in build errors).
+			return "cell " + m[2]
+		case ".":
+			return ""
+		default:
+			// Code at the top level of cells is shown as cellN (e.g. cell3.func1 for a func literal in the third cell).
+			return "cell" + m[2]
+		}
+	})
+	return strings.Replace(s, lgoExportPrefix, "", -1)
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+func TestRewriteTraceback(t *testing.T) {
+	rn := &LgoRunner{
+		sessID:   &SessionID{Time: 1234},
+		lineMaps: make(map[string]converter.LineMap),
+	}
+	prefix := rn.sessPkgPrefix()
+	other := (&LgoRunner{sessID: &SessionID{Time: 5678}}).sessPkgPrefix()
+	rn.setLineMap(prefix+"exec3", converter.LineMap{0, 0, 1, 2, 0, 5})
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{
+			in: "goroutine 7 [running]:\n" +
+				prefix + "exec3.LgoExport_f(0x1)\n" +
+				"\t/go/src/" + prefix + "exec3/src.go:4 +0x25\n" +
+				prefix + "exec3.lgo_init.func1()\n" +
+				"\t/go/src/" + prefix + "exec3/src.go:6 +0x1a\n" +
+				"created by " + prefix + "exec3.lgo_init in goroutine 6\n" +
+				"\t/go/src/" + prefix + "exec3/src.go:5 +0x3f\n",
+			want: "goroutine 7 [running]:\n" +
+				"f(0x1)\n" +
+				"\tcell 3, line 2\n" +
+				"cell3.func1()\n" +
+				"\tcell 3, line 5\n" +
+				"created by cell3 in goroutine 6\n" +
+				"\tcell 3\n",
+		}, {
+			in: "# " + prefix + "exec3\n" +
+				"../../go/src/" + prefix + "exec3/src.go:3:10: undefined: LgoExport_y\n",
+			want: "# cell 3\n" +
+				"cell 3, line 1: undefined: y\n",
+		}, {
+			// Unknown cells.
+			in:   "/go/src/" + prefix + "exec9/src.go:12",
+			want: "cell 9",
+		}, {
+			// Other sessions and files which are not lgo code.
+			in:   "/go/src/" + other + "exec3/src.go:4 +0x25\n" + other + "exec3.LgoExport_f()\n/tmp/exec3/src.go:4",
+			want: "/go/src/" + other + "exec3/src.go:4 +0x25\n" + other + "exec3.f()\n/tmp/exec3/src.go:4",
+		},
+	}
+	for _, tc := range tests {
+		if got := rn.RewriteTraceback(tc.in); got != tc.want {
+			t.Errorf("RewriteTraceback(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yunabe/lgo/parser"
+)
+
+// PackageIndex finds packages that can be imported automatically.
+type PackageIndex interface {
+	// Lookup returns import paths of packages that may be named name.
+	Lookup(name string) []string
+}
+
+var pkgIndex PackageIndex
+
+// SetPackageIndex sets the index of packages used to import packages automatically.
+// Packages are not imported automatically if the index is nil.
+func SetPackageIndex(idx PackageIndex) {
+	pkgIndex = idx
+}
+
+// autoImport is a package imported automatically.
+type autoImport struct {
+	name string
+	path string
+}
+
+// findAutoImports finds packages to import for identifiers in blk that are used as package names
+// (e.g. strings in strings.Split) but are not declared. declared reports whether a name is declared outside blk.
+// A package is imported if it's the only package in the index that has the name and exports all the selected names.
+// findAutoImports returns an error if multiple packages match an identifier.
+func findAutoImports(fset *token.FileSet, blk *parser.LGOBlock, declared func(name string) bool) ([]autoImport, error) {
+	if pkgIndex == nil {
+		return nil, nil
+	}
+	unresolved := make(map[*ast.Ident]bool)
+	for _, id := range blk.Unresolved {
+		unresolved[id] = true
+	}
+	// sels maps undeclared names used as package names to the selected names.
+	sels := make(map[string]map[string]bool)
+	firstUse := make(map[string]*ast.Ident)
+	for _, stmt := range blk.Stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok || !unresolved[x] {
+				return true
+			}
+			if sels[x.Name] == nil {
+				sels[x.Name] = make(map[string]bool)
+				firstUse[x.Name] = x
+			}
+			sels[x.Name][sel.Sel.Name] = true
+			return true
+		})
+	}
+	var names []string
+	for name := range sels {
+		if name == runCtxName || types.Universe.Lookup(name) != nil || declared(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var imports []autoImport
+	for _, name := range names {
+		var matched []string
+		for _, path := range pkgIndex.Lookup(name) {
+			if exportsAll(path, name, sels[name]) {
+				matched = append(matched, path)
+			}
+		}
+		if len(matched) == 1 {
+			imports = append(imports, autoImport{name: name, path: matched[0]})
+		} else if len(matched) > 1 {
+			var quoted []string
+			for _, path := range matched {
+				quoted = append(quoted, strconv.Quote(path))
+			}
+			return nil, types.Error{
+				Fset: fset,
+				Pos:  firstUse[name].Pos(),
+				Msg:  fmt.Sprintf("%s matches multiple packages: %s. Import one of them explicitly", name, strings.Join(quoted, ", ")),
+			}
+		}
+	}
+	return imports, nil
+}
+
+// exportsAll returns true if the package of path is named name and exports all of sels.
+func exportsAll(path, name string, sels map[string]bool) bool {
+	pkg, err := lgoImporter.Import(path)
+	if err != nil || pkg.Name() != name {
+		return false
+	}
+	for sel := range sels {
+		if obj := pkg.Scope().Lookup(sel); obj == nil || !obj.Exported() {
+			return false
+		}
+	}
+	return true
+}
+
+// addImports adds import declarations of imports to blk.
+func addImports(blk *parser.LGOBlock, imports []autoImport) {
+	var stmts []ast.Stmt
+	for _, im := range imports {
+		spec := &ast.ImportSpec{
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(im.path)},
+		}
+		if im.path != im.name && !strings.HasSuffix(im.path, "/"+im.name) {
+			spec.Name = ast.NewIdent(im.name)
+		}
+		blk.Imports = append(blk.Imports, spec)
+		stmts = append(stmts, &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{spec}}})
+	}
+	blk.Stmts = append(stmts, blk.Stmts...)
+}
@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+// fakeIndex is a PackageIndex of the given paths.
+type fakeIndex map[string][]string
+
+func (idx fakeIndex) Lookup(name string) []string {
+	return idx[name]
+}
+
+// newFakePkg returns a fake package that defines funcs of names.
+func newFakePkg(path, name string, funcs ...string) *types.Package {
+	pkg := types.NewPackage(path, name)
+	for _, f := range funcs {
+		pkg.Scope().Insert(types.NewFunc(token.NoPos, pkg, f, types.NewSignature(nil, nil, nil, false)))
+	}
+	pkg.MarkComplete()
+	return pkg
+}
+
+// setFakePackages sets a fake importer and a fake index of packages and returns a func to restore them.
+func setFakePackages() func() {
+	orig := lgoImporter
+	SetLGOImporter(fakeImporter{
+		"strings":          newFakePkg("strings", "strings", "Split", "Join"),
+		"math/rand":        newFakePkg("math/rand", "rand", "Intn", "Int"),
+		"crypto/rand":      newFakePkg("crypto/rand", "rand", "Read", "Int"),
+		"gopkg.in/yaml.v2": newFakePkg("gopkg.in/yaml.v2", "yaml", "Marshal"),
+	})
+	SetPackageIndex(fakeIndex{
+		"strings": {"strings"},
+		"rand":    {"math/rand", "crypto/rand"},
+		"yaml":    {"gopkg.in/yaml.v2"},
+	})
+	return func() {
+		SetLGOImporter(orig)
+		SetPackageIndex(nil)
+	}
+}
+
+func TestFindAutoImports(t *testing.T) {
+	defer setFakePackages()()
+	tests := []struct {
+		name     string
+		src      string
+		declared string
+		want     []autoImport
+	}{
+		{"std", "s := strings.Split(x, \",\")\nstrings.Join(s, \"\")", "", []autoImport{{"strings", "strings"}}},
+		{"selectors", "rand.Intn(3)", "", []autoImport{{"rand", "math/rand"}}},
+		{"named", "func f() { yaml.Marshal(nil) }", "", []autoImport{{"yaml", "gopkg.in/yaml.v2"}}},
+		{"unexported", "strings.split", "", nil},
+		{"unknown", "foo.Bar()", "", nil},
+		{"declared", "strings.Split(x, \",\")", "strings", nil},
+		{"local", "strings := []string{}\nlen(strings)\nfunc f(strings T) { strings.Split() }", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset, blk, err := parseLesserGoString(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := findAutoImports(fset, blk, func(name string) bool { return name == tt.declared })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAutoImports_ambiguous(t *testing.T) {
+	defer setFakePackages()()
+	fset, blk, err := parseLesserGoString("x := 10\nrand.Int()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = findAutoImports(fset, blk, func(string) bool { return false })
+	if want := `2:1: rand matches multiple packages: "math/rand", "crypto/rand". Import one of them explicitly`; err == nil || err.Error() != want {
+		t.Errorf("got %v; want %v", err, want)
+	}
+}
+
+func TestMergeCells_autoImport(t *testing.T) {
+	defer setFakePackages()()
+	got, err := mergeCells([]string{
+		"s := strings.Split(\"a,b\", \",\")",
+		"strings.Join(s, \"\")\nyaml.Marshal(s)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "import (\n\t\"strings\"\n\tyaml \"gopkg.in/yaml.v2\"\n)\n\n" +
+		"s := strings.Split(\"a,b\", \",\")\n\nstrings.Join(s, \"\")\nyaml.Marshal(s)"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -97,6 +97,11 @@ func uniqueSortedNames(ids []*ast.Ident) []string {
 func parseLesserGoString(src string) (*token.FileSet, *parser.LGOBlock, error) {
 	fset := token.NewFileSet()
 	f, err := parser.ParseLesserGoFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		if tperr := typeParamsError(src); tperr != nil {
+			err = tperr
+		}
+	}
 	return fset, f, err
 }
 
@@ -459,6 +464,8 @@ type ConvertResult struct {
 	Imports []*types.PkgName
 	// A list of package paths imported in the final Src.
 	FinalDeps []string
+	// LineMap maps lines in Src to lines in the original source. LineMap is nil if lgo fails to compute it.
+	LineMap LineMap
 
 	Err error
 }
@@ -783,6 +790,18 @@ func Convert(src string, conf *Config) *ConvertResult {
 	if err != nil {
 		return &ConvertResult{Err: err}
 	}
+	declared := make(map[string]bool)
+	for _, old := range conf.Olds {
+		declared[old.Name()] = true
+	}
+	for _, im := range conf.OldImports {
+		declared[im.Name()] = true
+	}
+	autoImports, err := findAutoImports(fset, blk, func(name string) bool { return declared[name] })
+	if err != nil {
+		return &ConvertResult{Err: err}
+	}
+	addImports(blk, autoImports)
 	maybeInstallPackageArchives(blk.Imports)
 	phase1 := convertToPhase1(blk)
 
@@ -844,6 +863,7 @@ func Convert(src string, conf *Config) *ConvertResult {
 		Checker:   fcheck,
 		Imports:   imports,
 		FinalDeps: finalDeps,
+		LineMap:   newLineMap(phase1.file, fset, fsrc),
 	}
 }
 
@@ -1094,6 +1114,8 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 		}
 	})
 
+	unusedImports := rewriteStdin(file, checker, immg)
+
 	// Inject auto-exit code
 	if conf.AutoExitCode {
 		injectAutoExitToFile(file, immg)
@@ -1107,7 +1129,7 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 	}
 	// Import old imports.
 	for _, im := range oldImports {
-		if !im.Used() {
+		if !im.Used() || unusedImports[im] {
 			continue
 		}
 		newDecls = append(newDecls, &ast.GenDecl{
@@ -1142,7 +1164,7 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 			if pname == nil {
 				panic(fmt.Sprintf("*types.PkgName for %v not found", spec))
 			}
-			if !pname.Used() {
+			if !pname.Used() || unusedImports[pname] {
 				spec.Name = ast.NewIdent("_")
 			}
 			specs = append(specs, spec)
@@ -1210,8 +1232,10 @@ func capturePanicInGoRoutine(file *ast.File, immg *importManager, defs map[*ast.
 // This converts
 // go f(x, y)
 // to
+// ectx := core.InitGoroutine()
 // go func() {
-//   defer core.FinalizeGoRoutine(core.InitGoroutine())
+//   defer core.FinalizeGoroutine(ectx)
+//   ectx.Start()
 //   f(x, y)
 // }()
 type wrapGoStmtVisitor struct {
@@ -1245,6 +1269,12 @@ func (v *wrapGoStmtVisitor) Visit(node ast.Node) ast.Visitor {
 							Args: []ast.Expr{&ast.Ident{Name: ectx}},
 						},
 					},
+					&ast.ExprStmt{X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   &ast.Ident{Name: ectx},
+							Sel: &ast.Ident{Name: "Start"},
+						},
+					}},
 					&ast.ExprStmt{X: g.Call},
 				},
 			},
@@ -1040,3 +1040,50 @@ func TestConvert_installArchive(t *testing.T) {
 		t.Errorf("Got %v; want [\"github.com/yunabe/dummypkg9171\"]", r.pkgs)
 	}
 }
+
+func TestConvert_stdin(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`
+		import (
+			"bufio"
+			"os"
+		)
+		r := bufio.NewReader(os.Stdin)`, []string{"bufio.NewReader(pkg0.Stdin)", `_ "os"`}},
+		{`
+		import "fmt"
+		var n int
+		fmt.Scan(&n)`, []string{"fmt.Fscan(pkg0.Stdin, &n)"}},
+		{`
+		import "fmt"
+		func f() {
+			var s string
+			fmt.Scanln(&s)
+		}`, []string{"fmt.Fscanln(pkg0.Stdin, &s)"}},
+		{`
+		import (
+			"io"
+			"os"
+		)
+		var buf [8]byte
+		os.Stdin.Read(buf[:])
+		var r io.Reader = io.Reader(os.Stdin)`, []string{"pkg0.Stdin.Read(buf[:])", "io.Reader(pkg0.Stdin)"}},
+		{`
+		import "os"
+		os.Stdin.Stat()`, []string{"os.Stdin.Stat()"}},
+	}
+	for _, tc := range tests {
+		result := Convert(tc.src, &Config{})
+		if result.Err != nil {
+			t.Errorf("Failed to convert %q: %v", tc.src, result.Err)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(result.Src, want) {
+				t.Errorf("%q is not found in the converted code of %q:\n%s", want, tc.src, result.Src)
+			}
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/parser"
+)
+
+// exportEntryFuncName is the name of the function into which Export moves top-level statements.
+const exportEntryFuncName = "main"
+
+// builtinsNotStmt is the list of builtin functions that are not permitted in statement context.
+var builtinsNotStmt = map[string]bool{
+	"append": true, "cap": true, "complex": true, "imag": true, "len": true,
+	"make": true, "new": true, "real": true,
+}
+
+// textEdit replaces src[start:end] with text.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// applyTextEdits applies edits to src. An edit overlapping with a preceding edit (e.g. renaming an identifier
+// in a removed statement) is ignored.
+func applyTextEdits(src string, edits []textEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var buf bytes.Buffer
+	cur := 0
+	for _, e := range edits {
+		if e.start < cur {
+			continue
+		}
+		buf.WriteString(src[cur:e.start])
+		buf.WriteString(e.text)
+		cur = e.end
+	}
+	buf.WriteString(src[cur:])
+	return buf.String()
+}
+
+// exportImport is an import declared in cells.
+type exportImport struct {
+	name, path string
+}
+
+// pkgName returns the name with which the package is referred to in code.
+// The last element of the path is used as the package name if the import is not named.
+func (im exportImport) pkgName() string {
+	if im.name != "" {
+		return im.name
+	}
+	return path.Base(im.path)
+}
+
+func (im exportImport) String() string {
+	if im.name != "" {
+		return im.name + " " + strconv.Quote(im.path)
+	}
+	return strconv.Quote(im.path)
+}
+
+type exportCell struct {
+	fset *token.FileSet
+	src  string
+	blk  *parser.LGOBlock
+}
+
+func (c *exportCell) offset(pos token.Pos) int {
+	return c.fset.Position(pos).Offset
+}
+
+// mergeCells merges lgo cells executed in order into one lgo source.
+//
+// In lgo, each cell is compiled into a package and an identifier redefined in a cell shadows the old one only in
+// the following cells. mergeCells reproduces this by renaming old definitions to unique names with the cell number
+// (e.g. x in cell 3 to x3). The last definition of an identifier keeps its name.
+// Imports are moved to the top of the source. Values displayed at the end of cells and calls of functions in core or
+// _ctx.Display are removed because they do nothing outside lgo. _ctx is declared as context.Background().
+func mergeCells(cells []string) (string, error) {
+	var parsed []*exportCell
+	names := make(map[string]bool)
+	last := make(map[string]int)
+	for i, src := range cells {
+		fset, blk, err := parseLesserGoString(src)
+		if err != nil {
+			return "", fmt.Errorf("cell %d: %v", i+1, err)
+		}
+		parsed = append(parsed, &exportCell{fset: fset, src: src, blk: blk})
+		for _, stmt := range blk.Stmts {
+			ast.Inspect(stmt, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					names[id.Name] = true
+				}
+				return true
+			})
+		}
+		for name := range blk.Scope.Objects {
+			last[name] = i
+		}
+	}
+	picker := &namePicker{m: names}
+	// finalNames[i] maps identifiers defined in cells[i] to their names in the merged source.
+	finalNames := make([]map[string]string, len(parsed))
+	for i, c := range parsed {
+		finalNames[i] = make(map[string]string)
+		var defs []string
+		for name := range c.blk.Scope.Objects {
+			defs = append(defs, name)
+		}
+		sort.Strings(defs)
+		for _, name := range defs {
+			if last[name] == i && name != exportEntryFuncName {
+				finalNames[i][name] = name
+			} else {
+				finalNames[i][name] = picker.NewName(fmt.Sprintf("%s%d", name, i+1))
+			}
+		}
+	}
+
+	var imports []exportImport
+	importByName := make(map[string]exportImport)
+	importSeen := make(map[exportImport]bool)
+	// current maps identifiers to the latest definitions visible from the cell.
+	current := make(map[string]*ast.Object)
+	currentNames := make(map[string]string)
+	usesCtx := false
+	var bodies []string
+	for i, c := range parsed {
+		var edits []textEdit
+		remove := func(node ast.Node) {
+			end := c.offset(node.End())
+			if end < len(c.src) && c.src[end] == '\n' {
+				end++
+			}
+			edits = append(edits, textEdit{start: c.offset(node.Pos()), end: end})
+		}
+		unresolved := make(map[*ast.Ident]bool)
+		for _, id := range c.blk.Unresolved {
+			unresolved[id] = true
+		}
+		for _, stmt := range c.blk.Stmts {
+			decl, ok := stmt.(*ast.DeclStmt)
+			if !ok {
+				continue
+			}
+			if gen, ok := decl.Decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				for _, spec := range gen.Specs {
+					spec := spec.(*ast.ImportSpec)
+					im := exportImport{}
+					im.path, _ = strconv.Unquote(spec.Path.Value)
+					if spec.Name != nil {
+						im.name = spec.Name.Name
+					}
+					if im.name == "_" || im.name == "." {
+						if !importSeen[im] {
+							importSeen[im] = true
+							imports = append(imports, im)
+						}
+						continue
+					}
+					name := im.pkgName()
+					if old, ok := importByName[name]; ok {
+						if old.path != im.path {
+							return "", fmt.Errorf("cell %d: %s is imported as both %q and %q", i+1, name, old.path, im.path)
+						}
+						continue
+					}
+					importByName[name] = im
+					imports = append(imports, im)
+				}
+				remove(stmt)
+			}
+		}
+		// Packages imported automatically in lgo are imported explicitly.
+		autoImports, err := findAutoImports(c.fset, c.blk, func(name string) bool {
+			_, imported := importByName[name]
+			return imported || current[name] != nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("cell %d: %v", i+1, err)
+		}
+		for _, auto := range autoImports {
+			im := exportImport{path: auto.path}
+			if im.pkgName() != auto.name {
+				im.name = auto.name
+			}
+			importByName[auto.name] = im
+			imports = append(imports, im)
+		}
+		isCoreCall := func(stmt *ast.ExprStmt) bool {
+			call, ok := stmt.X.(*ast.CallExpr)
+			if !ok {
+				return false
+			}
+			// Find the root of x.y.z(...).
+			fun := call.Fun
+			var sels []string
+			for {
+				sel, ok := fun.(*ast.SelectorExpr)
+				if !ok {
+					break
+				}
+				sels = append([]string{sel.Sel.Name}, sels...)
+				fun = sel.X
+			}
+			root, ok := fun.(*ast.Ident)
+			if !ok || !unresolved[root] || len(sels) == 0 {
+				return false
+			}
+			if root.Name == runCtxName {
+				return sels[0] == "Display"
+			}
+			return importByName[root.Name].path == core.SelfPkgPath
+		}
+		isType := func(x ast.Expr) bool {
+			switch x := x.(type) {
+			case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType, *ast.ParenExpr:
+				return true
+			case *ast.Ident:
+				obj := x.Obj
+				if obj == nil && unresolved[x] {
+					obj = current[x.Name]
+				}
+				if obj != nil {
+					return obj.Kind == ast.Typ
+				}
+				return isPredeclaredType(x.Name)
+			}
+			return false
+		}
+		// Remove the value displayed at the end of the cell.
+		if n := len(c.blk.Stmts); n > 0 {
+			if es, ok := c.blk.Stmts[n-1].(*ast.ExprStmt); ok {
+				call, ok := es.X.(*ast.CallExpr)
+				if !ok || isType(call.Fun) {
+					remove(es)
+				} else if id, ok := call.Fun.(*ast.Ident); ok && unresolved[id] && current[id.Name] == nil && builtinsNotStmt[id.Name] {
+					remove(es)
+				}
+			}
+		}
+		for _, stmt := range c.blk.Stmts {
+			ast.Inspect(stmt, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.ExprStmt:
+					if isCoreCall(n) {
+						remove(n)
+						return false
+					}
+				case *ast.Ident:
+					var name string
+					if n.Obj != nil && c.blk.Scope.Objects[n.Name] == n.Obj {
+						name = finalNames[i][n.Name]
+					} else if unresolved[n] {
+						if current[n.Name] != nil {
+							name = currentNames[n.Name]
+						} else if n.Name == runCtxName {
+							usesCtx = true
+						}
+					}
+					if name != "" && name != n.Name {
+						edits = append(edits, textEdit{start: c.offset(n.Pos()), end: c.offset(n.End()), text: name})
+					}
+				}
+				return true
+			})
+		}
+		if body := strings.TrimSpace(applyTextEdits(c.src, edits)); body != "" {
+			bodies = append(bodies, body)
+		}
+		for name, obj := range c.blk.Scope.Objects {
+			current[name] = obj
+			currentNames[name] = finalNames[i][name]
+		}
+	}
+
+	var buf bytes.Buffer
+	if usesCtx {
+		ctxPkg := "context"
+		if im, ok := importByName[ctxPkg]; ok && im.path != "context" {
+			ctxPkg = picker.NewName("context")
+			imports = append(imports, exportImport{name: ctxPkg, path: "context"})
+		} else if !ok {
+			imports = append(imports, exportImport{path: "context"})
+		}
+		bodies = append([]string{fmt.Sprintf("%s := %s.Background()", runCtxName, ctxPkg)}, bodies...)
+	}
+	if len(imports) > 0 {
+		buf.WriteString("import (\n")
+		for _, im := range imports {
+			fmt.Fprintf(&buf, "\t%s\n", im)
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.WriteString(strings.Join(bodies, "\n\n"))
+	return buf.String(), nil
+}
+
+// isPredeclaredType returns true if name is a predeclared type (e.g. int).
+func isPredeclaredType(name string) bool {
+	obj := types.Universe.Lookup(name)
+	_, ok := obj.(*types.TypeName)
+	return ok
+}
+
+// Export merges lgo cells executed in order into the source of a standalone main package.
+// Top-level statements in cells are moved into main and variables defined in them are declared as package variables.
+// See mergeCells for how identifiers redefined in cells are resolved.
+// Export returns the gofmt'd source and the paths of imported packages.
+func Export(cells []string) (string, []string, error) {
+	merged, err := mergeCells(cells)
+	if err != nil {
+		return "", nil, err
+	}
+	fset, blk, err := parseLesserGoString(merged)
+	if err != nil {
+		return "", nil, err
+	}
+	phase1 := convertToPhase1(blk)
+	// Values are not displayed in a standalone program.
+	phase1.lastExpr = nil
+	phase1.file.Name = ast.NewIdent(exportEntryFuncName)
+
+	pkg := types.NewPackage(exportEntryFuncName, exportEntryFuncName)
+	var errs []error
+	chConf := &types.Config{
+		Importer: lgoImporter,
+		Error: func(err error) {
+			errs = append(errs, err)
+		},
+		IgnoreFuncBodies:  true,
+		DontIgnoreLgoInit: true,
+	}
+	info := types.Info{
+		Defs:   make(map[*ast.Ident]types.Object),
+		Uses:   make(map[*ast.Ident]types.Object),
+		Scopes: make(map[ast.Node]*types.Scope),
+		Types:  make(map[ast.Expr]types.TypeAndValue),
+	}
+	checker := types.NewChecker(chConf, fset, pkg, &info)
+	checker.Files([]*ast.File{phase1.file})
+	if len(errs) > 0 {
+		return "", nil, errs[0]
+	}
+	convertToPhase2(phase1, pkg, checker, &Config{})
+
+	file := phase1.file
+	phase1.initFunc.Name = ast.NewIdent(exportEntryFuncName)
+	hasMain := false
+	for _, decl := range file.Decls {
+		if decl == phase1.initFunc {
+			hasMain = true
+		}
+	}
+	if !hasMain {
+		// convertToPhase2 removes lgo_init if cells have no statements.
+		phase1.initFunc.Body.List = nil
+		file.Decls = append(file.Decls, phase1.initFunc)
+	}
+
+	// Type-check the final source as a normal Go package and remove imports that are not used anymore.
+	errs = nil
+	final := types.NewPackage(exportEntryFuncName, exportEntryFuncName)
+	finfo := &types.Info{
+		Defs:      make(map[*ast.Ident]types.Object),
+		Uses:      make(map[*ast.Ident]types.Object),
+		Implicits: make(map[ast.Node]types.Object),
+	}
+	types.NewChecker(&types.Config{
+		Importer: lgoImporter,
+		Error: func(err error) {
+			errs = append(errs, err)
+		},
+		DisableUnusedImportCheck: true,
+	}, fset, final, finfo).Files([]*ast.File{file})
+	if len(errs) > 0 {
+		return "", nil, errs[0]
+	}
+	used := make(map[types.Object]bool)
+	for _, obj := range finfo.Uses {
+		used[obj] = true
+	}
+	var deps []string
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		if decl == phase1.initFunc {
+			continue
+		}
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			decls = append(decls, decl)
+			continue
+		}
+		var specs []ast.Spec
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.ImportSpec)
+			var pname types.Object
+			if spec.Name != nil {
+				pname = finfo.Defs[spec.Name]
+			} else {
+				pname = finfo.Implicits[spec]
+			}
+			if pname != nil && !used[pname] {
+				continue
+			}
+			specs = append(specs, spec)
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				deps = append(deps, path)
+			}
+		}
+		if len(specs) == 0 {
+			continue
+		}
+		gen.Specs = specs
+		decls = append(decls, gen)
+	}
+	// Declare main after declarations of package variables.
+	file.Decls = append(decls, phase1.initFunc)
+	src, err := printExport(file, fset, phase1.initFunc)
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(deps)
+	return src, deps, nil
+}
+
+// printExport prints file with blank lines between declarations and formats it with gofmt.
+func printExport(file *ast.File, fset *token.FileSet, mainFunc *ast.FuncDecl) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString("package " + file.Name.Name + "\n")
+	for _, decl := range file.Decls {
+		var b bytes.Buffer
+		if err := format.Node(&b, fset, decl); err != nil {
+			return "", err
+		}
+		s := b.String()
+		if decl == mainFunc {
+			// The body of main starts at the position of the first statement in cells.
+			s = strings.Replace(s, "{\n\n", "{\n", 1)
+		}
+		buf.WriteString("\n" + s + "\n")
+	}
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
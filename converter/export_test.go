@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestMergeCells(t *testing.T) {
+	tests := []struct {
+		name  string
+		cells []string
+		want  string
+	}{
+		{
+			name: "redefine",
+			cells: []string{
+				"x := 1",
+				"y := x + 1\nfunc f() int { return x * y }",
+				"x := \"s\"\nx",
+				"z := x + \"t\"\nlen(z)",
+			},
+			want: "x1 := 1\n\ny := x1 + 1\nfunc f() int { return x1 * y }\n\nx := \"s\"\n\nz := x + \"t\"",
+		},
+		{
+			name: "types",
+			cells: []string{
+				"type T int",
+				"T(3)",
+				"type T string\nfunc (t T) M() {}\n[]byte(\"a\")",
+			},
+			want: "type T1 int\n\ntype T string\nfunc (t T) M() {}",
+		},
+		{
+			name: "imports",
+			cells: []string{
+				"import \"fmt\"\nimport \"github.com/yunabe/lgo/core\"\ncore.ShowImage(nil, nil)\n_ctx.Display.Text(\"a\", nil)\nfmt.Println(1)",
+				"import (\n\t\"fmt\"\n\t\"os\"\n)\nfunc main() {}\nfmt.Fprintln(os.Stdout, _ctx.Err())\nmain()",
+			},
+			want: "import (\n\t\"fmt\"\n\t\"github.com/yunabe/lgo/core\"\n\t\"os\"\n\t\"context\"\n)\n\n" +
+				"_ctx := context.Background()\n\nfmt.Println(1)\n\nfunc main2() {}\nfmt.Fprintln(os.Stdout, _ctx.Err())\nmain2()",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := mergeCells(tc.cells)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeCells_importConflict(t *testing.T) {
+	_, err := mergeCells([]string{`import "math/rand"`, `import "crypto/rand"`})
+	if err == nil || err.Error() != `cell 2: rand is imported as both "math/rand" and "crypto/rand"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// fakeImporter imports fake packages that define only objects used in tests.
+type fakeImporter map[string]*types.Package
+
+func (im fakeImporter) Import(path string) (*types.Package, error) {
+	return im[path], nil
+}
+
+func newFakeFmt() *types.Package {
+	pkg := types.NewPackage("fmt", "fmt")
+	args := types.NewTuple(types.NewParam(token.NoPos, pkg, "a", types.NewSlice(types.NewInterface(nil, nil).Complete())))
+	results := types.NewTuple(
+		types.NewParam(token.NoPos, pkg, "n", types.Typ[types.Int]),
+		types.NewParam(token.NoPos, pkg, "err", types.Universe.Lookup("error").Type()))
+	pkg.Scope().Insert(types.NewFunc(token.NoPos, pkg, "Println", types.NewSignature(nil, args, results, true)))
+	pkg.MarkComplete()
+	return pkg
+}
+
+func TestExport(t *testing.T) {
+	orig := lgoImporter
+	defer func() { lgoImporter = orig }()
+	lgoImporter = fakeImporter{"fmt": newFakeFmt()}
+
+	src, deps, err := Export([]string{
+		"import \"fmt\"\nx := 10\nfunc double() int {\n\treturn x * 2\n}",
+		"x := \"hello\"\ny := double()\ny",
+		"fmt.Println(x, y)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, src, "testdata/export.golden")
+	if len(deps) != 1 || deps[0] != "fmt" {
+		t.Errorf("unexpected deps: %v", deps)
+	}
+}
+
+func TestExport_unusedImports(t *testing.T) {
+	orig := lgoImporter
+	defer func() { lgoImporter = orig }()
+	corePkg := types.NewPackage("github.com/yunabe/lgo/core", "core")
+	corePkg.MarkComplete()
+	lgoImporter = fakeImporter{"fmt": newFakeFmt(), "github.com/yunabe/lgo/core": corePkg}
+
+	src, deps, err := Export([]string{"import \"fmt\"\nimport \"github.com/yunabe/lgo/core\"\ncore.ShowImage(nil, nil)\nfmt.Println(1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tfmt.Println(1)\n}\n"; src != want {
+		t.Errorf("got %q; want %q", src, want)
+	}
+	if len(deps) != 1 || deps[0] != "fmt" {
+		t.Errorf("unexpected deps: %v", deps)
+	}
+}
+
+func TestExport_noStatements(t *testing.T) {
+	src, _, err := Export([]string{"type T int"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package main\n\ntype T int\n\nfunc main() {\n}\n"; src != want {
+		t.Errorf("got %q; want %q", src, want)
+	}
+}
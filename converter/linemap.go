@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"reflect"
+
+	"github.com/golang/glog"
+)
+
+// LineMap maps lines in the converted source to lines in the original lgo source.
+// LineMap[i] is the original line of the (i+1)-th line of the converted source.
+// It is 0 if the line does not come from the original source (e.g. lines generated by the converter).
+type LineMap []int
+
+// Original returns the line in the original source that corresponds to line in the converted source.
+// Both lines are 1-based. Original returns 0 if line is not mapped to the original source.
+func (m LineMap) Original(line int) int {
+	if line <= 0 || line > len(m) {
+		return 0
+	}
+	return m[line-1]
+}
+
+// collectNodes returns nodes in file in the depth-first order excluding comments.
+func collectNodes(file *ast.File) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		switch n.(type) {
+		case *ast.CommentGroup, *ast.Comment:
+			return false
+		}
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+// newLineMap computes LineMap of converted, which is printed from file.
+// Nodes in file keep positions in the original source (fset). newLineMap parses converted again
+// and associates lines of nodes in the parsed file with positions of the corresponding nodes in file.
+// newLineMap returns nil and logs the reason if the structure of the parsed file does not match file.
+// In that case, positions in errors and stack traces are shown without lines in cells.
+func newLineMap(file *ast.File, fset *token.FileSet, converted string) LineMap {
+	cfset := token.NewFileSet()
+	cfile, err := goparser.ParseFile(cfset, "", converted, 0)
+	if err != nil {
+		glog.Warningf("Failed to parse the converted source to compute the line map: %v", err)
+		return nil
+	}
+	orig, conv := collectNodes(file), collectNodes(cfile)
+	if len(orig) != len(conv) {
+		glog.Warningf("The converted source has %d nodes but the original AST has %d nodes. Lines are not mapped.", len(conv), len(orig))
+		return nil
+	}
+	m := make(LineMap, cfset.File(cfile.Pos()).LineCount())
+	for i, n := range orig {
+		if reflect.TypeOf(n) != reflect.TypeOf(conv[i]) {
+			glog.Warningf("Node %d of the converted source is %T but the original node is %T. Lines are not mapped.", i, conv[i], n)
+			return nil
+		}
+		if !n.Pos().IsValid() || !conv[i].Pos().IsValid() {
+			continue
+		}
+		line := cfset.Position(conv[i].Pos()).Line
+		// Use the outermost node that starts in the line.
+		if m[line-1] == 0 {
+			m[line-1] = fset.Position(n.Pos()).Line
+		}
+	}
+	return m
+}
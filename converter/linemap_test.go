@@ -0,0 +1,71 @@
+package converter
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestNewLineMap(t *testing.T) {
+	src := `package p
+
+// comment
+func f() {
+	x := 10
+
+	x++
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Insert a generated statement that does not have a position, like the converter does.
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	body.List = append([]ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent("g")}}}, body.List...)
+	file.Comments = nil
+	converted := "package p\n\nfunc f() {\n\tg()\n\tx := 10\n\n\tx++\n}\n"
+
+	got := newLineMap(file, fset, converted)
+	want := LineMap{1, 0, 4, 0, 5, 0, 7, 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+	if got := want.Original(5); got != 5 {
+		t.Errorf("Original(5) = %d; want 5", got)
+	}
+	if got := want.Original(100); got != 0 {
+		t.Errorf("Original(100) = %d; want 0", got)
+	}
+}
+
+func TestNewLineMap_fallback(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\tx := 10\n\tx++\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		converted string
+	}{
+		{"parse error", "package p\n\nfunc f() {\n"},
+		{"fewer nodes", "package p\n"},
+		{"more nodes", "package p\n\nfunc f() {\n\tx := 10\n\tx--\n\tx++\n}\n"},
+		{"different node types", "package p\n\nfunc f() {\n\tx := 10\n\treturn x\n}\n"},
+	}
+	for _, tc := range tests {
+		if m := newLineMap(file, fset, tc.converted); m != nil {
+			t.Errorf("%s: Got %v; want nil", tc.name, m)
+		}
+	}
+	// A nil LineMap maps no lines, so positions are shown without lines in cells.
+	var m LineMap
+	if got := m.Original(1); got != 0 {
+		t.Errorf("Original(1) of nil LineMap = %d; want 0", got)
+	}
+}
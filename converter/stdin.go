@@ -0,0 +1,132 @@
+// This file defines rewriteStdin, which rewrites code that reads os.Stdin to read core.Stdin.
+// core.Stdin requests input to the frontend on Read because Jupyter does not send input unless kernels request it.
+// - Rewrites os.Stdin passed as an interface (e.g. bufio.NewReader(os.Stdin), io.Reader(os.Stdin)) to core.Stdin.
+// - Rewrites os.Stdin.Read to core.Stdin.Read.
+// - Rewrites fmt.Scan, fmt.Scanln and fmt.Scanf to fmt.Fscan, fmt.Fscanln and fmt.Fscanf with core.Stdin.
+// Other uses of os.Stdin (e.g. os.Stdin.Stat()) are not rewritten.
+
+package converter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// stdinScanFuncs maps functions in fmt that read os.Stdin to functions that read a reader.
+var stdinScanFuncs = map[string]string{
+	"Scan":   "Fscan",
+	"Scanln": "Fscanln",
+	"Scanf":  "Fscanf",
+}
+
+func isStdinVar(obj types.Object) bool {
+	v, ok := obj.(*types.Var)
+	return ok && v.Pkg() != nil && v.Pkg().Path() == "os" && v.Name() == "Stdin"
+}
+
+// rewriteStdin rewrites code in file that reads os.Stdin to read core.Stdin.
+// rewriteStdin returns imports that are not used anymore because all of their uses are rewritten (e.g. "os").
+func rewriteStdin(file *ast.File, checker *types.Checker, immg *importManager) (unused map[*types.PkgName]bool) {
+	uses := make(map[*types.PkgName]int)
+	for _, obj := range checker.Uses {
+		if pname, ok := obj.(*types.PkgName); ok {
+			uses[pname]++
+		}
+	}
+	unused = make(map[*types.PkgName]bool)
+	// stdin returns core.Stdin to replace expr.
+	stdin := func(expr ast.Expr) ast.Expr {
+		ast.Inspect(expr, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if pname, ok := checker.Uses[id].(*types.PkgName); ok {
+				uses[pname]--
+				if uses[pname] == 0 {
+					unused[pname] = true
+				}
+			}
+			return true
+		})
+		corePkg, err := lgoImporter.Import(core.SelfPkgPath)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to import core: %v", err))
+		}
+		return &ast.SelectorExpr{
+			X:   &ast.Ident{Name: immg.shortName(corePkg), NamePos: expr.Pos()},
+			Sel: &ast.Ident{Name: "Stdin", NamePos: expr.Pos()},
+		}
+	}
+	isStdin := func(expr ast.Expr) bool {
+		for {
+			paren, ok := expr.(*ast.ParenExpr)
+			if !ok {
+				break
+			}
+			expr = paren.X
+		}
+		switch expr := expr.(type) {
+		case *ast.SelectorExpr:
+			return isStdinVar(checker.Uses[expr.Sel])
+		case *ast.Ident:
+			return isStdinVar(checker.Uses[expr])
+		}
+		return false
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+			if fn, ok := checker.Uses[sel.Sel].(*types.Func); ok && fn.Pkg() != nil && fn.Pkg().Path() == "fmt" && stdinScanFuncs[fn.Name()] != "" {
+				sel.Sel = &ast.Ident{Name: stdinScanFuncs[fn.Name()], NamePos: sel.Sel.NamePos}
+				call.Args = append([]ast.Expr{stdin(&ast.Ident{NamePos: call.Lparen})}, call.Args...)
+				return true
+			}
+			if sel.Sel.Name == "Read" && isStdin(sel.X) {
+				sel.X = stdin(sel.X)
+				return true
+			}
+		}
+		tv := checker.Types[call.Fun]
+		if tv.Type == nil {
+			return true
+		}
+		if tv.IsType() {
+			// Conversion to an interface (e.g. io.Reader(os.Stdin)).
+			if len(call.Args) == 1 && types.IsInterface(tv.Type) && isStdin(call.Args[0]) {
+				call.Args[0] = stdin(call.Args[0])
+			}
+			return true
+		}
+		sig, ok := tv.Type.Underlying().(*types.Signature)
+		if !ok {
+			return true
+		}
+		params := sig.Params()
+		for i, arg := range call.Args {
+			if !isStdin(arg) {
+				continue
+			}
+			var param types.Type
+			if sig.Variadic() && i >= params.Len()-1 {
+				param = params.At(params.Len() - 1).Type()
+				if call.Ellipsis == 0 {
+					param = param.(*types.Slice).Elem()
+				}
+			} else if i < params.Len() {
+				param = params.At(i).Type()
+			}
+			if param != nil && types.IsInterface(param) {
+				call.Args[i] = stdin(arg)
+			}
+		}
+		return true
+	})
+	return unused
+}
@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"go/scanner"
+	"go/token"
+)
+
+// This file is a diagnostic for code with type parameters, not support of them. lgo parses and type-checks code
+// with the parser and go/types of go1.9, which do not support type parameters. If a cell fails to parse and it uses
+// type parameters, the error is replaced with errTypeParams instead of confusing syntax errors like
+// "expected '(', found '['". Code that parses successfully is not scanned.
+// TODO: Support type parameters. This requires the parser and go/types of go1.18 or later.
+const errTypeParams = "type parameters (generics) are not supported in lgo yet"
+
+type scannedToken struct {
+	pos token.Pos
+	tok token.Token
+	lit string
+}
+
+// isTilde returns true if t is "~" of type constraints. "~" is ILLEGAL in go1.9 and token.TILDE in go1.18 or later.
+func (t scannedToken) isTilde() bool {
+	return t.tok.String() == "~" || (t.tok == token.ILLEGAL && t.lit == "~")
+}
+
+func scanTokens(fset *token.FileSet, src string) []scannedToken {
+	f := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	// Ignore errors. "~" is reported as an illegal character in go1.9.
+	s.Init(f, []byte(src), func(token.Position, string) {}, 0)
+	var toks []scannedToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		toks = append(toks, scannedToken{pos, tok, lit})
+	}
+	return toks
+}
+
+// findTypeParams returns the position of the first use of type parameters in src, or token.NoPos if src does not
+// use type parameters. It detects type parameter lists of functions and types, receivers of generic types and "~"
+// in constraints. Instantiations are not detected because they are not distinguishable from index expressions.
+func findTypeParams(fset *token.FileSet, src string) token.Pos {
+	toks := scanTokens(fset, src)
+	at := func(i int) token.Token {
+		if 0 <= i && i < len(toks) {
+			return toks[i].tok
+		}
+		return token.EOF
+	}
+	// typeSpecs is the depth of parentheses of "type (...)". 0 if we are not in type (...).
+	typeSpecs := 0
+	depth := 0
+	for i, t := range toks {
+		switch t.tok {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			if depth == typeSpecs {
+				typeSpecs = 0
+			}
+			depth--
+		}
+		if t.isTilde() {
+			return t.pos
+		}
+		switch {
+		case t.tok == token.FUNC && at(i+1) == token.IDENT && at(i+2) == token.LBRACK:
+			// func Map[T any](...)
+			return toks[i+2].pos
+		case t.tok == token.FUNC && at(i+1) == token.LPAREN:
+			// func (l *List[T]) Len() int
+			for j := i + 2; j < len(toks) && toks[j].tok != token.RPAREN; j++ {
+				if toks[j].tok == token.LBRACK && at(j-1) == token.IDENT && (at(j-2) == token.IDENT || at(j-2) == token.MUL) {
+					return toks[j].pos
+				}
+			}
+		case t.tok == token.TYPE && at(i+1) == token.LPAREN:
+			typeSpecs = depth + 1
+		case t.tok == token.TYPE || (typeSpecs > 0 && depth == typeSpecs && (at(i-1) == token.LPAREN || at(i-1) == token.SEMICOLON)):
+			// type List[T any] struct{...}
+			// Do not confuse type parameters with array types like type A [N]int.
+			j := i
+			if t.tok == token.TYPE {
+				j++
+			}
+			if at(j) == token.IDENT && at(j+1) == token.LBRACK && at(j+2) == token.IDENT {
+				if next := at(j + 3); next == token.IDENT || next == token.INTERFACE || next == token.COMMA || (j+3 < len(toks) && toks[j+3].isTilde()) {
+					return toks[j+1].pos
+				}
+			}
+		}
+	}
+	return token.NoPos
+}
+
+// typeParamsError returns an error if src uses type parameters. Otherwise, it returns nil.
+func typeParamsError(src string) error {
+	fset := token.NewFileSet()
+	pos := findTypeParams(fset, src)
+	if pos == token.NoPos {
+		return nil
+	}
+	var errs scanner.ErrorList
+	errs.Add(fset.Position(pos), errTypeParams)
+	return errs
+}
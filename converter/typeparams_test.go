@@ -0,0 +1,47 @@
+package converter
+
+import (
+	"go/token"
+	"testing"
+)
+
+func TestFindTypeParams(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// want is the position of type parameters in the form of "line:column" or "" if src does not use them.
+		want string
+	}{
+		{"func", "func Map[T, U any](s []T, f func(T) U) []U { return nil }", "1:9"},
+		{"type", "type List[T any] struct {\n\tnext *List[T]\n}", "1:10"},
+		{"type with interface", "type Set[K interface{}] map[K]bool", "1:9"},
+		{"grouped types", "type (\n\tA [3]int\n\tPair[K comparable, V any] struct{}\n)", "3:6"},
+		{"method", "func (l *List[T]) Len() int { return 0 }", "1:14"},
+		{"constraint", "type Number interface {\n\t~int | ~float64\n}", "2:2"},
+		{"array type", "const N = 3\ntype A [N]int\ntype B [N * 2]int", ""},
+		{"index", "x := []int{1, 2}\ny := x[1]\nfunc (a A) f() {}", ""},
+		{"func literal", "f := func(x int) int { return x }", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			var got string
+			if pos := findTypeParams(fset, tt.src); pos.IsValid() {
+				got = fset.Position(pos).String()
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert_typeParams(t *testing.T) {
+	result := Convert("x := 10\nfunc Max[T int | float64](a, b T) T {\n\treturn a\n}", &Config{})
+	if result.Err == nil {
+		t.Fatal("no error")
+	}
+	if got, want := result.Err.Error(), "2:9: "+errTypeParams; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
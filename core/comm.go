@@ -0,0 +1,293 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// commChanSize is the buffer size of channels returned from Comm.Messages.
+const commChanSize = 1 << 10
+
+// CommSender is the interface to send comm messages to the Jupyter frontend.
+// This interface is implemented by the kernel.
+// ctx is the context of the execution that sends the message. The kernel uses it to find the request
+// (e.g. execute_request, comm_msg) the message belongs to. ctx may be already canceled.
+//
+// References:
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+type CommSender interface {
+	SendCommOpen(ctx context.Context, id, target string, data map[string]interface{}) error
+	SendCommMsg(ctx context.Context, id string, data map[string]interface{}) error
+	SendCommClose(ctx context.Context, id string, data map[string]interface{}) error
+}
+
+// CommManager manages comms between lgo and the Jupyter frontend.
+// A comm is a bidirectional channel to exchange JSON messages with frontend extensions (e.g. ipywidgets).
+// CommManager is goroutine-safe.
+type CommManager struct {
+	sender CommSender
+
+	mu      sync.Mutex
+	comms   map[string]*Comm
+	targets map[string]func(c *Comm, data map[string]interface{})
+}
+
+// NewCommManager returns a new CommManager that sends messages with sender.
+func NewCommManager(sender CommSender) *CommManager {
+	return &CommManager{
+		sender:  sender,
+		comms:   make(map[string]*Comm),
+		targets: make(map[string]func(c *Comm, data map[string]interface{})),
+	}
+}
+
+// A Comm is a comm opened by lgo or the frontend.
+type Comm struct {
+	id     string
+	target string
+	m      *CommManager
+
+	mu       sync.Mutex
+	closed   bool
+	onMsg    []func(data map[string]interface{})
+	onClose  []func(data map[string]interface{})
+	channels []chan map[string]interface{}
+}
+
+func newCommID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("rand.Read failed: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Open opens a new comm with the target in the frontend. data is sent to the frontend with comm_open.
+func (m *CommManager) Open(target string, data map[string]interface{}) (*Comm, error) {
+	c := m.newComm(newCommID(), target)
+	if err := m.sender.SendCommOpen(GetExecContext(), c.id, target, data); err != nil {
+		m.remove(c.id)
+		return nil, err
+	}
+	return c, nil
+}
+
+// RegisterTarget registers a function that is invoked when the frontend opens a comm with target.
+// data is the data sent with comm_open.
+func (m *CommManager) RegisterTarget(target string, fn func(c *Comm, data map[string]interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets[target] = fn
+}
+
+// UnregisterTarget unregisters the function registered with RegisterTarget.
+func (m *CommManager) UnregisterTarget(target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.targets, target)
+}
+
+// Comms returns a map from IDs of opened comms to their target names.
+func (m *CommManager) Comms() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make(map[string]string, len(m.comms))
+	for id, c := range m.comms {
+		r[id] = c.target
+	}
+	return r
+}
+
+func (m *CommManager) newComm(id, target string) *Comm {
+	c := &Comm{id: id, target: target, m: m}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.comms[id] = c
+	return c
+}
+
+func (m *CommManager) lookup(id string) *Comm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.comms[id]
+}
+
+func (m *CommManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.comms, id)
+}
+
+// HandleOpen handles comm_open sent from the frontend. ctx is the context of the comm_open (See callCommHandler).
+// If no function is registered for target, HandleOpen closes the comm immediately and returns an error.
+func (m *CommManager) HandleOpen(ctx LgoContext, id, target string, data map[string]interface{}) error {
+	m.mu.Lock()
+	fn := m.targets[target]
+	m.mu.Unlock()
+	if fn == nil {
+		// The kernel must reply with comm_close if the target does not exist to avoid an inconsistent state.
+		if err := m.sender.SendCommClose(ctx, id, nil); err != nil {
+			return fmt.Errorf("comm target %q is not registered and failed to close the comm: %v", target, err)
+		}
+		return fmt.Errorf("comm target %q is not registered", target)
+	}
+	c := m.newComm(id, target)
+	callCommHandler(ctx, func() { fn(c, data) })
+	return nil
+}
+
+// HandleMsg handles comm_msg sent from the frontend. ctx is the context of the comm_msg (See callCommHandler).
+func (m *CommManager) HandleMsg(ctx LgoContext, id string, data map[string]interface{}) error {
+	c := m.lookup(id)
+	if c == nil {
+		return fmt.Errorf("comm %q not found", id)
+	}
+	c.dispatch(ctx, data)
+	return nil
+}
+
+// HandleClose handles comm_close sent from the frontend. ctx is the context of the comm_close (See callCommHandler).
+func (m *CommManager) HandleClose(ctx LgoContext, id string, data map[string]interface{}) error {
+	c := m.lookup(id)
+	if c == nil {
+		return fmt.Errorf("comm %q not found", id)
+	}
+	c.markClosed(ctx, data)
+	return nil
+}
+
+// callCommHandler invokes a handler registered by lgo code.
+// If lgo code is running, the handler runs in a goroutine of the running execution so that it is canceled with the execution.
+// Otherwise, the handler runs in a new execution with ctx, whose Display shows outputs for the comm message.
+// Failures of the handler are reported to stderr not to crash the kernel.
+func callCommHandler(ctx LgoContext, fn func()) {
+	if g := InitGoroutine(); g != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer FinalizeGoroutine(g)
+			g.Start()
+			fn()
+		}()
+		<-done
+		return
+	}
+	err := ExecLgoEntryPoint(ctx, fn)
+	if err == nil {
+		return
+	}
+	if e, ok := err.(*ExecError); ok {
+		for _, p := range e.Panics {
+			fmt.Fprintf(os.Stderr, "panic in comm handler: %s\n\n%s\n", p.Value, p.Stack)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "comm handler failed: %v\n", err)
+}
+
+// ID returns the ID of the comm.
+func (c *Comm) ID() string {
+	return c.id
+}
+
+// Target returns the target name of the comm.
+func (c *Comm) Target() string {
+	return c.target
+}
+
+// Send sends data to the frontend with comm_msg.
+func (c *Comm) Send(data map[string]interface{}) error {
+	return c.send(GetExecContext(), data)
+}
+
+func (c *Comm) send(ctx context.Context, data map[string]interface{}) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return errors.New("comm is already closed")
+	}
+	return c.m.sender.SendCommMsg(ctx, c.id, data)
+}
+
+// Close closes the comm and notifies the frontend with comm_close.
+func (c *Comm) Close(data map[string]interface{}) error {
+	ctx := GetExecContext()
+	if !c.markClosed(ctx, data) {
+		return nil
+	}
+	return c.m.sender.SendCommClose(ctx, c.id, data)
+}
+
+// OnMsg registers a function that is invoked when a message is sent from the frontend.
+// If lgo code is running when the message arrives, fn runs as a goroutine of the running code and its outputs go to the running cell.
+// Otherwise, fn runs in a new execution and its outputs are shown for the message (e.g. in the output area of the widget).
+func (c *Comm) OnMsg(fn func(data map[string]interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onMsg = append(c.onMsg, fn)
+}
+
+// OnClose registers a function that is invoked when the comm is closed.
+func (c *Comm) OnClose(fn func(data map[string]interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onClose = append(c.onClose, fn)
+}
+
+// Messages returns a channel that receives messages sent from the frontend.
+// The channel is closed when the comm is closed.
+// Messages are dropped if the buffer of the channel is full.
+func (c *Comm) Messages() <-chan map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan map[string]interface{}, commChanSize)
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.channels = append(c.channels, ch)
+	return ch
+}
+
+func (c *Comm) dispatch(ctx LgoContext, data map[string]interface{}) {
+	c.mu.Lock()
+	handlers := append([]func(map[string]interface{}){}, c.onMsg...)
+	for _, ch := range c.channels {
+		select {
+		case ch <- data:
+		default:
+			fmt.Fprintf(os.Stderr, "dropped a message to comm %s because the channel is full\n", c.id)
+		}
+	}
+	c.mu.Unlock()
+	for _, fn := range handlers {
+		callCommHandler(ctx, func() { fn(data) })
+	}
+}
+
+// markClosed marks c closed and invokes OnClose handlers with ctx. It returns false if c is already closed.
+func (c *Comm) markClosed(ctx LgoContext, data map[string]interface{}) bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.closed = true
+	handlers := c.onClose
+	for _, ch := range c.channels {
+		close(ch)
+	}
+	c.channels = nil
+	c.mu.Unlock()
+
+	c.m.remove(c.id)
+	for _, fn := range handlers {
+		callCommHandler(ctx, func() { fn(data) })
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// commCtx is the context of comm messages from the frontend in tests.
+var commCtx = LgoContext{Context: context.Background()}
+
+type recordCommSender struct {
+	sent []string
+}
+
+func (s *recordCommSender) SendCommOpen(ctx context.Context, id, target string, data map[string]interface{}) error {
+	s.sent = append(s.sent, fmt.Sprintf("open:%s:%v", target, data))
+	return nil
+}
+
+func (s *recordCommSender) SendCommMsg(ctx context.Context, id string, data map[string]interface{}) error {
+	s.sent = append(s.sent, fmt.Sprintf("msg:%v", data))
+	return nil
+}
+
+func (s *recordCommSender) SendCommClose(ctx context.Context, id string, data map[string]interface{}) error {
+	s.sent = append(s.sent, fmt.Sprintf("close:%s:%v", id, data))
+	return nil
+}
+
+func TestCommOpenFromKernel(t *testing.T) {
+	sender := &recordCommSender{}
+	m := NewCommManager(sender)
+	c, err := m.Open("mytarget", map[string]interface{}{"value": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Comms(), map[string]string{c.ID(): "mytarget"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Comms() = %v; want %v", got, want)
+	}
+	var received []interface{}
+	c.OnMsg(func(data map[string]interface{}) {
+		received = append(received, data["value"])
+	})
+	ch := c.Messages()
+	if err := c.Send(map[string]interface{}{"value": 2}); err != nil {
+		t.Error(err)
+	}
+	if err := m.HandleMsg(commCtx, c.ID(), map[string]interface{}{"value": 3}); err != nil {
+		t.Error(err)
+	}
+	if want := []interface{}{3}; !reflect.DeepEqual(received, want) {
+		t.Errorf("received %v; want %v", received, want)
+	}
+	if data := <-ch; data["value"] != 3 {
+		t.Errorf("Got %v from the channel; want 3", data)
+	}
+
+	var closed bool
+	c.OnClose(func(data map[string]interface{}) { closed = true })
+	if err := c.Close(nil); err != nil {
+		t.Error(err)
+	}
+	if !closed {
+		t.Error("OnClose handler was not invoked")
+	}
+	if _, ok := <-ch; ok {
+		t.Error("The channel is not closed")
+	}
+	if err := c.Send(nil); err == nil {
+		t.Error("Send succeeded after Close")
+	}
+	if len(m.Comms()) != 0 {
+		t.Errorf("Comms() = %v; want empty", m.Comms())
+	}
+	want := []string{
+		"open:mytarget:map[value:1]",
+		"msg:map[value:2]",
+		"close:" + c.ID() + ":map[]",
+	}
+	if !reflect.DeepEqual(sender.sent, want) {
+		t.Errorf("Got %v; want %v", sender.sent, want)
+	}
+}
+
+func TestCommOpenFromFrontend(t *testing.T) {
+	sender := &recordCommSender{}
+	m := NewCommManager(sender)
+	var opened *Comm
+	m.RegisterTarget("widget", func(c *Comm, data map[string]interface{}) {
+		opened = c
+		c.OnMsg(func(data map[string]interface{}) {
+			if data["panic"] != nil {
+				panic("panic in handler")
+			}
+			c.Send(data)
+		})
+	})
+	if err := m.HandleOpen(commCtx, "id0", "widget", nil); err != nil {
+		t.Fatal(err)
+	}
+	if opened == nil || opened.ID() != "id0" || opened.Target() != "widget" {
+		t.Fatalf("Unexpected comm: %#v", opened)
+	}
+	if err := m.HandleMsg(commCtx, "id0", map[string]interface{}{"x": "y"}); err != nil {
+		t.Error(err)
+	}
+	// A panic in a handler must not crash the process.
+	if err := m.HandleMsg(commCtx, "id0", map[string]interface{}{"panic": true}); err != nil {
+		t.Error(err)
+	}
+	if err := m.HandleClose(commCtx, "id0", nil); err != nil {
+		t.Error(err)
+	}
+	if err := m.HandleMsg(commCtx, "id0", nil); err == nil {
+		t.Error("HandleMsg succeeded for a closed comm")
+	}
+	if err := m.HandleOpen(commCtx, "id1", "unknown", nil); err == nil {
+		t.Error("HandleOpen succeeded for an unknown target")
+	}
+	want := []string{
+		"msg:map[x:y]",
+		"close:id1:map[]",
+	}
+	if !reflect.DeepEqual(sender.sent, want) {
+		t.Errorf("Got %v; want %v", sender.sent, want)
+	}
+}
+
+func TestCommHandlerContext(t *testing.T) {
+	m := NewCommManager(&recordCommSender{})
+	d := &recordDisplayer{}
+	var ctxErr error
+	m.RegisterTarget("widget", func(c *Comm, data map[string]interface{}) {
+		c.OnMsg(func(data map[string]interface{}) {
+			// Handlers run in an execution even after the previous execution was canceled.
+			ExitIfCtxDone()
+			ctx := GetExecContext()
+			ctxErr = ctx.Err()
+			ctx.Display.HTML("<b>clicked</b>", nil)
+		})
+	})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ExecLgoEntryPoint(LgoContext{Context: canceled}, func() {})
+
+	ctx := LgoContext{Context: context.Background(), Display: d}
+	if err := m.HandleOpen(ctx, "id0", "widget", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HandleMsg(ctx, "id0", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ctxErr != nil {
+		t.Errorf("The context of the handler is done: %v", ctxErr)
+	}
+	if want := []string{"html:<b>clicked</b>"}; !reflect.DeepEqual(d.calls, want) {
+		t.Errorf("Got %v; want %v", d.calls, want)
+	}
+}
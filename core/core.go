@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"image"
 	"reflect"
 	"runtime"
 	"runtime/debug"
@@ -31,11 +31,18 @@ type LgoContext struct {
 	context.Context
 	// Display displays non-text content in Jupyter Notebook.
 	Display DataDisplayer
+	// Comms opens comms to communicate with Jupyter frontend extensions (e.g. interactive widgets).
+	// Comms is nil if comms are not supported (e.g. REPL).
+	Comms *CommManager
+	// Input reads input from users (e.g. input_request in Jupyter Notebook).
+	// Input is nil if the frontend reads os.Stdin directly (e.g. REPL). Use core.Input to read input in both cases.
+	Input InputReader
 }
 
 func lgoCtxWithCancel(ctx LgoContext) (LgoContext, context.CancelFunc) {
 	goctx, cancel := context.WithCancel(ctx.Context)
-	return LgoContext{goctx, ctx.Display}, cancel
+	ctx.Context = goctx
+	return ctx, cancel
 }
 
 // DataDisplayer is the interface that wraps Jupyter Notebook display_data protocol.
@@ -44,12 +51,19 @@ func lgoCtxWithCancel(ctx LgoContext) (LgoContext, context.CancelFunc) {
 // If id is not nil and it points an empty string, the method reserves a new display ID and stores it to id.
 // If id is not nil and it points a non-empty string, the method overwrites a content with the same ID in Jupyter Notebooks.
 //
+// MIME is the generic version of other methods. It displays a MIME bundle (a map from MIME types to contents) with metadata[1].
+// A content of binary MIME types (e.g. image/png, audio/wav) should be []byte. It is encoded with base64 in Jupyter messages.
+// A content of application/json and other JSON-based MIME types (e.g. application/vnd.vegalite.v4+json) should be
+// a value that can be encoded with encoding/json. metadata can be nil. Common metadata keys are documented in [4].
+// e.g. {"image/png": {"width": 100, "height": 50}}, {"text/html": {"isolated": true}}, {"application/json": {"expanded": true}}.
+//
 // Please note that JavaScript output is disabled in JupyterLab[3].
 //
 // References:
 // [1] http://jupyter-client.readthedocs.io/en/latest/messaging.html#display-data
 // [2] https://github.com/jupyter/notebook/blob/master/notebook/static/notebook/js/outputarea.js
 // [3] https://github.com/jupyterlab/jupyterlab/issues/3748
+// [4] https://nbformat.readthedocs.io/en/latest/format_description.html#display-data
 type DataDisplayer interface {
 	JavaScript(s string, id *string)
 	HTML(s string, id *string)
@@ -61,6 +75,7 @@ type DataDisplayer interface {
 	GIF(b []byte, id *string)
 	PDF(b []byte, id *string)
 	Text(s string, id *string)
+	MIME(data, metadata map[string]interface{}, id *string)
 }
 
 type resultCounter struct {
@@ -76,38 +91,85 @@ func (c *resultCounter) add() {
 	c.active++
 }
 
-// recordResult records a result of a routine based on the value of recover().
-func (c *resultCounter) recordResult(r interface{}) {
+// recordResult records a result of g based on the value of recover().
+// recordResult returns false and records nothing if g was already abandoned.
+// recordResult and abandon mark g done under c.mu so that only one of them counts g.
+func (c *resultCounter) recordResult(g *Goroutine, r interface{}) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.active--
-	if c.active < 0 {
+	if !g.finish() {
+		return false
+	}
+	if c.active == 0 {
 		panic("active is negative")
 	}
+	c.active--
 	if r == nil {
-		return
+		return true
 	}
 	if r == Bailout {
 		c.cancel += 1
-		return
+		return true
 	}
-	fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, debug.Stack())
 	c.fail += 1
+	return true
 }
 
-func (c *resultCounter) recordResultInDefer() {
-	c.recordResult(recover())
+// abandon stops counting g. abandon returns false if g already finished or it was abandoned.
+func (c *resultCounter) abandon(g *Goroutine) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == 0 || !g.finish() {
+		return false
+	}
+	c.active--
+	return true
 }
 
 type ExecutionState struct {
 	Context   LgoContext
 	cancelCtx func()
 	canceled  bool
-	cancelMu  sync.Mutex
+	// cancelReason describes the limit exceeded by the execution if the execution was canceled by limits.
+	cancelReason string
+	cancelMu     sync.Mutex
+
+	started time.Time
+	limits  Limits
+	limitMu sync.Mutex
 
 	mainCounter resultCounter
 	subCounter  resultCounter
 	routineWait sync.WaitGroup
+
+	panics  []PanicInfo
+	panicMu sync.Mutex
+}
+
+// PanicInfo represents a panic in lgo code.
+type PanicInfo struct {
+	// Value is the value passed to panic formatted with %v.
+	Value string
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack string
+}
+
+// ExecError is the error returned from ExecLgoEntryPoint when routines in an execution fail or are canceled.
+type ExecError struct {
+	// Panics are panics in the execution in the order they happened.
+	// Panics is empty if the execution was canceled without failures.
+	Panics []PanicInfo
+	// Message summarizes results of routines in the execution (e.g. "main routine failed, 1 goroutine canceled").
+	Message string
+}
+
+func (e *ExecError) Error() string {
+	return e.Message
+}
+
+// Canceled returns true if the execution was canceled without failures.
+func (e *ExecError) Canceled() bool {
+	return len(e.Panics) == 0
 }
 
 func newExecutionState(parent LgoContext) *ExecutionState {
@@ -115,6 +177,8 @@ func newExecutionState(parent LgoContext) *ExecutionState {
 	e := &ExecutionState{
 		Context:   ctx,
 		cancelCtx: cancel,
+		started:   time.Now(),
+		limits:    SessionLimits(),
 	}
 	go func() {
 		<-parent.Done()
@@ -138,6 +202,20 @@ func (e *ExecutionState) cancel() {
 	e.cancelCtx()
 }
 
+// recordPanic records a panic in a routine of e. This must be called from a deferred function to record the stack trace.
+func (e *ExecutionState) recordPanic(r interface{}) {
+	info := PanicInfo{Value: fmt.Sprint(r), Stack: rewriteTraceback(trimPanicStack(string(debug.Stack())))}
+	e.panicMu.Lock()
+	defer e.panicMu.Unlock()
+	e.panics = append(e.panics, info)
+}
+
+func (e *ExecutionState) getPanics() []PanicInfo {
+	e.panicMu.Lock()
+	defer e.panicMu.Unlock()
+	return append([]PanicInfo(nil), e.panics...)
+}
+
 func (e *ExecutionState) counterMessage() string {
 	var msgs []string
 	func() {
@@ -244,42 +322,67 @@ func startExec(parent LgoContext, main func()) *ExecutionState {
 
 	e.routineWait.Add(1)
 	e.mainCounter.add()
+	g := newGoroutine(e, true, 1)
 	go func() {
-		defer e.routineWait.Done()
-		defer e.mainCounter.recordResultInDefer()
+		defer FinalizeGoroutine(g)
+		g.Start()
 		main()
 	}()
+	go e.monitorLimits()
 	return e
 }
 
 func finalizeExec(e *ExecutionState) error {
 	e.waitRoutines()
 	resetExecState(e)
-	if msg := e.counterMessage(); msg != "" {
-		return errors.New(msg)
+	printRunningGoroutines(e)
+	msg := e.counterMessage()
+	if reason := e.getCancelReason(); reason != "" {
+		if msg == "" {
+			msg = reason
+		} else {
+			msg = reason + ": " + msg
+		}
+	}
+	if msg != "" {
+		return &ExecError{Panics: e.getPanics(), Message: msg}
 	}
 	return nil
 }
 
-func InitGoroutine() (e *ExecutionState) {
-	e = getExecState()
+// InitGoroutine registers a goroutine to the current execution. This is called before go statements in lgo code.
+// InitGoroutine returns nil if no execution is running.
+func InitGoroutine() *Goroutine {
+	e := getExecState()
 	if e == nil {
-		return
+		return nil
 	}
 	e.routineWait.Add(1)
 	e.subCounter.add()
-	return
+	g := newGoroutine(e, false, 1)
+	// Check the limit here too because goroutines may grow rapidly between checks in monitorLimits.
+	if reason := e.checkGoroutineLimit(e.getLimits()); reason != "" {
+		e.cancelWithReason(reason)
+	}
+	return g
 }
 
-func FinalizeGoroutine(e *ExecutionState) {
+// FinalizeGoroutine records the result of a goroutine registered with InitGoroutine.
+// This must be deferred at the beginning of the goroutine.
+func FinalizeGoroutine(g *Goroutine) {
 	r := recover()
-	e.subCounter.recordResult(r)
-	e.routineWait.Done()
-	if r != nil {
+	if g == nil || !g.counter.recordResult(g, r) {
+		recoverAbandoned(r)
+		return
+	}
+	if r != nil && r != Bailout {
+		g.exec.recordPanic(r)
+	}
+	g.exec.routineWait.Done()
+	if r != nil && !g.main {
 		// paniced, cancel other routines.
-		e.cancel()
+		g.exec.cancel()
 	}
-	return
 }
 
 type LgoPrinter interface {
@@ -311,9 +414,34 @@ func UnregisterLgoPrinter(p LgoPrinter) {
 	delete(lgoPrinters, p)
 }
 
+// LgoPrintln prints the values of the last expression of lgo code.
+// Values that implement Displayable or one of *Renderer interfaces are rendered
+// with the DataDisplayer of the current execution. Values of image.Image are shown as PNG images.
+// Slices of structs, maps and 2-D slices are rendered as tables. Other values are printed with LgoPrinters.
 func LgoPrintln(args ...interface{}) {
+	d := GetExecContext().Display
+	conf := GetPrettyConfig()
+	var rest []interface{}
+	for _, arg := range args {
+		if displayValue(d, arg) {
+			continue
+		}
+		if t := conf.newTable(arg); t != nil {
+			if displayValue(d, t) {
+				continue
+			}
+			// LgoPrinters print the table in text with its String method.
+			arg = t
+		} else if img, ok := arg.(image.Image); ok {
+			arg = imageSummary(img)
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		return
+	}
 	for p := range lgoPrinters {
-		p.Println(args...)
+		p.Println(rest...)
 	}
 }
 
@@ -337,4 +465,7 @@ func LgoRegisterVar(name string, p interface{}) {
 		panic("cannot register a non-pointer")
 	}
 	AllVars[name] = append(AllVars[name], p)
+	registeredVarsMu.Lock()
+	defer registeredVarsMu.Unlock()
+	registeredVars = append(registeredVars, registeredVar{name: name, ptr: p, cell: cellOfCaller()})
 }
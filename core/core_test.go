@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -151,6 +152,25 @@ func TestMainCounters(t *testing.T) {
 	}
 }
 
+func TestResultCounterAbandon(t *testing.T) {
+	var c resultCounter
+	g := &Goroutine{}
+	c.add()
+	if !c.abandon(g) {
+		t.Error("abandon returned false for an active goroutine")
+	}
+	if c.abandon(g) || c.recordResult(g, nil) {
+		t.Error("an abandoned goroutine was counted again")
+	}
+	// A goroutine that is not counted must not make active negative.
+	if c.abandon(&Goroutine{}) {
+		t.Error("abandon returned true without active goroutines")
+	}
+	if c.active != 0 || c.fail != 0 || c.cancel != 0 {
+		t.Errorf("Unexpected counter: active=%d, fail=%d, cancel=%d", c.active, c.fail, c.cancel)
+	}
+}
+
 func TestFinalizeExecTimeout(t *testing.T) {
 	execWaitDuration = 10 * time.Millisecond
 
@@ -172,3 +192,31 @@ func TestFinalizeExecTimeout(t *testing.T) {
 		t.Errorf("Unexpected err: %v", err)
 	}
 }
+
+func TestExecError(t *testing.T) {
+	atomic.StoreUint32(&isRunning, 0)
+	err := ExecLgoEntryPoint(LgoContext{Context: context.Background()}, func() {
+		panic("fail")
+	})
+	e, ok := err.(*ExecError)
+	if !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+	if e.Canceled() {
+		t.Error("Canceled() returned true for a panic")
+	}
+	if len(e.Panics) != 1 || e.Panics[0].Value != "fail" {
+		t.Fatalf("Unexpected panics: %+v", e.Panics)
+	}
+	if !strings.Contains(e.Panics[0].Stack, "TestExecError") {
+		t.Errorf("The stack does not contain the panicked function: %s", e.Panics[0].Stack)
+	}
+
+	atomic.StoreUint32(&isRunning, 0)
+	err = ExecLgoEntryPoint(LgoContext{Context: context.Background()}, func() {
+		panic(Bailout)
+	})
+	if e, ok := err.(*ExecError); !ok || !e.Canceled() {
+		t.Errorf("Got %#v; want a canceled ExecError", err)
+	}
+}
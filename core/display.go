@@ -0,0 +1,131 @@
+package core
+
+import "image"
+
+// Displayable is the interface implemented by values that render themselves in Jupyter Notebook.
+// If the last expression of lgo code implements Displayable, Display is invoked with the DataDisplayer
+// of the current execution instead of printing the value as text.
+// This is the equivalent of _repr_*_ methods in IPython.
+type Displayable interface {
+	Display(d DataDisplayer)
+}
+
+// MIMEBundler is the interface implemented by values that render themselves as a MIME bundle.
+// MIMEBundle returns a map from MIME types to contents and metadata of the bundle.
+// See DataDisplayer.MIME for the format of data and metadata. If data does not have text/plain,
+// the value printed with the pretty printer is added as text/plain.
+type MIMEBundler interface {
+	MIMEBundle() (data, metadata map[string]interface{})
+}
+
+// HTMLRenderer is the interface implemented by values that can render themselves as HTML.
+type HTMLRenderer interface {
+	RenderHTML() string
+}
+
+// MarkdownRenderer is the interface implemented by values that can render themselves as Markdown.
+type MarkdownRenderer interface {
+	RenderMarkdown() string
+}
+
+// LatexRenderer is the interface implemented by values that can render themselves as LaTeX.
+type LatexRenderer interface {
+	RenderLatex() string
+}
+
+// SVGRenderer is the interface implemented by values that can render themselves as SVG.
+type SVGRenderer interface {
+	RenderSVG() string
+}
+
+// JavaScriptRenderer is the interface implemented by values that can render themselves as JavaScript.
+type JavaScriptRenderer interface {
+	RenderJavaScript() string
+}
+
+// PNGRenderer is the interface implemented by values that can render themselves as PNG images.
+type PNGRenderer interface {
+	RenderPNG() []byte
+}
+
+// JPEGRenderer is the interface implemented by values that can render themselves as JPEG images.
+type JPEGRenderer interface {
+	RenderJPEG() []byte
+}
+
+// mimeBundle builds a MIME bundle from *Renderer interfaces implemented by v.
+// mimeBundle returns nil if v does not implement any of them. The bundle always has text/plain for frontends
+// that can not render rich outputs (e.g. the interactive shell and nbconvert --to text).
+func mimeBundle(v interface{}) map[string]interface{} {
+	data := make(map[string]interface{})
+	if r, ok := v.(HTMLRenderer); ok {
+		data["text/html"] = r.RenderHTML()
+	}
+	if r, ok := v.(MarkdownRenderer); ok {
+		data["text/markdown"] = r.RenderMarkdown()
+	}
+	if r, ok := v.(LatexRenderer); ok {
+		data["text/latex"] = r.RenderLatex()
+	}
+	if r, ok := v.(SVGRenderer); ok {
+		data["image/svg+xml"] = r.RenderSVG()
+	}
+	if r, ok := v.(JavaScriptRenderer); ok {
+		data["application/javascript"] = r.RenderJavaScript()
+	}
+	if r, ok := v.(PNGRenderer); ok {
+		data["image/png"] = r.RenderPNG()
+	}
+	if r, ok := v.(JPEGRenderer); ok {
+		data["image/jpeg"] = r.RenderJPEG()
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	data["text/plain"] = plainText(v)
+	return data
+}
+
+// plainText returns the text representation of v in MIME bundles.
+func plainText(v interface{}) string {
+	if img, ok := v.(image.Image); ok {
+		return imageSummary(img)
+	}
+	return PrettySprint(v)
+}
+
+// displayValue displays v with d if v knows how to render itself or v is an image.Image.
+// displayValue returns false if d is nil or v does not implement any of rendering interfaces.
+// If v implements multiple *Renderer interfaces, all representations are sent in a MIME bundle
+// and the frontend picks the richest one.
+func displayValue(d DataDisplayer, v interface{}) bool {
+	if d == nil || v == nil {
+		return false
+	}
+	if v, ok := v.(Displayable); ok {
+		v.Display(d)
+		return true
+	}
+	if v, ok := v.(MIMEBundler); ok {
+		bundle, metadata := v.MIMEBundle()
+		data := map[string]interface{}{"text/plain": plainText(v)}
+		for typ, content := range bundle {
+			data[typ] = content
+		}
+		d.MIME(data, metadata, nil)
+		return true
+	}
+	if data := mimeBundle(v); data != nil {
+		d.MIME(data, nil, nil)
+		return true
+	}
+	if img, ok := v.(image.Image); ok {
+		data, metadata, err := imageBundle(img, nil)
+		if err != nil {
+			return false
+		}
+		d.MIME(data, metadata, nil)
+		return true
+	}
+	return false
+}
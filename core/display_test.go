@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// recordDisplayer is a DataDisplayer that records the invocations of display methods.
+type recordDisplayer struct {
+	calls []string
+}
+
+func (d *recordDisplayer) record(typ string, v interface{}) {
+	d.calls = append(d.calls, fmt.Sprintf("%s:%v", typ, v))
+}
+
+func (d *recordDisplayer) JavaScript(s string, id *string) { d.record("javascript", s) }
+func (d *recordDisplayer) HTML(s string, id *string)       { d.record("html", s) }
+func (d *recordDisplayer) Markdown(s string, id *string)   { d.record("markdown", s) }
+func (d *recordDisplayer) Latex(s string, id *string)      { d.record("latex", s) }
+func (d *recordDisplayer) SVG(s string, id *string)        { d.record("svg", s) }
+func (d *recordDisplayer) PNG(b []byte, id *string)        { d.record("png", string(b)) }
+func (d *recordDisplayer) JPEG(b []byte, id *string)       { d.record("jpeg", string(b)) }
+func (d *recordDisplayer) GIF(b []byte, id *string)        { d.record("gif", string(b)) }
+func (d *recordDisplayer) PDF(b []byte, id *string)        { d.record("pdf", string(b)) }
+func (d *recordDisplayer) Text(s string, id *string)       { d.record("text", s) }
+func (d *recordDisplayer) MIME(data, metadata map[string]interface{}, id *string) {
+	var types []string
+	for typ := range data {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	d.record("mime", strings.Join(types, ","))
+	if metadata != nil {
+		d.record("metadata", metadata)
+	}
+}
+
+type recordPrinter struct {
+	args []interface{}
+}
+
+func (p *recordPrinter) Println(args ...interface{}) {
+	p.args = append(p.args, args...)
+}
+
+type htmlValue struct{}
+
+func (htmlValue) RenderHTML() string { return "<b>html</b>" }
+
+type richValue struct{}
+
+func (richValue) RenderMarkdown() string { return "*md*" }
+func (richValue) RenderSVG() string      { return "<svg/>" }
+
+type jsonValue struct{}
+
+func (jsonValue) MIMEBundle() (data, metadata map[string]interface{}) {
+	return map[string]interface{}{
+		"application/json": []int{1, 2},
+		"text/plain":       "[1, 2]",
+	}, map[string]interface{}{
+		"application/json": map[string]interface{}{"expanded": true},
+	}
+}
+
+type selfDisplay struct{}
+
+func (selfDisplay) Display(d DataDisplayer) {
+	d.Text("text", nil)
+	d.PNG([]byte("png"), nil)
+}
+
+func TestLgoPrintlnDisplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []interface{}
+		calls  []string
+		prints []interface{}
+	}{
+		{
+			name:   "text",
+			args:   []interface{}{10, "hello"},
+			prints: []interface{}{10, "hello"},
+		}, {
+			name:  "html",
+			args:  []interface{}{htmlValue{}},
+			calls: []string{"mime:text/html,text/plain"},
+		}, {
+			name:  "multi",
+			args:  []interface{}{richValue{}},
+			calls: []string{"mime:image/svg+xml,text/markdown,text/plain"},
+		}, {
+			name:  "bundle",
+			args:  []interface{}{jsonValue{}},
+			calls: []string{"mime:application/json,text/plain", "metadata:map[application/json:map[expanded:true]]"},
+		}, {
+			name:  "displayable",
+			args:  []interface{}{&selfDisplay{}},
+			calls: []string{"text:text", "png:png"},
+		}, {
+			name:   "mixed",
+			args:   []interface{}{htmlValue{}, 3},
+			calls:  []string{"mime:text/html,text/plain"},
+			prints: []interface{}{3},
+		}, {
+			name:   "table",
+			args:   []interface{}{map[string]int{"a": 1}, []int{1}},
+			calls:  []string{"mime:text/html,text/plain"},
+			prints: []interface{}{[]int{1}},
+		}, {
+			name:  "image",
+			args:  []interface{}{image.NewGray(image.Rect(0, 0, 1, 1))},
+			calls: []string{"mime:image/png,text/plain"},
+		}, {
+			name:   "nil",
+			args:   []interface{}{nil},
+			prints: []interface{}{nil},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &recordDisplayer{}
+			p := &recordPrinter{}
+			RegisterLgoPrinter(p)
+			defer UnregisterLgoPrinter(p)
+
+			atomic.StoreUint32(&isRunning, 0)
+			state := startExec(LgoContext{Context: context.Background(), Display: d}, func() {
+				LgoPrintln(tc.args...)
+			})
+			if err := finalizeExec(state); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.calls, tc.calls) {
+				t.Errorf("Got %v; want %v", d.calls, tc.calls)
+			}
+			if !reflect.DeepEqual(p.args, tc.prints) {
+				t.Errorf("Got %v; want %v", p.args, tc.prints)
+			}
+		})
+	}
+}
+
+func TestLgoPrintlnNoDisplay(t *testing.T) {
+	p := &recordPrinter{}
+	RegisterLgoPrinter(p)
+	defer UnregisterLgoPrinter(p)
+
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background()}, func() {
+		LgoPrintln(htmlValue{})
+	})
+	if err := finalizeExec(state); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{htmlValue{}}
+	if !reflect.DeepEqual(p.args, want) {
+		t.Errorf("Got %v; want %v", p.args, want)
+	}
+}
+
+type svgBundle struct{ name string }
+
+func (svgBundle) MIMEBundle() (data, metadata map[string]interface{}) {
+	return map[string]interface{}{"image/svg+xml": "<svg/>"}, nil
+}
+
+// plainDisplayer records text/plain of MIME bundles.
+type plainDisplayer struct {
+	recordDisplayer
+	plains []interface{}
+}
+
+func (d *plainDisplayer) MIME(data, metadata map[string]interface{}, id *string) {
+	d.plains = append(d.plains, data["text/plain"])
+}
+
+func TestDisplayValue_textPlain(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{richValue{}, "core.richValue{}"},
+		{svgBundle{"circle"}, `core.svgBundle{name: "circle"}`},
+		{jsonValue{}, "[1, 2]"},
+		{image.NewGray(image.Rect(0, 0, 3, 2)), "*image.Gray (3x2)"},
+	}
+	for _, tc := range tests {
+		d := &plainDisplayer{}
+		if !displayValue(d, tc.v) {
+			t.Errorf("displayValue(%#v) = false", tc.v)
+			continue
+		}
+		if want := []interface{}{tc.want}; !reflect.DeepEqual(d.plains, want) {
+			t.Errorf("text/plain of %#v = %q; want %q", tc.v, d.plains, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cellPkgPrefix is the prefix of package paths of lgo code.
+// This is a var to test stack filtering in this package.
+var cellPkgPrefix = "github.com/yunabe/lgo/sess"
+
+// A Goroutine represents a goroutine started by lgo code (the main routine of an execution or go statements).
+// Goroutines are tracked until they finish or they are abandoned with Abandon.
+type Goroutine struct {
+	exec    *ExecutionState
+	counter *resultCounter
+	main    bool
+	// file and line represent the position of the go statement that started this goroutine.
+	file    string
+	line    int
+	created time.Time
+
+	// id and done are protected by goroutinesMu.
+	id int64
+	// done is true if the goroutine finished or it was abandoned.
+	done bool
+}
+
+var (
+	goroutinesMu sync.Mutex
+	goroutines   = make(map[*Goroutine]bool)
+)
+
+func newGoroutine(e *ExecutionState, main bool, skip int) *Goroutine {
+	g := &Goroutine{exec: e, main: main, created: time.Now()}
+	if main {
+		g.counter = &e.mainCounter
+	} else {
+		g.counter = &e.subCounter
+	}
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		g.file, g.line = file, line
+	}
+	goroutinesMu.Lock()
+	defer goroutinesMu.Unlock()
+	goroutines[g] = true
+	return g
+}
+
+// currentGoroutineID returns the ID of the current goroutine parsed from its stack trace.
+func currentGoroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// Start records the ID of the current goroutine to g. Start must be called at the beginning of the goroutine.
+// lgo injects Start to go statements automatically.
+func (g *Goroutine) Start() {
+	if g == nil {
+		return
+	}
+	id := currentGoroutineID()
+	goroutinesMu.Lock()
+	defer goroutinesMu.Unlock()
+	g.id = id
+}
+
+// finish marks g done. finish returns false if g already finished or it was abandoned.
+// finish is called under the lock of g.counter (See resultCounter.recordResult and resultCounter.abandon).
+func (g *Goroutine) finish() bool {
+	goroutinesMu.Lock()
+	defer goroutinesMu.Unlock()
+	delete(goroutines, g)
+	if g.done {
+		return false
+	}
+	g.done = true
+	return true
+}
+
+// ID returns the ID of the goroutine assigned by Go runtime. ID returns 0 if the goroutine is not started yet.
+func (g *Goroutine) ID() int64 {
+	goroutinesMu.Lock()
+	defer goroutinesMu.Unlock()
+	return g.id
+}
+
+// SpawnSite returns the position of the go statement that started the goroutine in "execN/file:line" format.
+// The position is rewritten by the function set with SetTracebackRewriter (e.g. "cell N, line L").
+func (g *Goroutine) SpawnSite() string {
+	if g.file == "" {
+		return "unknown"
+	}
+	return rewriteTraceback(fmt.Sprintf("%s/%s:%d", path.Base(path.Dir(g.file)), path.Base(g.file), g.line))
+}
+
+// Stack returns the current stack trace of the goroutine filtered to frames of lgo code.
+// Stack returns an empty string if the goroutine is not running.
+func (g *Goroutine) Stack() string {
+	id := g.ID()
+	if id == 0 {
+		return ""
+	}
+	return rewriteTraceback(filterStack(goroutineStacks()[id]))
+}
+
+// Abandon stops tracking the goroutine. The goroutine is not stopped because Go can not kill goroutines,
+// but executions no longer wait for the goroutine and it is not reported as a hanging goroutine.
+// Abandon returns false if the goroutine already finished or it was abandoned.
+func (g *Goroutine) Abandon() bool {
+	if !g.counter.abandon(g) {
+		return false
+	}
+	g.exec.routineWait.Done()
+	return true
+}
+
+func (g *Goroutine) String() string {
+	elapsed := time.Since(g.created).Truncate(time.Millisecond)
+	if g.main {
+		return fmt.Sprintf("main routine %d (%v ago)", g.ID(), elapsed)
+	}
+	return fmt.Sprintf("goroutine %d started at %s (%v ago)", g.ID(), g.SpawnSite(), elapsed)
+}
+
+// Goroutines returns goroutines started by lgo code that are still running, including goroutines
+// started in previous executions. Goroutines are sorted by their IDs.
+func Goroutines() []*Goroutine {
+	goroutinesMu.Lock()
+	var gs []*Goroutine
+	for g := range goroutines {
+		gs = append(gs, g)
+	}
+	goroutinesMu.Unlock()
+	sort.Slice(gs, func(i, j int) bool { return gs[i].ID() < gs[j].ID() })
+	return gs
+}
+
+// AbandonGoroutines abandons goroutines with ids. If no id is specified, AbandonGoroutines abandons all goroutines
+// started in previous executions. AbandonGoroutines returns the number of abandoned goroutines.
+// See Goroutine.Abandon for details.
+func AbandonGoroutines(ids ...int64) int {
+	cur := getExecState()
+	var n int
+	for _, g := range Goroutines() {
+		if len(ids) == 0 {
+			if g.exec == cur {
+				continue
+			}
+		} else if !containsID(ids, g.ID()) {
+			continue
+		}
+		if g.Abandon() {
+			n++
+		}
+	}
+	return n
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func runningGoroutines(e *ExecutionState) []*Goroutine {
+	var gs []*Goroutine
+	for _, g := range Goroutines() {
+		if g.exec == e {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// printRunningGoroutines prints stack traces of goroutines of e that are still running to stderr.
+func printRunningGoroutines(e *ExecutionState) {
+	gs := runningGoroutines(e)
+	if len(gs) == 0 {
+		return
+	}
+	stacks := goroutineStacks()
+	var buf bytes.Buffer
+	for _, g := range gs {
+		fmt.Fprintf(&buf, "%s:\n", g)
+		if s := filterStack(stacks[g.ID()]); s != "" {
+			buf.WriteString(rewriteTraceback(s))
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("Use core.AbandonGoroutines to stop tracking these goroutines.\n")
+	os.Stderr.Write(buf.Bytes())
+}
+
+// goroutineStacks returns stack traces of all goroutines keyed by their IDs.
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[int64]string)
+	for _, s := range strings.Split(string(buf), "\n\n") {
+		if !strings.HasPrefix(s, "goroutine ") {
+			continue
+		}
+		f := strings.Fields(s)
+		if len(f) < 2 {
+			continue
+		}
+		if id, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+			stacks[id] = s
+		}
+	}
+	return stacks
+}
+
+// filterStack removes frames that are not in lgo code from a stack trace of a goroutine.
+// The first line (e.g. "goroutine 10 [chan receive]:") is kept.
+func filterStack(stack string) string {
+	if stack == "" {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	var buf bytes.Buffer
+	buf.WriteString(lines[0])
+	buf.WriteString("\n")
+	for i := 1; i+1 < len(lines); i += 2 {
+		fn := strings.TrimPrefix(lines[i], "created by ")
+		if !strings.HasPrefix(fn, cellPkgPrefix) {
+			continue
+		}
+		buf.WriteString(lines[i])
+		buf.WriteString("\n")
+		buf.WriteString(lines[i+1])
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// recoverAbandoned handles the result of an abandoned goroutine.
+// A panic in abandoned goroutines is printed to stderr not to crash the process.
+func recoverAbandoned(r interface{}) {
+	if r != nil && r != Bailout {
+		fmt.Fprintf(os.Stderr, "panic in an abandoned goroutine: %v\n\n%s", r, rewriteTraceback(trimPanicStack(string(debug.Stack()))))
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(t *testing.T, f func()) string {
+	tmp, err := ioutil.TempFile("", "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	orig := os.Stderr
+	os.Stderr = tmp
+	defer func() { os.Stderr = orig }()
+	f()
+	b, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func blockInTestGoroutine(ch <-chan struct{}) {
+	<-ch
+}
+
+func TestGoroutines(t *testing.T) {
+	cellPkgPrefix = "github.com/yunabe/lgo/core.blockIn"
+	defer func() { cellPkgPrefix = "github.com/yunabe/lgo/sess" }()
+
+	ch := make(chan struct{})
+	started := make(chan *Goroutine)
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background()}, func() {
+		g := InitGoroutine()
+		go func() {
+			defer FinalizeGoroutine(g)
+			g.Start()
+			started <- g
+			blockInTestGoroutine(ch)
+		}()
+	})
+	g := <-started
+	// Wait until the main routine finishes.
+	for len(runningGoroutines(state)) > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	gs := runningGoroutines(state)
+	if len(gs) != 1 || gs[0] != g {
+		t.Fatalf("Unexpected goroutines: %v", gs)
+	}
+	if g.ID() == 0 || g.ID() == currentGoroutineID() {
+		t.Errorf("Unexpected ID: %d", g.ID())
+	}
+	if site := g.SpawnSite(); !strings.HasPrefix(site, "core/goroutine_test.go:") {
+		t.Errorf("Unexpected spawn site: %s", site)
+	}
+	stack := g.Stack()
+	if !strings.Contains(stack, "[chan receive]") || !strings.Contains(stack, "core.blockInTestGoroutine") {
+		t.Errorf("Unexpected stack: %s", stack)
+	}
+	if strings.Contains(stack, "TestGoroutines") {
+		t.Errorf("Stack is not filtered: %s", stack)
+	}
+	close(ch)
+	if err := finalizeExec(state); err != nil {
+		t.Error(err)
+	}
+	if gs := runningGoroutines(state); len(gs) != 0 {
+		t.Errorf("Goroutines are still running: %v", gs)
+	}
+}
+
+func TestAbandonGoroutines(t *testing.T) {
+	execWaitDuration = 10 * time.Millisecond
+	cellPkgPrefix = "github.com/yunabe/lgo/core.blockIn"
+	defer func() { cellPkgPrefix = "github.com/yunabe/lgo/sess" }()
+
+	ch := make(chan struct{})
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background()}, func() {
+		g := InitGoroutine()
+		go func() {
+			defer FinalizeGoroutine(g)
+			g.Start()
+			blockInTestGoroutine(ch)
+			panic("panic after abandoned")
+		}()
+	})
+	state.cancel()
+	var err error
+	out := captureStderr(t, func() {
+		err = finalizeExec(state)
+	})
+	if want := "1 goroutine is hanging"; err == nil || err.Error() != want {
+		t.Errorf("Got %v; want %q", err, want)
+	}
+	if !strings.Contains(out, "goroutine_test.go") || !strings.Contains(out, "core.blockInTestGoroutine") {
+		t.Errorf("Unexpected output: %s", out)
+	}
+	gs := runningGoroutines(state)
+	if len(gs) != 1 {
+		t.Fatalf("Unexpected goroutines: %v", gs)
+	}
+	if n := AbandonGoroutines(gs[0].ID()); n != 1 {
+		t.Errorf("AbandonGoroutines returned %d; want 1", n)
+	}
+	if gs := runningGoroutines(state); len(gs) != 0 {
+		t.Errorf("Goroutines are still tracked: %v", gs)
+	}
+	if n := AbandonGoroutines(gs[0].ID()); n != 0 {
+		t.Errorf("AbandonGoroutines returned %d; want 0", n)
+	}
+	out = captureStderr(t, func() {
+		close(ch)
+		time.Sleep(10 * time.Millisecond)
+	})
+	if !strings.Contains(out, "panic in an abandoned goroutine: panic after abandoned") {
+		t.Errorf("Unexpected output: %s", out)
+	}
+}
+
+func TestFilterStack(t *testing.T) {
+	stack := `goroutine 7 [chan receive]:
+github.com/yunabe/lgo/sess7b/exec2.LgoExport_wait(...)
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:10
+time.Sleep(0x3b9aca00)
+	/usr/local/go/src/runtime/time.go:300 +0x10
+github.com/yunabe/lgo/sess7b/exec2.lgo_init.func1()
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:20 +0x55
+created by github.com/yunabe/lgo/sess7b/exec2.lgo_init in goroutine 6
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:18 +0x30
+`
+	want := `goroutine 7 [chan receive]:
+github.com/yunabe/lgo/sess7b/exec2.LgoExport_wait(...)
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:10
+github.com/yunabe/lgo/sess7b/exec2.lgo_init.func1()
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:20 +0x55
+created by github.com/yunabe/lgo/sess7b/exec2.lgo_init in goroutine 6
+	/gopath/src/github.com/yunabe/lgo/sess7b/exec2/src.go:18 +0x30
+`
+	if got := filterStack(stack); got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+}
+
+func TestTrimPanicStack(t *testing.T) {
+	stack := `goroutine 7 [running]:
+runtime/debug.Stack()
+	/usr/local/go/src/runtime/debug/stack.go:26 +0x5e
+github.com/yunabe/lgo/core.FinalizeGoroutine(0xc0000b4000)
+	/go/src/github.com/yunabe/lgo/core/core.go:320 +0x70
+panic({0x4b1e60, 0x4f0b20})
+	/usr/local/go/src/runtime/panic.go:770 +0x132
+github.com/yunabe/lgo/sess7b/exec3.lgo_init()
+	/go/src/github.com/yunabe/lgo/sess7b/exec3/src.go:12 +0x25
+`
+	want := `goroutine 7 [running]:
+panic({0x4b1e60, 0x4f0b20})
+	/usr/local/go/src/runtime/panic.go:770 +0x132
+github.com/yunabe/lgo/sess7b/exec3.lgo_init()
+	/go/src/github.com/yunabe/lgo/sess7b/exec3/src.go:12 +0x25
+`
+	if got := trimPanicStack(stack); got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+	if got := trimPanicStack("goroutine 1 [running]:\nmain.f()\n"); got != "goroutine 1 [running]:\nmain.f()\n" {
+		t.Errorf("Got %q; a stack without panic must not be changed", got)
+	}
+}
+
+func TestSetTracebackRewriter(t *testing.T) {
+	SetTracebackRewriter(strings.ToUpper)
+	defer SetTracebackRewriter(nil)
+	if got := rewriteTraceback("exec1/src.go:3"); got != "EXEC1/SRC.GO:3" {
+		t.Errorf("Got %q", got)
+	}
+}
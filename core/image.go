@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"reflect"
+	"time"
+)
+
+// ImageOptions configures how images are shown in Jupyter Notebook.
+type ImageOptions struct {
+	// Width and Height are the size of images in the frontend in pixels.
+	// If only one of them is set, the frontend keeps the aspect ratio of images.
+	Width, Height int
+	// Scale scales images if Width and Height are not set. For example, Scale 4 shows small images (e.g. pixel art) 4 times larger.
+	Scale float64
+}
+
+// metadata returns the metadata of a MIME bundle of an image of mimeType.
+func (o *ImageOptions) metadata(mimeType string, bounds image.Rectangle) map[string]interface{} {
+	if o == nil {
+		return nil
+	}
+	w, h := o.Width, o.Height
+	if w == 0 && h == 0 && o.Scale > 0 {
+		w = int(float64(bounds.Dx()) * o.Scale)
+		h = int(float64(bounds.Dy()) * o.Scale)
+	}
+	md := make(map[string]interface{})
+	if w > 0 {
+		md["width"] = w
+	}
+	if h > 0 {
+		md["height"] = h
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return map[string]interface{}{mimeType: md}
+}
+
+// errNoDisplay is returned when images are shown in an environment without a frontend (e.g. the interactive shell).
+var errNoDisplay = errors.New("images can not be displayed without Jupyter Notebook")
+
+// showMIME shows a MIME bundle with the DataDisplayer of the current execution.
+func showMIME(id *string, data, metadata map[string]interface{}) error {
+	d := GetExecContext().Display
+	if d == nil {
+		return errNoDisplay
+	}
+	d.MIME(data, metadata, id)
+	return nil
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageBundle returns a MIME bundle to show img as a PNG image.
+func imageBundle(img image.Image, opts *ImageOptions) (data, metadata map[string]interface{}, err error) {
+	b, err := encodePNG(img)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = map[string]interface{}{"image/png": b, "text/plain": imageSummary(img)}
+	return data, opts.metadata("image/png", img.Bounds()), nil
+}
+
+// ShowImage shows img as a PNG image in the output of the current execution. opts can be nil.
+// If id is not nil, id is used as the display id of the image like methods of DataDisplayer.
+// The last expression of lgo code is shown as an image automatically if it implements image.Image.
+func ShowImage(img image.Image, opts *ImageOptions, id *string) error {
+	data, metadata, err := imageBundle(img, opts)
+	if err != nil {
+		return err
+	}
+	return showMIME(id, data, metadata)
+}
+
+// paletted converts img to an image with the web-safe palette if img is not paletted.
+func paletted(img image.Image) *image.Paletted {
+	if p, ok := img.(*image.Paletted); ok {
+		return p
+	}
+	p := image.NewPaletted(img.Bounds(), palette.WebSafe)
+	draw.FloydSteinberg.Draw(p, img.Bounds(), img, img.Bounds().Min)
+	return p
+}
+
+// EncodeGIF encodes frames into an animated GIF that loops forever. delay is the time to show each frame.
+// Frames that are not *image.Paletted are converted with the web-safe palette.
+func EncodeGIF(frames []image.Image, delay time.Duration) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no frames")
+	}
+	anim := &gif.GIF{}
+	for _, f := range frames {
+		anim.Image = append(anim.Image, paletted(f))
+		// The unit of delays of GIF is 10ms.
+		anim.Delay = append(anim.Delay, int(delay/(10*time.Millisecond)))
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ShowAnimation shows frames as an animated GIF in the output of the current execution.
+// See ShowImage for opts and id.
+func ShowAnimation(frames []image.Image, delay time.Duration, opts *ImageOptions, id *string) error {
+	b, err := EncodeGIF(frames, delay)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"image/gif":  b,
+		"text/plain": fmt.Sprintf("%s, %d frames", imageSummary(frames[0]), len(frames)),
+	}
+	return showMIME(id, data, opts.metadata("image/gif", frames[0].Bounds()))
+}
+
+// LiveImage shows images at the same place in the output. Each Update replaces the image shown before.
+// LiveImage is useful to show the progress of simulations and animations computed on the fly.
+type LiveImage struct {
+	opts *ImageOptions
+	id   string
+}
+
+// NewLiveImage returns a new LiveImage. opts can be nil.
+func NewLiveImage(opts *ImageOptions) *LiveImage {
+	return &LiveImage{opts: opts}
+}
+
+// Update shows img in place of the image shown by the previous Update.
+func (l *LiveImage) Update(img image.Image) error {
+	return ShowImage(img, l.opts, &l.id)
+}
+
+// imageSummary describes img in text in environments where images can not be displayed.
+func imageSummary(img image.Image) string {
+	b := img.Bounds()
+	return fmt.Sprintf("%s (%dx%d)", typeName(reflect.TypeOf(img)), b.Dx(), b.Dy())
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// idDisplayer records display ids passed to MIME. Like the kernel, it assigns a new id if the id is empty.
+type idDisplayer struct {
+	recordDisplayer
+	ids []string
+}
+
+func (d *idDisplayer) MIME(data, metadata map[string]interface{}, id *string) {
+	d.recordDisplayer.MIME(data, metadata, id)
+	if id != nil {
+		if *id == "" {
+			*id = "id"
+		}
+		d.ids = append(d.ids, *id)
+	}
+}
+
+func runWithDisplay(t *testing.T, d DataDisplayer, f func()) {
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background(), Display: d}, f)
+	if err := finalizeExec(state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageOptionsMetadata(t *testing.T) {
+	bounds := image.Rect(0, 0, 10, 20)
+	tests := []struct {
+		name string
+		opts *ImageOptions
+		want map[string]interface{}
+	}{
+		{"nil", nil, nil},
+		{"empty", &ImageOptions{}, nil},
+		{"width", &ImageOptions{Width: 100}, map[string]interface{}{"image/png": map[string]interface{}{"width": 100}}},
+		{"scale", &ImageOptions{Scale: 2}, map[string]interface{}{"image/png": map[string]interface{}{"width": 20, "height": 40}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.opts.metadata("image/png", bounds); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	var frames []image.Image
+	for i := 0; i < 3; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		img.Set(i, i, color.White)
+		frames = append(frames, img)
+	}
+	b, err := EncodeGIF(frames, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := anim.Delay, []int{5, 5, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got delays %v; want %v", got, want)
+	}
+	if _, err := EncodeGIF(nil, time.Second); err == nil {
+		t.Error("EncodeGIF with no frames succeeded unexpectedly")
+	}
+}
+
+func TestLiveImage(t *testing.T) {
+	d := &idDisplayer{}
+	runWithDisplay(t, d, func() {
+		l := NewLiveImage(&ImageOptions{Width: 8})
+		img := image.NewGray(image.Rect(0, 0, 2, 2))
+		for i := 0; i < 2; i++ {
+			if err := l.Update(img); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	if want := []string{"id", "id"}; !reflect.DeepEqual(d.ids, want) {
+		t.Errorf("got ids %v; want %v", d.ids, want)
+	}
+	want := []string{
+		"mime:image/png,text/plain", "metadata:map[image/png:map[width:8]]",
+		"mime:image/png,text/plain", "metadata:map[image/png:map[width:8]]",
+	}
+	if !reflect.DeepEqual(d.calls, want) {
+		t.Errorf("got %v; want %v", d.calls, want)
+	}
+}
+
+func TestShowImageNoDisplay(t *testing.T) {
+	runWithDisplay(t, nil, func() {
+		if err := ShowImage(image.NewGray(image.Rect(0, 0, 1, 1)), nil, nil); err != errNoDisplay {
+			t.Errorf("got %v; want %v", err, errNoDisplay)
+		}
+	})
+}
+
+func TestImageSummary(t *testing.T) {
+	if got, want := imageSummary(image.NewRGBA(image.Rect(0, 0, 3, 2))), "*image.RGBA (3x2)"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
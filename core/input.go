@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// InputReader is the interface to read input from users interactively.
+// In Jupyter Notebook, ReadInput sends input_request to the frontend and blocks until users enter a value.
+// ReadInput returns ctx.Err() if ctx, the context of the current execution, is canceled while waiting for input.
+// ReadInput returns io.EOF if the frontend does not accept input (e.g. allow_stdin of execute_request is false).
+type InputReader interface {
+	// ReadInput shows prompt to users and returns the line entered by users without a newline.
+	// If password is true, the input is not echoed.
+	ReadInput(ctx context.Context, prompt string, password bool) (string, error)
+}
+
+// Input shows prompt and returns a line entered by users.
+// This is the equivalent of input() in Python.
+func Input(prompt string) (string, error) {
+	return readInput(prompt, false)
+}
+
+// Password is like Input but the frontend does not echo the input.
+// This is the equivalent of getpass.getpass() in Python.
+func Password(prompt string) (string, error) {
+	return readInput(prompt, true)
+}
+
+func readInput(prompt string, password bool) (string, error) {
+	ctx := GetExecContext()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if ctx.Input != nil {
+		return ctx.Input.ReadInput(ctx.Context, prompt, password)
+	}
+	// Input is not set in the interactive shell. Read a line from the terminal directly.
+	// Note that password is not hidden in this case and a pending read is not interrupted
+	// even if the execution is canceled because os.Stdin can not be read with a deadline.
+	fmt.Fprint(os.Stdout, prompt)
+	return readLine(os.Stdin)
+}
+
+// Stdin is the reader lgo code reads instead of os.Stdin.
+// lgo rewrites fmt.Scan, fmt.Scanln, fmt.Scanf, os.Stdin.Read and os.Stdin passed as an io.Reader (e.g. bufio.NewReader(os.Stdin))
+// in lgo code to read Stdin. In Jupyter Notebook, Stdin requests a line to users with InputReader when its buffer is empty.
+// In the interactive shell, Stdin reads os.Stdin.
+var Stdin io.Reader = &stdinReader{}
+
+type stdinReader struct {
+	mu sync.Mutex
+	// buf keeps the rest of the last line that is not read yet.
+	buf []byte
+	// state is the execution which read buf. buf is discarded when another execution reads Stdin.
+	state *ExecutionState
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+	e := getExecState()
+	ctx := canceledCtx
+	if e != nil {
+		ctx = e.Context
+	}
+	if ctx.Input == nil {
+		// In the interactive shell, read os.Stdin directly without r.mu.
+		// Like readInput, a pending read is not interrupted even if the execution is canceled.
+		return os.Stdin.Read(p)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e != r.state {
+		r.buf = nil
+		r.state = e
+	}
+	if len(r.buf) == 0 {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		line, err := ctx.Input.ReadInput(ctx.Context, "", false)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = append([]byte(line), '\n')
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// readLine reads a line from r byte by byte not to consume bytes after the line.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				return "", err
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
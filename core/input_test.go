@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type fakeInputReader struct {
+	prompts []string
+}
+
+func (r *fakeInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	if password {
+		prompt += "(password)"
+	}
+	r.prompts = append(r.prompts, prompt)
+	if len(r.prompts) > 2 {
+		return "", errors.New("no more input")
+	}
+	return "value", nil
+}
+
+func TestInput(t *testing.T) {
+	r := &fakeInputReader{}
+	var results []string
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background(), Input: r}, func() {
+		for _, f := range []func(string) (string, error){Input, Password, Input} {
+			v, err := f("name? ")
+			if err != nil {
+				results = append(results, "error:"+err.Error())
+			} else {
+				results = append(results, v)
+			}
+		}
+	})
+	if err := finalizeExec(state); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name? ", "name? (password)", "name? "}; !reflect.DeepEqual(r.prompts, want) {
+		t.Errorf("Got %q; want %q", r.prompts, want)
+	}
+	if want := []string{"value", "value", "error:no more input"}; !reflect.DeepEqual(results, want) {
+		t.Errorf("Got %q; want %q", results, want)
+	}
+}
+
+func TestInput_canceled(t *testing.T) {
+	if _, err := Input("prompt"); err != context.Canceled {
+		t.Errorf("Got %v; want %v", err, context.Canceled)
+	}
+}
+
+// blockingInputReader is an InputReader which blocks until ctx is done.
+type blockingInputReader struct {
+	started chan struct{}
+}
+
+func (r blockingInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	close(r.started)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestInput_execCanceled(t *testing.T) {
+	r := blockingInputReader{started: make(chan struct{})}
+	var inputErr error
+	atomic.StoreUint32(&isRunning, 0)
+	// The parent context is not canceled. ReadInput must be interrupted by the cancellation of the execution.
+	state := startExec(LgoContext{Context: context.Background(), Input: r}, func() {
+		_, inputErr = Input("name? ")
+	})
+	<-r.started
+	state.cancel()
+	finalizeExec(state)
+	if inputErr != context.Canceled {
+		t.Errorf("Got %v; want %v", inputErr, context.Canceled)
+	}
+}
+
+// eofInputReader is an InputReader of a frontend that does not accept input.
+type eofInputReader struct{}
+
+func (eofInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	return "", io.EOF
+}
+
+func TestStdin(t *testing.T) {
+	var x, y int
+	var line string
+	var scanErr, eofErr error
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background(), Input: &fakeInputReader{}}, func() {
+		// Reads "value\n" twice.
+		var s string
+		if _, scanErr = fmt.Fscan(Stdin, &s); scanErr != nil {
+			return
+		}
+		line, scanErr = bufio.NewReader(Stdin).ReadString('\n')
+		x, y = len(s), len(line)
+	})
+	if err := finalizeExec(state); err != nil {
+		t.Fatal(err)
+	}
+	if scanErr != nil {
+		t.Fatal(scanErr)
+	}
+	// fmt.Fscan consumes the newline after "value" because Stdin is not an io.RuneScanner.
+	if x != 5 || line != "value\n" || y != 6 {
+		t.Errorf("Got (%d, %q); want (5, %q)", x, line, "value\n")
+	}
+
+	state = startExec(LgoContext{Context: context.Background(), Input: eofInputReader{}}, func() {
+		var b [1]byte
+		_, eofErr = Stdin.Read(b[:])
+	})
+	if err := finalizeExec(state); err != nil {
+		t.Fatal(err)
+	}
+	if eofErr != io.EOF {
+		t.Errorf("Got %v; want io.EOF", eofErr)
+	}
+}
+
+func TestStdin_discardBuffer(t *testing.T) {
+	r := &fakeInputReader{}
+	var line string
+	var err error
+	atomic.StoreUint32(&isRunning, 0)
+	state := startExec(LgoContext{Context: context.Background(), Input: r}, func() {
+		var b [1]byte
+		_, err = Stdin.Read(b[:])
+	})
+	if ferr := finalizeExec(state); ferr != nil {
+		t.Fatal(ferr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rest of the line read in the previous execution is discarded.
+	state = startExec(LgoContext{Context: context.Background(), Input: r}, func() {
+		line, err = readLine(Stdin)
+	})
+	if ferr := finalizeExec(state); ferr != nil {
+		t.Fatal(ferr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "value" || len(r.prompts) != 2 {
+		t.Errorf("Got (%q, %d prompts); want (%q, 2 prompts)", line, len(r.prompts), "value")
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	r := strings.NewReader("abc\r\ndef\nghi")
+	var lines []string
+	for {
+		line, err := readLine(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if want := []string{"abc", "def", "ghi"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Got %q; want %q", lines, want)
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// How often resource usages of an execution are checked against Limits.
+// This is coarse because runtime.ReadMemStats, which is used to check MaxHeap, stops the world.
+var limitCheckInterval = 250 * time.Millisecond
+
+// Limits represents resource limits of lgo executions.
+// An execution is canceled when it exceeds one of limits. The zero value of each field means no limit.
+type Limits struct {
+	// Timeout is the maximum wall-clock time of an execution.
+	Timeout time.Duration
+	// MaxHeap is the maximum heap size in bytes. The heap usage is sampled periodically only if MaxHeap is set.
+	// Note that the heap of the whole process, including values created in previous executions, is compared with MaxHeap
+	// because Go does not provide heap statistics per goroutine.
+	MaxHeap uint64
+	// MaxGoroutines is the maximum number of goroutines started with go statements in an execution and running at the same time.
+	MaxGoroutines int
+}
+
+// tighten returns the stricter limit of l and o field by field.
+func (l Limits) tighten(o Limits) Limits {
+	if o.Timeout > 0 && (l.Timeout == 0 || o.Timeout < l.Timeout) {
+		l.Timeout = o.Timeout
+	}
+	if o.MaxHeap > 0 && (l.MaxHeap == 0 || o.MaxHeap < l.MaxHeap) {
+		l.MaxHeap = o.MaxHeap
+	}
+	if o.MaxGoroutines > 0 && (l.MaxGoroutines == 0 || o.MaxGoroutines < l.MaxGoroutines) {
+		l.MaxGoroutines = o.MaxGoroutines
+	}
+	return l
+}
+
+var (
+	sessionLimitsMu sync.Mutex
+	sessionLimits   Limits
+)
+
+// SetSessionLimits sets the limits applied to all executions in the session.
+// The limits are configured with flags of lgo command (e.g. lgo kernel --exec_timeout=10m).
+func SetSessionLimits(l Limits) {
+	sessionLimitsMu.Lock()
+	defer sessionLimitsMu.Unlock()
+	sessionLimits = l
+}
+
+// SessionLimits returns the limits set with SetSessionLimits.
+func SessionLimits() Limits {
+	sessionLimitsMu.Lock()
+	defer sessionLimitsMu.Unlock()
+	return sessionLimits
+}
+
+// SetCellLimits sets the limits of the current execution. Timeout is measured from the beginning of the execution.
+// Cell limits can not loosen the session limits. If both of them are set, the stricter one is used.
+func SetCellLimits(l Limits) {
+	e := getExecState()
+	if e == nil {
+		return
+	}
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.limits = SessionLimits().tighten(l)
+}
+
+func (e *ExecutionState) getLimits() Limits {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	return e.limits
+}
+
+// heapBytes returns the size of allocated heap objects.
+func heapBytes() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// checkLimits returns a message that describes the exceeded limit. checkLimits returns an empty string if e is within limits.
+func (e *ExecutionState) checkLimits() string {
+	l := e.getLimits()
+	if l.Timeout > 0 && time.Since(e.started) > l.Timeout {
+		return fmt.Sprintf("exceeded the time limit (%v)", l.Timeout)
+	}
+	// Do not call heapBytes without the heap limit because it stops the world.
+	if l.MaxHeap > 0 {
+		if h := heapBytes(); h > l.MaxHeap {
+			return fmt.Sprintf("exceeded the heap limit (%s > %s)", formatBytes(h), formatBytes(l.MaxHeap))
+		}
+	}
+	return e.checkGoroutineLimit(l)
+}
+
+func (e *ExecutionState) checkGoroutineLimit(l Limits) string {
+	if l.MaxGoroutines <= 0 {
+		return ""
+	}
+	e.subCounter.mu.Lock()
+	active := e.subCounter.active
+	e.subCounter.mu.Unlock()
+	if int(active) > l.MaxGoroutines {
+		return fmt.Sprintf("exceeded the goroutine limit (%d > %d)", active, l.MaxGoroutines)
+	}
+	return ""
+}
+
+// monitorLimits cancels e when e exceeds its limits.
+// Limits are checked every limitCheckInterval, or when the timeout expires if it comes earlier.
+func (e *ExecutionState) monitorLimits() {
+	for {
+		wait := limitCheckInterval
+		if l := e.getLimits(); l.Timeout > 0 {
+			if rest := l.Timeout - time.Since(e.started) + time.Millisecond; rest < wait {
+				wait = rest
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-e.Context.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if reason := e.checkLimits(); reason != "" {
+			e.cancelWithReason(reason)
+			return
+		}
+	}
+}
+
+// cancelWithReason cancels e because e exceeded a limit.
+func (e *ExecutionState) cancelWithReason(reason string) {
+	e.cancelMu.Lock()
+	if e.cancelReason == "" && !e.canceled {
+		e.cancelReason = reason
+	}
+	e.cancelMu.Unlock()
+	e.cancel()
+}
+
+func (e *ExecutionState) getCancelReason() string {
+	e.cancelMu.Lock()
+	defer e.cancelMu.Unlock()
+	return e.cancelReason
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitsTighten(t *testing.T) {
+	session := Limits{Timeout: time.Minute, MaxHeap: 1 << 30}
+	got := session.tighten(Limits{Timeout: time.Second, MaxHeap: 1 << 31, MaxGoroutines: 10})
+	want := Limits{Timeout: time.Second, MaxHeap: 1 << 30, MaxGoroutines: 10}
+	if got != want {
+		t.Errorf("Got %+v; want %+v", got, want)
+	}
+	if got := session.tighten(Limits{}); got != session {
+		t.Errorf("Got %+v; want %+v", got, session)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		b    uint64
+		want string
+	}{
+		{100, "100B"},
+		{1 << 10, "1.0KiB"},
+		{3 << 19, "1.5MiB"},
+		{2 << 30, "2.0GiB"},
+	}
+	for _, tc := range tests {
+		if got := formatBytes(tc.b); got != tc.want {
+			t.Errorf("formatBytes(%d) = %q; want %q", tc.b, got, tc.want)
+		}
+	}
+}
+
+// waitCanceled blocks until the current execution is canceled.
+func waitCanceled() {
+	<-GetExecContext().Done()
+	panic(Bailout)
+}
+
+func TestLimits(t *testing.T) {
+	execWaitDuration = 10 * time.Millisecond
+	tests := []struct {
+		name    string
+		session Limits
+		cell    *Limits
+		body    func()
+		message string
+	}{
+		{
+			name:    "timeout",
+			session: Limits{Timeout: 20 * time.Millisecond},
+			body: func() {
+				for {
+					ExitIfCtxDone()
+					time.Sleep(time.Millisecond)
+				}
+			},
+			message: "exceeded the time limit (20ms): main routine canceled",
+		}, {
+			name:    "celltimeout",
+			session: Limits{Timeout: time.Hour},
+			cell:    &Limits{Timeout: 20 * time.Millisecond},
+			body:    waitCanceled,
+			message: "exceeded the time limit (20ms): main routine canceled",
+		}, {
+			name:    "heap",
+			session: Limits{MaxHeap: 1},
+			body:    waitCanceled,
+			message: "exceeded the heap limit",
+		}, {
+			name:    "goroutines",
+			session: Limits{MaxGoroutines: 2},
+			body: func() {
+				for i := 0; i < 3; i++ {
+					g := InitGoroutine()
+					go func() {
+						defer FinalizeGoroutine(g)
+						g.Start()
+						waitCanceled()
+					}()
+				}
+				waitCanceled()
+			},
+			message: "exceeded the goroutine limit (3 > 2): main routine canceled, 3 goroutines canceled",
+		}, {
+			name:    "ok",
+			session: Limits{Timeout: time.Hour, MaxHeap: 1 << 40, MaxGoroutines: 10},
+			body:    func() { time.Sleep(2 * limitCheckInterval) },
+		},
+	}
+	defer SetSessionLimits(Limits{})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			SetSessionLimits(tc.session)
+			atomic.StoreUint32(&isRunning, 0)
+			state := startExec(LgoContext{Context: context.Background()}, func() {
+				if tc.cell != nil {
+					SetCellLimits(*tc.cell)
+				}
+				tc.body()
+			})
+			var msg string
+			if err := finalizeExec(state); err != nil {
+				msg = err.Error()
+			}
+			if !strings.HasPrefix(msg, tc.message) || (tc.message == "" && msg != "") {
+				t.Errorf("Got %q; want %q", msg, tc.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// VarPointer returns the pointer to the latest variable of name defined in lgo code or nil if it's not defined.
+func VarPointer(name string) interface{} {
+	v, ok := latestVars()[name]
+	if !ok {
+		return nil
+	}
+	return v.ptr
+}
+
+// MigrateValues copies values that srcs point to into variables that dsts point to.
+// Types of dsts can be different from types of srcs if their shapes are compatible (e.g. a struct type redefined
+// in a later cell). Fields of structs are copied by names. Fields that do not exist in srcs are left zero and fields
+// that do not exist in dsts are dropped. Pointers shared in srcs are shared in dsts too.
+func MigrateValues(dsts, srcs []interface{}) error {
+	if len(dsts) != len(srcs) {
+		return fmt.Errorf("the number of destinations (%d) does not match the number of sources (%d)", len(dsts), len(srcs))
+	}
+	m := &migrator{ptrs: make(map[migratedPtr]reflect.Value)}
+	for i := range dsts {
+		dst, src := reflect.ValueOf(dsts[i]), reflect.ValueOf(srcs[i])
+		if dst.Kind() != reflect.Ptr || src.Kind() != reflect.Ptr {
+			return fmt.Errorf("MigrateValues takes pointers but got %v and %v", dst.Type(), src.Type())
+		}
+		if err := m.migrate(dst.Elem(), src.Elem()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratedPtr identifies a pointer migrated to a type.
+type migratedPtr struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type migrator struct {
+	// ptrs maps pointers in sources to pointers created in destinations.
+	ptrs map[migratedPtr]reflect.Value
+}
+
+func (m *migrator) migrate(dst, src reflect.Value) error {
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if !dst.CanSet() {
+		return fmt.Errorf("can not set %v", dst.Type())
+	}
+	switch dst.Kind() {
+	case reflect.Struct:
+		if src.Kind() != reflect.Struct {
+			break
+		}
+		for i := 0; i < dst.NumField(); i++ {
+			name := dst.Type().Field(i).Name
+			sf, ok := src.Type().FieldByName(name)
+			if !ok || len(sf.Index) != 1 {
+				continue
+			}
+			if err := m.migrate(dst.Field(i), src.Field(sf.Index[0])); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return nil
+	case reflect.Ptr:
+		if src.Kind() != reflect.Ptr {
+			break
+		}
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		key := migratedPtr{src.Pointer(), dst.Type()}
+		if p, ok := m.ptrs[key]; ok {
+			dst.Set(p)
+			return nil
+		}
+		p := reflect.New(dst.Type().Elem())
+		m.ptrs[key] = p
+		dst.Set(p)
+		return m.migrate(p.Elem(), src.Elem())
+	case reflect.Slice:
+		if src.Kind() != reflect.Slice {
+			break
+		}
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		s := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := m.migrate(s.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Array:
+		if src.Kind() != reflect.Array || src.Len() != dst.Len() {
+			break
+		}
+		for i := 0; i < src.Len(); i++ {
+			if err := m.migrate(dst.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if src.Kind() != reflect.Map {
+			break
+		}
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		mp := reflect.MakeMap(dst.Type())
+		for _, k := range src.MapKeys() {
+			nk := reflect.New(dst.Type().Key()).Elem()
+			if err := m.migrate(nk, k); err != nil {
+				return err
+			}
+			nv := reflect.New(dst.Type().Elem()).Elem()
+			if err := m.migrate(nv, src.MapIndex(k)); err != nil {
+				return err
+			}
+			mp.SetMapIndex(nk, nv)
+		}
+		dst.Set(mp)
+		return nil
+	default:
+		if src.Type().ConvertibleTo(dst.Type()) && src.Kind() != reflect.Interface && dst.Kind() != reflect.Interface {
+			dst.Set(src.Convert(dst.Type()))
+			return nil
+		}
+	}
+	return fmt.Errorf("%v can not be migrated to %v", src.Type(), dst.Type())
+}
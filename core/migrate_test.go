@@ -0,0 +1,58 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateValues(t *testing.T) {
+	type oldPoint struct {
+		X, Y    int
+		Removed string
+	}
+	type newPoint struct {
+		X     int64
+		Y     int
+		Label string
+	}
+	type oldLine struct {
+		A, B *oldPoint
+		Tags map[string]oldPoint
+		Path []oldPoint
+	}
+	type newLine struct {
+		A, B *newPoint
+		Tags map[string]newPoint
+		Path []newPoint
+	}
+	p := &oldPoint{1, 2, "x"}
+	src := oldLine{A: p, B: p, Tags: map[string]oldPoint{"o": {3, 4, ""}}, Path: []oldPoint{{5, 6, ""}}}
+	var dst newLine
+	var dstP *newPoint
+	if err := MigrateValues([]interface{}{&dst, &dstP}, []interface{}{&src, &p}); err != nil {
+		t.Fatal(err)
+	}
+	want := newLine{
+		A:    &newPoint{X: 1, Y: 2},
+		B:    &newPoint{X: 1, Y: 2},
+		Tags: map[string]newPoint{"o": {X: 3, Y: 4}},
+		Path: []newPoint{{X: 5, Y: 6}},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v; want %+v", dst, want)
+	}
+	if dst.A != dst.B || dst.A != dstP {
+		t.Error("shared pointers are not shared after the migration")
+	}
+}
+
+func TestMigrateValues_incompatible(t *testing.T) {
+	type oldT struct{ X int }
+	type newT struct{ X []int }
+	var dst newT
+	err := MigrateValues([]interface{}{&dst}, []interface{}{&oldT{1}})
+	if err == nil || !strings.Contains(err.Error(), "X: int can not be migrated to []int") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
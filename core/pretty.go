@@ -0,0 +1,347 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// lgoPackageName is the package name of converted lgo code.
+const lgoPackageName = "lgo_exec"
+
+// PrettyConfig configures the pretty printer used to print values of the last expressions in lgo code.
+// A limit is disabled if it is zero or negative.
+type PrettyConfig struct {
+	// Indent is the string used to indent nested values.
+	Indent string
+	// Width is the maximum width of composite values printed in one line.
+	// Values that do not fit in Width are printed in multiple lines.
+	Width int
+	// MaxDepth is the maximum depth of nested values. Deeper values are printed as "...".
+	MaxDepth int
+	// MaxElements is the maximum number of elements of slices, arrays and maps to print.
+	// The rest is omitted as "... N more".
+	MaxElements int
+	// MaxStringLen is the maximum length of strings to print in bytes.
+	MaxStringLen int
+	// MaxBytes is the maximum number of bytes of []byte printed in a hexdump.
+	MaxBytes int
+
+	// Slices and arrays of structs, maps and 2-D slices are rendered as tables.
+	// MaxTableRows and MaxTableColumns are the maximum numbers of rows and columns of tables.
+	MaxTableRows    int
+	MaxTableColumns int
+	// TablePageSize is the number of rows in a page of HTML tables.
+	TablePageSize int
+}
+
+// DefaultPrettyConfig returns the default configuration of the pretty printer.
+func DefaultPrettyConfig() PrettyConfig {
+	return PrettyConfig{
+		Indent:       "  ",
+		Width:        80,
+		MaxDepth:     8,
+		MaxElements:  100,
+		MaxStringLen: 1000,
+		MaxBytes:     256,
+
+		MaxTableRows:    200,
+		MaxTableColumns: 30,
+		TablePageSize:   20,
+	}
+}
+
+var (
+	prettyConfigMu sync.Mutex
+	prettyConfig   = DefaultPrettyConfig()
+)
+
+// SetPrettyConfig sets the configuration of the pretty printer.
+// Call SetPrettyConfig in a cell to change how the values of the last expressions are printed in later executions.
+func SetPrettyConfig(c PrettyConfig) {
+	prettyConfigMu.Lock()
+	defer prettyConfigMu.Unlock()
+	prettyConfig = c
+}
+
+// GetPrettyConfig returns the current configuration of the pretty printer.
+func GetPrettyConfig() PrettyConfig {
+	prettyConfigMu.Lock()
+	defer prettyConfigMu.Unlock()
+	return prettyConfig
+}
+
+// PrettySprint formats v with the pretty printer configured with SetPrettyConfig.
+func PrettySprint(v interface{}) string {
+	return GetPrettyConfig().Sprint(v)
+}
+
+// Sprint formats v in a Go-like syntax. Struct fields are indented, pointers are followed,
+// map keys are sorted and large values are truncated. Values that implement error or fmt.Stringer
+// are printed with their methods. Strings are printed without quotes if v itself is a string.
+func (c PrettyConfig) Sprint(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return c.truncateString(s)
+	}
+	p := &prettyPrinter{conf: c, visiting: make(map[uintptr]bool)}
+	return p.format(reflect.ValueOf(v), 0)
+}
+
+func (c PrettyConfig) truncateString(s string) string {
+	if c.MaxStringLen <= 0 || len(s) <= c.MaxStringLen {
+		return s
+	}
+	n := c.MaxStringLen
+	// Do not split a multi-byte character.
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + fmt.Sprintf("... %d more bytes", len(s)-n)
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xc0 != 0x80
+}
+
+type prettyPrinter struct {
+	conf PrettyConfig
+	// visiting keeps pointers being printed to detect cycles.
+	visiting map[uintptr]bool
+}
+
+// typeName returns the name of t without prefixes added to lgo code.
+func typeName(t reflect.Type) string {
+	s := strings.Replace(t.String(), lgoExportPrefix, "", -1)
+	return strings.Replace(s, lgoPackageName+".", "", -1)
+}
+
+func (p *prettyPrinter) format(v reflect.Value, depth int) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	if s, ok := p.formatWithMethod(v); ok {
+		return s
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Complex64, reflect.Complex128:
+		return fmt.Sprint(v.Complex())
+	case reflect.String:
+		return strconv.Quote(p.conf.truncateString(v.String()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+		return p.format(v.Elem(), depth)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "nil"
+		}
+		switch v.Elem().Kind() {
+		case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+		default:
+			// Pointers to other values are printed with their addresses and values.
+			return fmt.Sprintf("(%s)(%#x)", typeName(v.Type()), v.Pointer())
+		}
+		ptr := v.Pointer()
+		if p.visiting[ptr] {
+			return "<cycle>"
+		}
+		p.visiting[ptr] = true
+		defer delete(p.visiting, ptr)
+		return "&" + p.format(v.Elem(), depth)
+	case reflect.Slice:
+		if v.IsNil() {
+			return "nil"
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return p.formatBytes(v)
+		}
+		return p.formatList(v, depth)
+	case reflect.Array:
+		return p.formatList(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return "nil"
+		}
+		ptr := v.Pointer()
+		if p.visiting[ptr] {
+			return "<cycle>"
+		}
+		p.visiting[ptr] = true
+		defer delete(p.visiting, ptr)
+		return p.formatMap(v, depth)
+	case reflect.Struct:
+		return p.formatStruct(v, depth)
+	}
+	// Funcs, channels and unsafe pointers.
+	if v.CanInterface() {
+		return fmt.Sprintf("(%s)(%v)", typeName(v.Type()), v.Interface())
+	}
+	return typeName(v.Type())
+}
+
+// formatWithMethod formats v with Error or String method if v implements error or fmt.Stringer.
+func (p *prettyPrinter) formatWithMethod(v reflect.Value) (s string, ok bool) {
+	if !v.CanInterface() {
+		return "", false
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", false
+	}
+	defer func() {
+		// Fall back to the default format if the method panics.
+		if r := recover(); r != nil {
+			s, ok = "", false
+		}
+	}()
+	switch x := v.Interface().(type) {
+	case error:
+		return x.Error(), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	return "", false
+}
+
+func (p *prettyPrinter) formatBytes(v reflect.Value) string {
+	b := v.Bytes()
+	if len(b) == 0 {
+		return typeName(v.Type()) + "{}"
+	}
+	shown := b
+	if p.conf.MaxBytes > 0 && len(b) > p.conf.MaxBytes {
+		shown = b[:p.conf.MaxBytes]
+	}
+	s := fmt.Sprintf("%s (%d bytes)\n%s", typeName(v.Type()), len(b), strings.TrimRight(hex.Dump(shown), "\n"))
+	if len(shown) < len(b) {
+		s += fmt.Sprintf("\n... %d more bytes", len(b)-len(shown))
+	}
+	return s
+}
+
+// composite formats a composite value with its type name and elements.
+// Elements are printed in one line if they fit in the width. Otherwise, one element is printed in each line.
+func (p *prettyPrinter) composite(typ string, elems []string) string {
+	if len(elems) == 0 {
+		return typ + "{}"
+	}
+	oneLine := typ + "{" + strings.Join(elems, ", ") + "}"
+	if !strings.Contains(oneLine, "\n") && (p.conf.Width <= 0 || len(oneLine) <= p.conf.Width) {
+		return oneLine
+	}
+	var b bytes.Buffer
+	b.WriteString(typ + "{\n")
+	for _, e := range elems {
+		b.WriteString(p.conf.Indent)
+		b.WriteString(strings.Replace(e, "\n", "\n"+p.conf.Indent, -1))
+		b.WriteString(",\n")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// numShown returns the number of elements to print out of n elements.
+func (p *prettyPrinter) numShown(n int) int {
+	if p.conf.MaxElements > 0 && n > p.conf.MaxElements {
+		return p.conf.MaxElements
+	}
+	return n
+}
+
+func moreElements(n int) string {
+	return fmt.Sprintf("... %d more", n)
+}
+
+func (p *prettyPrinter) formatList(v reflect.Value, depth int) string {
+	typ := typeName(v.Type())
+	if p.conf.MaxDepth > 0 && depth >= p.conf.MaxDepth {
+		return typ + "{...}"
+	}
+	n := p.numShown(v.Len())
+	elems := make([]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		elems = append(elems, p.format(v.Index(i), depth+1))
+	}
+	if n < v.Len() {
+		elems = append(elems, moreElements(v.Len()-n))
+	}
+	return p.composite(typ, elems)
+}
+
+func (p *prettyPrinter) formatMap(v reflect.Value, depth int) string {
+	typ := typeName(v.Type())
+	if p.conf.MaxDepth > 0 && depth >= p.conf.MaxDepth {
+		return typ + "{...}"
+	}
+	keys := sortedKeys(p, v)
+	n := p.numShown(len(keys))
+	elems := make([]string, 0, n+1)
+	for _, k := range keys[:n] {
+		elems = append(elems, p.format(k, depth+1)+": "+p.format(v.MapIndex(k), depth+1))
+	}
+	if n < len(keys) {
+		elems = append(elems, moreElements(len(keys)-n))
+	}
+	return p.composite(typ, elems)
+}
+
+// sortedKeys returns the keys of map v in order.
+func sortedKeys(p *prettyPrinter, v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return p.lessKey(keys[i], keys[j]) })
+	return keys
+}
+
+// lessKey compares map keys. Numbers and strings are compared by their values and other keys are compared by their formats.
+func (p *prettyPrinter) lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if a.IsValid() && b.IsValid() && a.Kind() == b.Kind() {
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		}
+	}
+	return p.format(a, 0) < p.format(b, 0)
+}
+
+func (p *prettyPrinter) formatStruct(v reflect.Value, depth int) string {
+	typ := typeName(v.Type())
+	if p.conf.MaxDepth > 0 && depth >= p.conf.MaxDepth {
+		return typ + "{...}"
+	}
+	t := v.Type()
+	elems := make([]string, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.TrimPrefix(t.Field(i).Name, lgoExportPrefix)
+		elems = append(elems, name+": "+p.format(v.Field(i), depth+1))
+	}
+	return p.composite(typ, elems)
+}
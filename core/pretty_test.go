@@ -0,0 +1,116 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type prettyPoint struct {
+	X, Y int
+}
+
+type prettyNode struct {
+	Name string
+	Next *prettyNode
+}
+
+type prettyStringer struct{ v int }
+
+func (s prettyStringer) String() string { return "stringer" }
+
+type panicStringer struct{ v int }
+
+func (s *panicStringer) String() string { panic("boom") }
+
+func TestPrettySprint(t *testing.T) {
+	loop := &prettyNode{Name: "a"}
+	loop.Next = &prettyNode{Name: "b", Next: loop}
+	var nilPoint *prettyPoint
+	conf := DefaultPrettyConfig()
+	conf.MaxElements = 3
+	conf.MaxStringLen = 5
+	conf.Width = 50
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"nil", nil, "nil"},
+		{"int", 10, "10"},
+		{"float", 1.5, "1.5"},
+		{"string", "hello", "hello"},
+		{"longString", "hello world", "hello... 6 more bytes"},
+		{"quoted", []string{"a\n"}, `[]string{"a\n"}`},
+		{"struct", prettyPoint{1, 2}, "core.prettyPoint{X: 1, Y: 2}"},
+		{"pointer", &prettyPoint{1, 2}, "&core.prettyPoint{X: 1, Y: 2}"},
+		{"nilPointer", nilPoint, "nil"},
+		{"truncate", []int{1, 2, 3, 4, 5}, "[]int{1, 2, 3, ... 2 more}"},
+		{"array", [2]bool{true, false}, "[2]bool{true, false}"},
+		{"map", map[int]string{10: "a", 2: "b", -1: "c"}, `map[int]string{-1: "c", 2: "b", 10: "a"}`},
+		{"stringer", prettyStringer{}, "stringer"},
+		{"error", errors.New("failed"), "failed"},
+		{"panicStringer", &panicStringer{3}, "&core.panicStringer{v: 3}"},
+		{"multiline", []prettyPoint{{1, 2}, {3, 4}}, strings.Join([]string{
+			"[]core.prettyPoint{",
+			"  core.prettyPoint{X: 1, Y: 2},",
+			"  core.prettyPoint{X: 3, Y: 4},",
+			"}",
+		}, "\n")},
+		{"cycle", loop, strings.Join([]string{
+			"&core.prettyNode{",
+			`  Name: "a",`,
+			`  Next: &core.prettyNode{Name: "b", Next: <cycle>},`,
+			"}",
+		}, "\n")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := conf.Sprint(tc.v); got != tc.want {
+				t.Errorf("Sprint(%#v) =\n%s\nwant\n%s", tc.v, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrettySprintBytes(t *testing.T) {
+	conf := DefaultPrettyConfig()
+	conf.MaxBytes = 4
+	got := conf.Sprint([]byte("abcdef"))
+	want := strings.Join([]string{
+		"[]uint8 (6 bytes)",
+		"00000000  61 62 63 64                                       |abcd|",
+		"... 2 more bytes",
+	}, "\n")
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrettySprintDepth(t *testing.T) {
+	conf := DefaultPrettyConfig()
+	conf.MaxDepth = 2
+	got := conf.Sprint([][][]int{{{1}}})
+	if want := "[][][]int{[][]int{[]int{...}}}"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestSetPrettyConfig(t *testing.T) {
+	orig := GetPrettyConfig()
+	defer SetPrettyConfig(orig)
+	conf := DefaultPrettyConfig()
+	conf.MaxElements = 1
+	SetPrettyConfig(conf)
+	if got, want := PrettySprint([]int{1, 2}), "[]int{1, ... 1 more}"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	type LgoExport_T struct{}
+	if got, want := typeName(reflect.TypeOf(map[string]LgoExport_T{})), "map[string]core.T"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
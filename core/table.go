@@ -0,0 +1,311 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// maxCellLen is the maximum length of texts in table cells in runes.
+const maxCellLen = 60
+
+var (
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// table is a tabular value of the last expression of lgo code.
+// table is rendered as an HTML table in Jupyter Notebook and as a text table in the REPL.
+type table struct {
+	// columns are the headers of columns. The first column is the index (or the key) of rows.
+	columns []string
+	rows    [][]string
+	// total is the number of rows including rows omitted from rows.
+	total int
+	// pageSize is the number of rows in a page of the HTML table.
+	pageSize int
+}
+
+// structType returns the struct type of elements of tables if t is a struct or a pointer to a struct.
+// structType returns nil if values of t print themselves with String or Error method.
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, typ := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if typ.Implements(stringerType) || typ.Implements(errorType) {
+			return nil
+		}
+	}
+	return t
+}
+
+// exportedFields returns indices of exported fields of struct type t.
+func exportedFields(t reflect.Type) []int {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+// isTable reports whether values of t are rendered as tables.
+// Slices and arrays of structs, maps and slices of slices (or arrays) are rendered as tables.
+func isTable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Slice, reflect.Array:
+		elem := t.Elem()
+		if st := structType(elem); st != nil {
+			return len(exportedFields(st)) > 0
+		}
+		if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+			// []byte is printed as a hexdump.
+			return elem.Elem().Kind() != reflect.Uint8
+		}
+	}
+	return false
+}
+
+// newTable returns a table to render v. newTable returns nil if v is not rendered as a table.
+func (c PrettyConfig) newTable(v interface{}) *table {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || !isTable(rv.Type()) || rv.Len() == 0 {
+		return nil
+	}
+	if rv.Type().Implements(stringerType) || rv.Type().Implements(errorType) {
+		return nil
+	}
+	if rv.Kind() == reflect.Map {
+		return c.mapTable(rv)
+	}
+	t := &table{total: rv.Len(), pageSize: c.TablePageSize}
+	n := rv.Len()
+	if c.MaxTableRows > 0 && n > c.MaxTableRows {
+		n = c.MaxTableRows
+	}
+	if st := structType(rv.Type().Elem()); st != nil {
+		fields := c.limitColumns(exportedFields(st))
+		t.columns = append([]string{""}, fieldNames(st, fields)...)
+		for i := 0; i < n; i++ {
+			t.rows = append(t.rows, append([]string{strconv.Itoa(i)}, c.structCells(rv.Index(i), fields)...))
+		}
+		return t
+	}
+	// A slice of slices.
+	width := 0
+	for i := 0; i < n; i++ {
+		row := rv.Index(i)
+		if row.Len() > width {
+			width = row.Len()
+		}
+	}
+	cols := make([]int, width)
+	for i := range cols {
+		cols[i] = i
+	}
+	cols = c.limitColumns(cols)
+	t.columns = []string{""}
+	for _, i := range cols {
+		t.columns = append(t.columns, strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ {
+		row := rv.Index(i)
+		cells := []string{strconv.Itoa(i)}
+		for _, j := range cols {
+			if j < row.Len() {
+				cells = append(cells, c.cell(row.Index(j)))
+			} else {
+				cells = append(cells, "")
+			}
+		}
+		t.rows = append(t.rows, cells)
+	}
+	return t
+}
+
+func (c PrettyConfig) mapTable(v reflect.Value) *table {
+	p := &prettyPrinter{conf: c, visiting: make(map[uintptr]bool)}
+	keys := sortedKeys(p, v)
+	t := &table{total: len(keys), pageSize: c.TablePageSize}
+	if c.MaxTableRows > 0 && len(keys) > c.MaxTableRows {
+		keys = keys[:c.MaxTableRows]
+	}
+	st := structType(v.Type().Elem())
+	var fields []int
+	if st != nil {
+		fields = c.limitColumns(exportedFields(st))
+	}
+	if len(fields) > 0 {
+		t.columns = append([]string{"key"}, fieldNames(st, fields)...)
+	} else {
+		t.columns = []string{"key", "value"}
+	}
+	for _, k := range keys {
+		val := v.MapIndex(k)
+		cells := []string{c.cell(k)}
+		if len(fields) > 0 {
+			cells = append(cells, c.structCells(val, fields)...)
+		} else {
+			cells = append(cells, c.cell(val))
+		}
+		t.rows = append(t.rows, cells)
+	}
+	return t
+}
+
+// limitColumns truncates cols to MaxTableColumns.
+func (c PrettyConfig) limitColumns(cols []int) []int {
+	if c.MaxTableColumns > 0 && len(cols) > c.MaxTableColumns {
+		return cols[:c.MaxTableColumns]
+	}
+	return cols
+}
+
+func fieldNames(st reflect.Type, fields []int) []string {
+	var names []string
+	for _, i := range fields {
+		names = append(names, strings.TrimPrefix(st.Field(i).Name, lgoExportPrefix))
+	}
+	return names
+}
+
+// structCells returns cells of fields of v, which is a struct or a pointer to a struct.
+func (c PrettyConfig) structCells(v reflect.Value, fields []int) []string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			cells := make([]string, len(fields))
+			if len(cells) > 0 {
+				cells[0] = "nil"
+			}
+			return cells
+		}
+		v = v.Elem()
+	}
+	var cells []string
+	for _, i := range fields {
+		cells = append(cells, c.cell(v.Field(i)))
+	}
+	return cells
+}
+
+// cell formats v in a line.
+func (c PrettyConfig) cell(v reflect.Value) string {
+	conf := c
+	conf.Width = 0
+	var s string
+	if v.CanInterface() {
+		s = conf.Sprint(v.Interface())
+	} else {
+		p := &prettyPrinter{conf: conf, visiting: make(map[uintptr]bool)}
+		s = p.format(v, 0)
+	}
+	s = strings.Replace(s, "\n", " ", -1)
+	if utf8.RuneCountInString(s) > maxCellLen {
+		s = string([]rune(s)[:maxCellLen-3]) + "..."
+	}
+	return s
+}
+
+// omitted returns a message about rows omitted from the table.
+func (t *table) omitted() string {
+	if t.total <= len(t.rows) {
+		return ""
+	}
+	return fmt.Sprintf("... %d more rows (%d rows in total)", t.total-len(t.rows), t.total)
+}
+
+// String returns the text representation of the table.
+func (t *table) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.columns, "\t"))
+	var seps []string
+	for _, c := range t.columns {
+		seps = append(seps, strings.Repeat("-", utf8.RuneCountInString(c)))
+	}
+	fmt.Fprintln(w, strings.Join(seps, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for i, l := range lines {
+		// Remove padding of empty cells at the ends of rows.
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	s := strings.Join(lines, "\n")
+	if msg := t.omitted(); msg != "" {
+		s += "\n" + msg
+	}
+	return s
+}
+
+func (t *table) writeHTMLRows(buf *bytes.Buffer, rows [][]string) {
+	buf.WriteString("<table><thead><tr>")
+	for _, c := range t.columns {
+		buf.WriteString("<th>" + html.EscapeString(c) + "</th>")
+	}
+	buf.WriteString("</tr></thead><tbody>")
+	for _, row := range rows {
+		buf.WriteString("<tr>")
+		for i, c := range row {
+			if i == 0 {
+				buf.WriteString("<th>" + html.EscapeString(c) + "</th>")
+			} else {
+				buf.WriteString("<td>" + html.EscapeString(c) + "</td>")
+			}
+		}
+		buf.WriteString("</tr>")
+	}
+	buf.WriteString("</tbody></table>")
+}
+
+// HTML returns the HTML representation of the table.
+// Rows are split into pages and pages except the first one are collapsed in <details> elements
+// so that long tables are readable without JavaScript (e.g. in notebooks converted with nbconvert).
+func (t *table) HTML() string {
+	var buf bytes.Buffer
+	buf.WriteString("<div>")
+	size := t.pageSize
+	if size <= 0 {
+		size = len(t.rows)
+	}
+	for start := 0; start < len(t.rows); start += size {
+		end := start + size
+		if end > len(t.rows) {
+			end = len(t.rows)
+		}
+		if start == 0 {
+			t.writeHTMLRows(&buf, t.rows[start:end])
+			continue
+		}
+		fmt.Fprintf(&buf, "<details><summary>rows %d-%d</summary>", start, end-1)
+		t.writeHTMLRows(&buf, t.rows[start:end])
+		buf.WriteString("</details>")
+	}
+	if msg := t.omitted(); msg != "" {
+		buf.WriteString("<p>" + html.EscapeString(msg) + "</p>")
+	}
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// MIMEBundle implements MIMEBundler.
+func (t *table) MIMEBundle() (data, metadata map[string]interface{}) {
+	return map[string]interface{}{
+		"text/html":  t.HTML(),
+		"text/plain": t.String(),
+	}, nil
+}
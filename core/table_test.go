@@ -0,0 +1,118 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+type tableOrder struct {
+	ID    int
+	Item  string
+	Price float64
+	note  string
+}
+
+func TestNewTable(t *testing.T) {
+	conf := DefaultPrettyConfig()
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"structs", []tableOrder{{1, "apple", 1.5, "x"}, {2, "banana", 0.25, "y"}}, strings.Join([]string{
+			"   ID  Item    Price",
+			"   --  ----    -----",
+			"0  1   apple   1.5",
+			"1  2   banana  0.25",
+		}, "\n")},
+		{"pointers", []*tableOrder{{ID: 1}, nil}, strings.Join([]string{
+			"   ID   Item  Price",
+			"   --   ----  -----",
+			"0  1          0",
+			"1  nil",
+		}, "\n")},
+		{"map", map[string]int{"b": 2, "a": 1}, strings.Join([]string{
+			"key  value",
+			"---  -----",
+			"a    1",
+			"b    2",
+		}, "\n")},
+		{"mapOfStructs", map[int]tableOrder{3: {ID: 3, Item: "c"}}, strings.Join([]string{
+			"key  ID  Item  Price",
+			"---  --  ----  -----",
+			"3    3   c     0",
+		}, "\n")},
+		{"2d", [][]int{{1, 2}, {3}}, strings.Join([]string{
+			"   0  1",
+			"   -  -",
+			"0  1  2",
+			"1  3",
+		}, "\n")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := conf.newTable(tc.v)
+			if tb == nil {
+				t.Fatalf("newTable(%#v) returned nil", tc.v)
+			}
+			if got := tb.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewTableNotTable(t *testing.T) {
+	conf := DefaultPrettyConfig()
+	for _, v := range []interface{}{
+		nil, 10, "str", []int{1, 2}, []tableOrder{}, [][]byte{[]byte("a")}, []prettyStringer{{}}, map[int]int{},
+	} {
+		if tb := conf.newTable(v); tb != nil {
+			t.Errorf("newTable(%#v) = %v; want nil", v, tb)
+		}
+	}
+}
+
+func TestTableTruncate(t *testing.T) {
+	conf := DefaultPrettyConfig()
+	conf.MaxTableRows = 5
+	conf.MaxTableColumns = 2
+	conf.TablePageSize = 2
+	var rows [][]int
+	for i := 0; i < 8; i++ {
+		rows = append(rows, []int{i, i * 10, i * 100})
+	}
+	tb := conf.newTable(rows)
+	if got, want := len(tb.rows), 5; got != want {
+		t.Errorf("got %d rows; want %d", got, want)
+	}
+	if got, want := strings.Join(tb.columns, ","), ",0,1"; got != want {
+		t.Errorf("got columns %q; want %q", got, want)
+	}
+	text := tb.String()
+	if want := "... 3 more rows (8 rows in total)"; !strings.HasSuffix(text, want) {
+		t.Errorf("%q does not end with %q", text, want)
+	}
+	h := tb.HTML()
+	for _, want := range []string{
+		"<details><summary>rows 2-3</summary>",
+		"<details><summary>rows 4-4</summary>",
+		"<p>... 3 more rows (8 rows in total)</p>",
+	} {
+		if !strings.Contains(h, want) {
+			t.Errorf("%q does not contain %q", h, want)
+		}
+	}
+	if got := strings.Count(h, "<details>"); got != 2 {
+		t.Errorf("got %d pages; want 2", got+1)
+	}
+}
+
+func TestTableHTMLEscape(t *testing.T) {
+	tb := DefaultPrettyConfig().newTable(map[string]string{"<a>": "&"})
+	want := "<div><table><thead><tr><th>key</th><th>value</th></tr></thead>" +
+		"<tbody><tr><th>&lt;a&gt;</th><td>&amp;</td></tr></tbody></table></div>"
+	if got := tb.HTML(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	tracebackRewriterMu sync.Mutex
+	tracebackRewriter   func(s string) string
+)
+
+// SetTracebackRewriter sets a function that rewrites stack traces and positions of lgo code before they are shown to users.
+// The runner sets a function that replaces positions and names in converted code with positions and names in cells.
+func SetTracebackRewriter(fn func(s string) string) {
+	tracebackRewriterMu.Lock()
+	defer tracebackRewriterMu.Unlock()
+	tracebackRewriter = fn
+}
+
+func rewriteTraceback(s string) string {
+	tracebackRewriterMu.Lock()
+	fn := tracebackRewriter
+	tracebackRewriterMu.Unlock()
+	if fn == nil {
+		return s
+	}
+	return fn(s)
+}
+
+// trimPanicStack removes frames of functions that recovered a panic from stack,
+// which is a stack trace taken in a deferred function while panicking.
+// The result starts with the header of the goroutine followed by the frame of panic.
+func trimPanicStack(stack string) string {
+	lines := strings.Split(stack, "\n")
+	for i := 1; i < len(lines); i += 2 {
+		if strings.HasPrefix(lines[i], "panic(") {
+			return lines[0] + "\n" + strings.Join(lines[i:], "\n")
+		}
+	}
+	return stack
+}
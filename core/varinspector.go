@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// VarInspectorTarget is the comm target name of the variable inspector.
+// This is compatible with the comm used by jupyterlab-variableinspector.
+const VarInspectorTarget = "jupyterlab_variableinspector"
+
+// VarInspector sends the list of variables to variable inspectors in the frontend via comms.
+// The frontend opens a comm with VarInspectorTarget and VarInspector replies with the list of variables
+// to comm_open and every comm_msg. Update sends the latest list to all opened comms.
+//
+// The list is taken only in Update, which the kernel calls between executions, because comm messages
+// are handled while lgo code is running and reading variables may race with lgo code that modifies them.
+// comm_open and comm_msg are answered with the list taken in the last Update.
+type VarInspector struct {
+	mu    sync.Mutex
+	comms map[*Comm]bool
+	// vars is the list of variables taken in the last Update.
+	vars []VarInfo
+}
+
+// NewVarInspector returns a new VarInspector and registers it to m.
+func NewVarInspector(m *CommManager) *VarInspector {
+	in := &VarInspector{comms: make(map[*Comm]bool)}
+	m.RegisterTarget(VarInspectorTarget, in.handleOpen)
+	return in
+}
+
+func (in *VarInspector) handleOpen(c *Comm, data map[string]interface{}) {
+	in.mu.Lock()
+	in.comms[c] = true
+	in.mu.Unlock()
+	c.OnMsg(func(data map[string]interface{}) {
+		in.send(GetExecContext(), c, in.lastVars())
+	})
+	c.OnClose(func(data map[string]interface{}) {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		delete(in.comms, c)
+	})
+	in.send(GetExecContext(), c, in.lastVars())
+}
+
+func (in *VarInspector) lastVars() []VarInfo {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.vars
+}
+
+// Update takes the latest list of variables and sends it to all opened comms.
+// The kernel calls Update after every execution with the context of the execution.
+func (in *VarInspector) Update(ctx context.Context) {
+	in.mu.Lock()
+	var comms []*Comm
+	for c := range in.comms {
+		comms = append(comms, c)
+	}
+	in.mu.Unlock()
+	// Take the list even if no comm is opened to answer comms opened later.
+	vars := Vars()
+	in.mu.Lock()
+	in.vars = vars
+	in.mu.Unlock()
+	for _, c := range comms {
+		in.send(ctx, c, vars)
+	}
+}
+
+func (in *VarInspector) send(ctx context.Context, c *Comm, vars []VarInfo) {
+	if err := c.send(ctx, varInspectorMessage(vars)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send variables to the inspector: %v\n", err)
+	}
+}
+
+// varInspectorMessage converts vars into the format of jupyterlab-variableinspector.
+func varInspectorMessage(vars []VarInfo) map[string]interface{} {
+	list := make([]interface{}, 0, len(vars))
+	for _, v := range vars {
+		var shape string
+		if v.Cap >= 0 {
+			shape = fmt.Sprintf("len=%d, cap=%d", v.Len, v.Cap)
+		} else if v.Len >= 0 {
+			shape = fmt.Sprintf("len=%d", v.Len)
+		}
+		list = append(list, map[string]interface{}{
+			"varName":    v.Name,
+			"varType":    v.Type,
+			"varSize":    formatBytes(v.Size),
+			"varShape":   shape,
+			"varContent": v.Preview,
+			"varCell":    v.Cell,
+			"isMatrix":   false,
+		})
+	}
+	return map[string]interface{}{
+		"method":    "update",
+		"variables": list,
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// lgoExportPrefix is the prefix the runner adds to top-level identifiers in lgo code.
+const lgoExportPrefix = "LgoExport_"
+
+// maxPreviewLen is the maximum length of VarInfo.Preview in runes.
+const maxPreviewLen = 60
+
+// registeredVar is a variable registered with LgoRegisterVar.
+type registeredVar struct {
+	name string
+	ptr  interface{}
+	cell int
+}
+
+var (
+	registeredVarsMu sync.Mutex
+	// registeredVars is the list of registered variables in the order of registration.
+	registeredVars []registeredVar
+)
+
+// cellOfCaller returns the number of the cell that calls the caller of cellOfCaller.
+// lgo code of the N-th execution is stored in a directory named execN (or execN_M if the execution runs code of
+// multiple cells). cellOfCaller returns 0 if it's not lgo code.
+func cellOfCaller() int {
+	_, file, _, ok := runtime.Caller(2)
+	if !ok {
+		return 0
+	}
+	dir := filepath.Base(filepath.Dir(file))
+	if !strings.HasPrefix(dir, "exec") {
+		return 0
+	}
+	dir = dir[len("exec"):]
+	if i := strings.IndexByte(dir, '_'); i >= 0 {
+		dir = dir[:i]
+	}
+	n, err := strconv.Atoi(dir)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// VarInfo is the information of a variable defined in lgo code.
+type VarInfo struct {
+	Name string
+	// Cell is the number of the execution that defined the variable. Cell is 0 if it's unknown.
+	Cell int
+	Type string
+	// Preview is the value formatted with %v and truncated.
+	Preview string
+	// Len and Cap are the length and the capacity of the value. They are -1 if the value does not have them.
+	Len, Cap int
+	// Size is the approximate memory size of the value in bytes including memory referred from the value.
+	// Size does not include memory referred indirectly if the value refers to too many values (See sizeVisitLimit).
+	Size uint64
+}
+
+// Vars returns the information of variables defined in lgo code sorted by their names.
+// If a variable is redefined, only the latest one is returned.
+func Vars() []VarInfo {
+	var infos []VarInfo
+	for _, v := range latestVars() {
+		infos = append(infos, newVarInfo(v))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// VarValues returns the current values of variables defined in lgo code keyed by their names.
+// The runner passes them to templates in cell magics (e.g. {{.x}} in %%html).
+func VarValues() map[string]interface{} {
+	values := make(map[string]interface{})
+	for name, v := range latestVars() {
+		values[name] = reflect.ValueOf(v.ptr).Elem().Interface()
+	}
+	return values
+}
+
+// latestVars returns the latest registered variables keyed by their names without lgoExportPrefix.
+func latestVars() map[string]registeredVar {
+	registeredVarsMu.Lock()
+	defer registeredVarsMu.Unlock()
+	latest := make(map[string]registeredVar)
+	for _, v := range registeredVars {
+		latest[strings.TrimPrefix(v.name, lgoExportPrefix)] = v
+	}
+	return latest
+}
+
+func newVarInfo(rv registeredVar) VarInfo {
+	v := reflect.ValueOf(rv.ptr).Elem()
+	info := VarInfo{
+		Name:    strings.TrimPrefix(rv.name, lgoExportPrefix),
+		Cell:    rv.cell,
+		Type:    strings.Replace(v.Type().String(), lgoExportPrefix, "", -1),
+		Preview: previewValue(v),
+		Len:     -1,
+		Cap:     -1,
+		Size:    deepSize(v),
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Slice:
+		info.Len, info.Cap = v.Len(), v.Cap()
+	case reflect.Map, reflect.String:
+		info.Len = v.Len()
+	}
+	return info
+}
+
+func previewValue(v reflect.Value) string {
+	var s string
+	if v.CanInterface() {
+		s = fmt.Sprintf("%v", v.Interface())
+	} else {
+		s = v.String()
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > maxPreviewLen {
+		s = string([]rune(s)[:maxPreviewLen-3]) + "..."
+	}
+	return s
+}
+
+// sizeVisitLimit is the maximum number of values deepSize visits for a value.
+// deepSize stops counting memory at the limit not to walk the entire heap for every variable.
+var sizeVisitLimit = 1 << 16
+
+// deepSize returns the approximate memory size of v including memory referred from v.
+// Memory referred multiple times is counted only once.
+// If v refers to more than sizeVisitLimit values, the size of the rest is not counted.
+func deepSize(v reflect.Value) uint64 {
+	w := &sizeWalker{seen: make(map[uintptr]bool), visits: sizeVisitLimit}
+	return w.size(v)
+}
+
+// sizeWalker computes the size of memory referred from values.
+type sizeWalker struct {
+	// seen records addresses already counted.
+	seen map[uintptr]bool
+	// visits is the number of values the walker can visit.
+	visits int
+}
+
+func (w *sizeWalker) size(v reflect.Value) uint64 {
+	size := uint64(v.Type().Size())
+	return size + w.referredSize(v)
+}
+
+// markSeen returns false if p was already counted or the walker reached the limit. Otherwise, it records p.
+func (w *sizeWalker) markSeen(p uintptr) bool {
+	if w.seen[p] || w.visits <= 0 {
+		return false
+	}
+	w.visits--
+	w.seen[p] = true
+	return true
+}
+
+// referredSize returns the size of memory referred from v, excluding the size of v itself.
+func (w *sizeWalker) referredSize(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !w.markSeen(v.Pointer()) {
+			return 0
+		}
+		return w.size(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return w.size(v.Elem())
+	case reflect.String:
+		if v.Len() == 0 {
+			return 0
+		}
+		s := v.String()
+		if !w.markSeen((*reflect.StringHeader)(unsafe.Pointer(&s)).Data) {
+			return 0
+		}
+		return uint64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || !w.markSeen(v.Pointer()) {
+			return 0
+		}
+		size := uint64(v.Cap()) * uint64(v.Type().Elem().Size())
+		for i := 0; i < v.Len() && w.visits > 0; i++ {
+			size += w.referredSize(v.Index(i))
+		}
+		return size
+	case reflect.Array:
+		var size uint64
+		for i := 0; i < v.Len() && w.visits > 0; i++ {
+			size += w.referredSize(v.Index(i))
+		}
+		return size
+	case reflect.Struct:
+		var size uint64
+		for i := 0; i < v.NumField(); i++ {
+			size += w.referredSize(v.Field(i))
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() || !w.markSeen(v.Pointer()) {
+			return 0
+		}
+		// This does not include the overhead of hash tables.
+		size := uint64(v.Len()) * uint64(v.Type().Key().Size()+v.Type().Elem().Size())
+		for _, key := range v.MapKeys() {
+			if w.visits <= 0 {
+				break
+			}
+			size += w.referredSize(key) + w.referredSize(v.MapIndex(key))
+		}
+		return size
+	}
+	return 0
+}
+
+// FormatVars formats vars as a table. This is used to implement %whos.
+func FormatVars(vars []VarInfo) string {
+	if len(vars) == 0 {
+		return "No variables are defined.\n"
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Variable\tCell\tType\tLen/Cap\tSize\tValue")
+	fmt.Fprintln(w, "--------\t----\t----\t-------\t----\t-----")
+	for _, v := range vars {
+		var cell, lenCap string
+		if v.Cell > 0 {
+			cell = strconv.Itoa(v.Cell)
+		}
+		if v.Cap >= 0 {
+			lenCap = fmt.Sprintf("%d/%d", v.Len, v.Cap)
+		} else if v.Len >= 0 {
+			lenCap = strconv.Itoa(v.Len)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, cell, v.Type, lenCap, formatBytes(v.Size), v.Preview)
+	}
+	w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeepSize(t *testing.T) {
+	type pair struct {
+		a, b *int
+	}
+	n := 10
+	shared := &n
+	type node struct {
+		next *node
+		val  int64
+	}
+	loop := &node{val: 1}
+	loop.next = loop
+	tests := []struct {
+		name string
+		v    interface{}
+		want uint64
+	}{
+		{"int", 3, 8},
+		{"string", "hello", 16 + 5},
+		{"slice", make([]int32, 2, 10), 24 + 40},
+		{"strings", []string{"ab", "cde"}, 24 + 32 + 5},
+		{"shared", pair{shared, shared}, 16 + 8},
+		{"loop", loop, 8 + 16},
+		{"nilmap", map[string]int(nil), 8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := deepSize(reflect.ValueOf(tc.v)); got != tc.want {
+				t.Errorf("deepSize(%#v) = %d; want %d", tc.v, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeepSize_limit(t *testing.T) {
+	orig := sizeVisitLimit
+	sizeVisitLimit = 3
+	defer func() { sizeVisitLimit = orig }()
+
+	type node struct {
+		next *node
+		val  int64
+	}
+	var list *node
+	for i := 0; i < 10; i++ {
+		list = &node{next: list}
+	}
+	// Only the first 3 nodes are counted.
+	if got, want := deepSize(reflect.ValueOf(list)), uint64(8+3*16); got != want {
+		t.Errorf("deepSize(list) = %d; want %d", got, want)
+	}
+	m := map[int][]int{1: make([]int, 4), 2: make([]int, 4), 3: make([]int, 4)}
+	// The map and 2 slices in it are counted.
+	if got, want := deepSize(reflect.ValueOf(m)), uint64(8+3*(8+24)+2*32); got != want {
+		t.Errorf("deepSize(m) = %d; want %d", got, want)
+	}
+}
+
+func TestPreviewValue(t *testing.T) {
+	if got, want := previewValue(reflect.ValueOf([]int{1, 2, 3})), "[1 2 3]"; got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+	if got, want := previewValue(reflect.ValueOf("a\n  b")), "a b"; got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+	long := previewValue(reflect.ValueOf(strings.Repeat("x", 100)))
+	if len(long) != maxPreviewLen || !strings.HasSuffix(long, "...") {
+		t.Errorf("Got %q; want a truncated string", long)
+	}
+}
+
+func TestVars(t *testing.T) {
+	registeredVarsMu.Lock()
+	saved := registeredVars
+	registeredVars = nil
+	registeredVarsMu.Unlock()
+	defer func() {
+		registeredVarsMu.Lock()
+		registeredVars = saved
+		registeredVarsMu.Unlock()
+	}()
+
+	x, y, oldY := []int{1, 2}, "hello", 10
+	LgoRegisterVar("y", &oldY)
+	LgoRegisterVar("x", &x)
+	LgoRegisterVar("y", &y)
+	vars := Vars()
+	if len(vars) != 2 {
+		t.Fatalf("Got %d vars; want 2: %+v", len(vars), vars)
+	}
+	wantX := VarInfo{Name: "x", Type: "[]int", Preview: "[1 2]", Len: 2, Cap: 2, Size: 24 + 16}
+	if vars[0] != wantX {
+		t.Errorf("Got %+v; want %+v", vars[0], wantX)
+	}
+	wantY := VarInfo{Name: "y", Type: "string", Preview: "hello", Len: 5, Cap: -1, Size: 16 + 5}
+	if vars[1] != wantY {
+		t.Errorf("Got %+v; want %+v", vars[1], wantY)
+	}
+
+	table := FormatVars(vars)
+	for _, want := range []string{"Variable", "[]int", "2/2", "40B", "hello"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("%q does not contain %q", table, want)
+		}
+	}
+	if got := FormatVars(nil); got != "No variables are defined.\n" {
+		t.Errorf("Got %q for no vars", got)
+	}
+}
+
+func TestVarValues(t *testing.T) {
+	registeredVarsMu.Lock()
+	saved := registeredVars
+	registeredVars = nil
+	registeredVarsMu.Unlock()
+	defer func() {
+		registeredVarsMu.Lock()
+		registeredVars = saved
+		registeredVarsMu.Unlock()
+	}()
+
+	x, oldX := 3, "old"
+	LgoRegisterVar("LgoExport_x", &oldX)
+	LgoRegisterVar("LgoExport_x", &x)
+	x = 4
+	got := VarValues()
+	if want := map[string]interface{}{"x": 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+}
+
+func TestVarInspector(t *testing.T) {
+	sender := &recordCommSender{}
+	m := NewCommManager(sender)
+	in := NewVarInspector(m)
+	if err := m.HandleOpen(commCtx, "id0", VarInspectorTarget, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HandleMsg(commCtx, "id0", map[string]interface{}{"method": "inspect"}); err != nil {
+		t.Fatal(err)
+	}
+	in.Update(context.Background())
+	if len(sender.sent) != 3 {
+		t.Fatalf("Got %d messages; want 3: %v", len(sender.sent), sender.sent)
+	}
+
+	// comm_msg is answered with the list taken in the last Update, not with the current variables.
+	registeredVarsMu.Lock()
+	saved := registeredVars
+	registeredVarsMu.Unlock()
+	defer func() {
+		registeredVarsMu.Lock()
+		registeredVars = saved
+		registeredVarsMu.Unlock()
+	}()
+	y := 10
+	LgoRegisterVar("LgoExport_newvar", &y)
+	if err := m.HandleMsg(commCtx, "id0", map[string]interface{}{"method": "inspect"}); err != nil {
+		t.Fatal(err)
+	}
+	if last := sender.sent[len(sender.sent)-1]; strings.Contains(last, "newvar") || last != sender.sent[2] {
+		t.Errorf("comm_msg was answered with the current variables: %s", last)
+	}
+	in.Update(context.Background())
+	if last := sender.sent[len(sender.sent)-1]; !strings.Contains(last, "newvar") {
+		t.Errorf("Update did not send the current variables: %s", last)
+	}
+	if err := m.HandleClose(commCtx, "id0", nil); err != nil {
+		t.Fatal(err)
+	}
+	in.Update(context.Background())
+	if len(sender.sent) != 5 {
+		t.Errorf("Update sent a message to a closed comm: %v", sender.sent[5:])
+	}
+}
+
+func TestVarInspectorMessage(t *testing.T) {
+	msg := varInspectorMessage([]VarInfo{{Name: "x", Type: "[]int", Preview: "[1 2]", Len: 2, Cap: 3, Size: 48}})
+	want := map[string]interface{}{
+		"method": "update",
+		"variables": []interface{}{map[string]interface{}{
+			"varName":    "x",
+			"varType":    "[]int",
+			"varSize":    "48B",
+			"varShape":   "len=2, cap=3",
+			"varContent": "[1 2]",
+			"varCell":    0,
+			"isMatrix":   false,
+		}},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("Got %v; want %v", msg, want)
+	}
+}
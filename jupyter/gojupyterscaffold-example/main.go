@@ -31,7 +31,8 @@ func (*handlers) HandleExecuteRequest(
 	ctx context.Context,
 	r *scaffold.ExecuteRequest,
 	stream func(string, string),
-	displayData func(data *scaffold.DisplayData, update bool)) *scaffold.ExecuteResult {
+	displayData func(data *scaffold.DisplayData, update bool),
+	readInput func(ctx context.Context, prompt string, password bool) (string, error)) *scaffold.ExecuteResult {
 	var i int
 	tick := time.Tick(time.Second)
 	cancelled := false
@@ -99,6 +100,19 @@ func (*handlers) HandleIsComplete(req *scaffold.IsCompleteRequest) *scaffold.IsC
 	return nil
 }
 
+func (*handlers) HandleCommOpen(ctx context.Context, req *scaffold.CommOpen, writeStream func(name, text string), writeDisplayData func(data *scaffold.DisplayData, update bool)) {
+}
+
+func (*handlers) HandleCommMsg(ctx context.Context, req *scaffold.CommMsg, writeStream func(name, text string), writeDisplayData func(data *scaffold.DisplayData, update bool)) {
+}
+
+func (*handlers) HandleCommClose(ctx context.Context, req *scaffold.CommClose, writeStream func(name, text string), writeDisplayData func(data *scaffold.DisplayData, update bool)) {
+}
+
+func (*handlers) HandleCommInfo(req *scaffold.CommInfoRequest) *scaffold.CommInfoReply {
+	return nil
+}
+
 func main() {
 	flag.Parse()
 	fmt.Printf("os.Args == %+v\n", os.Args)
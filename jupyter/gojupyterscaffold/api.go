@@ -1,20 +1,45 @@
 package gojupyterscaffold
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrStdinNotAllowed is returned from readInput of HandleExecuteRequest if allow_stdin of execute_request is false.
+var ErrStdinNotAllowed = errors.New("stdin is not allowed in this execution")
 
 type RequestHandlers interface {
 	HandleKernelInfo() KernelInfo
 	// HandleExecuteRequest handles execute_request.
 	// writeStream sends stdout/stderr texts and writeDisplayData sends display_data
 	// (or update_display_data if update is true) to the client.
+	// readInput sends input_request to the client and blocks until input_reply is received,
+	// ctx of HandleExecuteRequest is done or ctx passed to readInput is done.
+	// readInput returns ErrStdinNotAllowed if the client does not support input_request.
 	HandleExecuteRequest(ctx context.Context,
 		req *ExecuteRequest,
 		writeStream func(name, text string),
-		writeDisplayData func(data *DisplayData, update bool)) *ExecuteResult
+		writeDisplayData func(data *DisplayData, update bool),
+		readInput func(ctx context.Context, prompt string, password bool) (string, error)) *ExecuteResult
 	HandleComplete(req *CompleteRequest) *CompleteReply
 	HandleInspect(req *InspectRequest) *InspectReply
 	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#code-completeness
 	HandleIsComplete(req *IsCompleteRequest) *IsCompleteReply
+	// HandleCommOpen, HandleCommMsg and HandleCommClose handle comm messages sent from the client.
+	// They are invoked sequentially in the order the messages are received.
+	// ctx is canceled when the kernel is interrupted like ctx of HandleExecuteRequest.
+	// Messages are dropped if the client sends them faster than the handlers process them.
+	// writeStream and writeDisplayData send outputs for the comm message like HandleExecuteRequest.
+	// Use Server.SendCommOpen, Server.SendCommMsg and Server.SendCommClose to send comm messages to the client.
+	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+	HandleCommOpen(ctx context.Context, req *CommOpen,
+		writeStream func(name, text string), writeDisplayData func(data *DisplayData, update bool))
+	HandleCommMsg(ctx context.Context, req *CommMsg,
+		writeStream func(name, text string), writeDisplayData func(data *DisplayData, update bool))
+	HandleCommClose(ctx context.Context, req *CommClose,
+		writeStream func(name, text string), writeDisplayData func(data *DisplayData, update bool))
+	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-info
+	HandleCommInfo(req *CommInfoRequest) *CommInfoReply
 }
 
 type KernelInfo struct {
@@ -94,6 +119,13 @@ type ExecuteResult struct {
 	Status         string `json:"status"`
 	ExecutionCount int    `json:"execution_count,omitempty"`
 	// data and metadata are omitted because they are covered by DisplayData.
+
+	// Ename, Evalue and Traceback describe the error if Status is "error".
+	// When Status is "error", they are also published as an error message on IOPub.
+	// c.f. http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-errors
+	Ename     string   `json:"ename,omitempty"`
+	Evalue    string   `json:"evalue,omitempty"`
+	Traceback []string `json:"traceback,omitempty"`
 }
 
 // DisplayData represents display_data defined in http://jupyter-client.readthedocs.io/en/latest/messaging.html#display-data
@@ -130,3 +162,53 @@ type IsCompleteReply struct {
 	// field does not exist.
 	Indent string `json:"indent"`
 }
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#opening-a-comm
+type CommOpen struct {
+	CommID     string                 `json:"comm_id"`
+	TargetName string                 `json:"target_name"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-messages
+type CommMsg struct {
+	CommID string                 `json:"comm_id"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#tearing-down-comms
+type CommClose struct {
+	CommID string                 `json:"comm_id"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-info
+type CommInfoRequest struct {
+	// Optional, the target name. If specified, only comms with the target are returned.
+	TargetName string `json:"target_name,omitempty"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-info
+type CommInfoReply struct {
+	Status string `json:"status"`
+	// A dictionary of the comms, indexed by uuids.
+	Comms map[string]CommInfo `json:"comms"`
+}
+
+type CommInfo struct {
+	TargetName string `json:"target_name"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-channel
+type InputRequest struct {
+	// The text to show at the prompt
+	Prompt string `json:"prompt"`
+	// Is the request for a password? If so, the frontend shouldn't echo input.
+	Password bool `json:"password"`
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-channel
+type InputReply struct {
+	// The string entered by the user
+	Value string `json:"value"`
+}
@@ -0,0 +1,152 @@
+// This file implements comm messages, which are used to build interactive widgets.
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+
+package gojupyterscaffold
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+)
+
+const commQueueSize = 1 << 8
+
+// emptyCommData is set to Data of comm messages if data is missing because Jupyter expects a dict.
+var emptyCommData = make(map[string]interface{})
+
+// commQueue handles comm_open, comm_msg and comm_close from the client sequentially.
+// comm messages are not handled in the loop of shellSocket so that slow handlers do not block other requests.
+type commQueue struct {
+	serverCtx  context.Context
+	queue      chan *message
+	iopub      *iopubSocket
+	handlers   RequestHandlers
+	currentCtx *contextAndCancel
+}
+
+func newCommQueue(ctx context.Context, iopub *iopubSocket, handlers RequestHandlers) *commQueue {
+	return &commQueue{
+		serverCtx: ctx,
+		queue:     make(chan *message, commQueueSize),
+		iopub:     iopub,
+		handlers:  handlers,
+	}
+}
+
+// push adds msg to the queue. push does not block the loop of shellSocket.
+// If the queue is full because handlers are too slow, msg is dropped. A dropped comm_open is rejected with comm_close.
+func (q *commQueue) push(msg *message) {
+	select {
+	case q.queue <- msg:
+		return
+	default:
+	}
+	glog.Warningf("Dropped %s because the comm queue is full", msg.Header.MsgType)
+	open, ok := msg.Content.(*CommOpen)
+	if !ok {
+		return
+	}
+	err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
+		return q.iopub.sendComm(ctx, "comm_close", &CommClose{CommID: open.CommID, Data: emptyCommData})
+	}, msg)
+	if err != nil {
+		glog.Errorf("Failed to reject comm_open: %v", err)
+	}
+}
+
+// cancelCurrent cancels the context of the comm message being handled.
+func (q *commQueue) cancelCurrent() {
+	cur := q.currentCtx
+	if cur != nil {
+		cur.cancel()
+	}
+}
+
+func (q *commQueue) loop() {
+	for {
+		var msg *message
+		select {
+		case msg = <-q.queue:
+		case <-q.serverCtx.Done():
+			return
+		}
+		err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
+			ctx, cancel := context.WithCancel(ctx)
+			q.currentCtx = &contextAndCancel{ctx, cancel}
+			defer func() {
+				cancel()
+				q.currentCtx = nil
+			}()
+			writeStream := func(name, text string) {
+				q.iopub.sendStream(name, text, msg)
+			}
+			writeDisplayData := func(data *DisplayData, update bool) {
+				q.iopub.sendDisplayData(data, msg, update)
+			}
+			switch content := msg.Content.(type) {
+			case *CommOpen:
+				q.handlers.HandleCommOpen(ctx, content, writeStream, writeDisplayData)
+			case *CommMsg:
+				q.handlers.HandleCommMsg(ctx, content, writeStream, writeDisplayData)
+			case *CommClose:
+				q.handlers.HandleCommClose(ctx, content, writeStream, writeDisplayData)
+			default:
+				glog.Errorf("Unexpected content in %s: %#v", msg.Header.MsgType, content)
+			}
+			return nil
+		}, msg)
+		if err != nil {
+			glog.Errorf("Failed to handle %s: %v", msg.Header.MsgType, err)
+		}
+	}
+}
+
+// sendComm sends a comm message. The request handled in ctx (See WithOngoingContext) is set to the parent of the message.
+func (s *iopubSocket) sendComm(ctx context.Context, msgType string, content interface{}) error {
+	var msg message
+	msg.Identity = [][]byte{[]byte(msgType)}
+	msg.Header.MsgType = msgType
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.MsgID = genMsgID()
+	if parent := parentFromContext(ctx); parent != nil {
+		msg.Header.Session = parent.Header.Session
+		msg.ParentHeader = parent.Header
+	}
+	msg.Content = content
+	return s.sendMessage(&msg)
+}
+
+// SendCommOpen sends comm_open to the client to open a comm from the kernel.
+// ctx is the context passed to RequestHandlers (or derived from it). The request of ctx is set to the parent of the message.
+// This method is goroutine-safe.
+func (s *Server) SendCommOpen(ctx context.Context, c *CommOpen) error {
+	if c.Data == nil {
+		copy := *c
+		copy.Data = emptyCommData
+		c = &copy
+	}
+	return s.iopub.sendComm(ctx, "comm_open", c)
+}
+
+// SendCommMsg sends comm_msg to the client.
+// ctx is used in the same way as SendCommOpen. This method is goroutine-safe.
+func (s *Server) SendCommMsg(ctx context.Context, c *CommMsg) error {
+	if c.Data == nil {
+		copy := *c
+		copy.Data = emptyCommData
+		c = &copy
+	}
+	return s.iopub.sendComm(ctx, "comm_msg", c)
+}
+
+// SendCommClose sends comm_close to the client.
+// ctx is used in the same way as SendCommOpen. This method is goroutine-safe.
+func (s *Server) SendCommClose(ctx context.Context, c *CommClose) error {
+	if c.Data == nil {
+		copy := *c
+		copy.Data = emptyCommData
+		c = &copy
+	}
+	return s.iopub.sendComm(ctx, "comm_close", c)
+}
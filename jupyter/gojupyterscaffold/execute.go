@@ -26,15 +26,17 @@ type executeQueue struct {
 	serverCtx  context.Context
 	queue      chan *executeQueueItem
 	iopub      *iopubSocket
+	stdin      *stdinSocket
 	handlers   RequestHandlers
 	currentCtx *contextAndCancel
 }
 
-func newExecuteQueue(ctx context.Context, iopub *iopubSocket, handlers RequestHandlers) *executeQueue {
+func newExecuteQueue(ctx context.Context, iopub *iopubSocket, stdin *stdinSocket, handlers RequestHandlers) *executeQueue {
 	return &executeQueue{
 		serverCtx: ctx,
 		queue:     make(chan *executeQueueItem, executeQueueSize),
 		iopub:     iopub,
+		stdin:     stdin,
 		handlers:  handlers,
 	}
 }
@@ -105,7 +107,15 @@ loop:
 					q.iopub.sendStream(name, text, item.req)
 				}, func(data *DisplayData, update bool) {
 					q.iopub.sendDisplayData(data, item.req, update)
+				}, func(ctx context.Context, prompt string, password bool) (string, error) {
+					if !exReq.AllowStdin {
+						return "", ErrStdinNotAllowed
+					}
+					return q.stdin.readInput(cur, ctx, item.req, prompt, password)
 				})
+			if result.Status == "error" {
+				q.iopub.sendError(result, item.req)
+			}
 			res := newMessageWithParent(item.req)
 			res.Header.MsgType = "execute_reply"
 			res.Content = &result
@@ -64,13 +64,14 @@ type Server struct {
 	shell   *shellSocket
 	control *shellSocket
 	iopub   *iopubSocket
-	stdin   *zmq.Socket
+	stdin   *stdinSocket
 	hb      *zmq.Socket
 
 	// Attribute
 	connInfo *connectionInfo
 
 	execQueue *executeQueue
+	commQueue *commQueue
 }
 
 // NewServer returns a new jupyter kernel server.
@@ -97,23 +98,22 @@ func NewServer(connectionFile string, handlers RequestHandlers) (server *Server,
 		return nil, fmt.Errorf("Failed to create iopub socket: %v", err)
 	}
 
-	execQueue := newExecuteQueue(serverCtx, iopub, handlers)
-	shell, err := newShellSocket(serverCtx, ctx, "shell", cinfo, iopub, handlers, cancelCtx, execQueue)
+	stdin, err := newStdinSocket(ctx, cinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	execQueue := newExecuteQueue(serverCtx, iopub, stdin, handlers)
+	commQueue := newCommQueue(serverCtx, iopub, handlers)
+	shell, err := newShellSocket(serverCtx, ctx, "shell", cinfo, iopub, handlers, cancelCtx, execQueue, commQueue)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create shell socket: %v", err)
 	}
-	control, err := newShellSocket(serverCtx, ctx, "control", cinfo, iopub, handlers, cancelCtx, execQueue)
+	control, err := newShellSocket(serverCtx, ctx, "control", cinfo, iopub, handlers, cancelCtx, execQueue, commQueue)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create control socket: %v", err)
 	}
 
-	stdin, err := ctx.NewSocket(zmq.ROUTER)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
-	}
-	if err := stdin.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
-		return nil, fmt.Errorf("Failed to bind shell socket: %v", err)
-	}
 	// Ref: Python version of HeartBeat
 	// https://github.com/ipython/ipykernel/blob/master/ipykernel/heartbeat.py
 	hb, err := ctx.NewSocket(zmq.REP)
@@ -134,6 +134,7 @@ func NewServer(connectionFile string, handlers RequestHandlers) (server *Server,
 		hb:        hb,
 		connInfo:  cinfo,
 		execQueue: execQueue,
+		commQueue: commQueue,
 	}, nil
 }
 
@@ -147,8 +148,9 @@ func (s *Server) monitorSigint() {
 	signal.Notify(ch, syscall.SIGINT)
 	go func() {
 		for _ = range ch {
-			glog.Info("Received SIGINT. Cancelling an ongoing execute_request")
+			glog.Info("Received SIGINT. Cancelling an ongoing execute_request and comm message")
 			s.execQueue.cancelCurrent()
+			s.commQueue.cancelCurrent()
 		}
 	}()
 }
@@ -190,6 +192,11 @@ func (s *Server) Loop() {
 		s.execQueue.loop()
 		close(execDone)
 	}()
+	go s.commQueue.loop()
+	go func() {
+		s.stdin.loop()
+		sockDone <- struct{}{}
+	}()
 	go func() {
 		s.shell.loop()
 		sockDone <- struct{}{}
@@ -206,9 +213,13 @@ func (s *Server) Loop() {
 	if err := s.control.notifyLoopEnd(); err != nil {
 		glog.Errorf("Failed to notify the loop end to control socket: %v", err)
 	}
+	if err := s.stdin.notifyLoopEnd(); err != nil {
+		glog.Errorf("Failed to notify the loop end to stdin socket: %v", err)
+	}
 	// Wait loop ends
 	<-sockDone
 	<-sockDone
+	<-sockDone
 
 	if err := s.iopub.close(); err != nil {
 		glog.Errorf("Failed to close iopub socket: %v", err)
@@ -219,6 +230,7 @@ func (s *Server) Loop() {
 	if err := s.control.close(); err != nil {
 		glog.Errorf("Failed to close control socket: %v", err)
 	}
-
-	// TODO: Support stdin.
+	if err := s.stdin.close(); err != nil {
+		glog.Errorf("Failed to close stdin socket: %v", err)
+	}
 }
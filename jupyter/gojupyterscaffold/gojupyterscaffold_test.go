@@ -1,6 +1,7 @@
 package gojupyterscaffold
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 )
@@ -47,3 +48,68 @@ func TestMessageHeader(t *testing.T) {
 		t.Errorf("Unexpected header: %#v", header)
 	}
 }
+
+func TestInputReply(t *testing.T) {
+	key := []byte("37485811-fb40116f79cb23af4056c7a8")
+	req := &message{Identity: [][]byte{[]byte("client")}}
+	req.Header.MsgID = "request-id"
+	req.Header.MsgType = "input_request"
+	reply := newMessageWithParent(req)
+	reply.Header.MsgType = "input_reply"
+	reply.Content = map[string]interface{}{"value": "hello"}
+	msgs, err := reply.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg message
+	if err := msg.Unmarshal(msgs, key); err != nil {
+		t.Fatal(err)
+	}
+	content, ok := msg.Content.(*InputReply)
+	if !ok {
+		t.Fatalf("Unexpected content: %#v", msg.Content)
+	}
+	if content.Value != "hello" {
+		t.Errorf("Got %q; want %q", content.Value, "hello")
+	}
+	if msg.ParentHeader.MsgID != "request-id" {
+		t.Errorf("Got %q; want %q", msg.ParentHeader.MsgID, "request-id")
+	}
+}
+
+func TestExecuteResultJSON(t *testing.T) {
+	b, err := json.Marshal(&ExecuteResult{Status: "ok", ExecutionCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"status":"ok","execution_count":1}`; got != want {
+		t.Errorf("Got %s; want %s", got, want)
+	}
+	b, err = json.Marshal(&ExecuteResult{
+		Status:         "error",
+		ExecutionCount: 2,
+		Ename:          "Panic",
+		Evalue:         "fail",
+		Traceback:      []string{"panic: fail"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"status":"error","execution_count":2,"ename":"Panic","evalue":"fail","traceback":["panic: fail"]}`
+	if got := string(b); got != want {
+		t.Errorf("Got %s; want %s", got, want)
+	}
+}
+
+func TestCommQueuePush_full(t *testing.T) {
+	q := newCommQueue(context.Background(), nil, nil)
+	for i := 0; i < commQueueSize+10; i++ {
+		msg := &message{Content: &CommMsg{CommID: "comm"}}
+		msg.Header.MsgType = "comm_msg"
+		// push must not block even if nobody consumes the queue.
+		q.push(msg)
+	}
+	if n := len(q.queue); n != commQueueSize {
+		t.Errorf("Got %d; want %d", n, commQueueSize)
+	}
+}
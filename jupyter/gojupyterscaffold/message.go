@@ -45,6 +45,16 @@ func newContentForMsgType(header *messageHeader) interface{} {
 		return &InspectRequest{}
 	case "is_complete_request":
 		return &IsCompleteRequest{}
+	case "comm_open":
+		return &CommOpen{}
+	case "comm_msg":
+		return &CommMsg{}
+	case "comm_close":
+		return &CommClose{}
+	case "comm_info_request":
+		return &CommInfoRequest{}
+	case "input_reply":
+		return &InputReply{}
 	}
 	return nil
 }
@@ -73,6 +73,19 @@ func (s *iopubSocket) cancelOngoings() {
 	}
 }
 
+// parentKey is the key of the request handled in a context.
+type parentKey struct{}
+
+// parentFromContext returns the request handled in ctx, which is passed to f of WithOngoingContext.
+// parentFromContext returns nil if ctx is not derived from WithOngoingContext.
+func parentFromContext(ctx context.Context) *message {
+	if ctx == nil {
+		return nil
+	}
+	parent, _ := ctx.Value(parentKey{}).(*message)
+	return parent
+}
+
 func (s *iopubSocket) WithOngoingContext(f func(ctx context.Context) error, parent *message) (err error) {
 	// We may want to call addOngoingContext in the goroutine for zmq loop.
 	// TODO: Reconsider this deeply.
@@ -86,7 +99,7 @@ func (s *iopubSocket) WithOngoingContext(f func(ctx context.Context) error, pare
 			err = ierr
 		}
 	}()
-	return f(ctxCancel.ctx)
+	return f(context.WithValue(ctxCancel.ctx, parentKey{}, parent))
 }
 
 func (s *iopubSocket) sendMessage(msg *message) error {
@@ -135,6 +148,34 @@ func (s *iopubSocket) sendStream(name, text string, parent *message) {
 	}
 }
 
+// sendError publishes an error message of an execute_request.
+func (s *iopubSocket) sendError(result *ExecuteResult, parent *message) {
+	var msg message
+	msg.Identity = [][]byte{[]byte("error")}
+	msg.Header.MsgType = "error"
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.MsgID = genMsgID()
+	msg.ParentHeader = parent.Header
+	traceback := result.Traceback
+	if traceback == nil {
+		// traceback is required in error messages.
+		traceback = []string{}
+	}
+	msg.Content = &struct {
+		Ename     string   `json:"ename"`
+		Evalue    string   `json:"evalue"`
+		Traceback []string `json:"traceback"`
+	}{
+		Ename:     result.Ename,
+		Evalue:    result.Evalue,
+		Traceback: traceback,
+	}
+	if err := s.sendMessage(&msg); err != nil {
+		glog.Errorf("Failed to send error: %v", err)
+	}
+}
+
 func (s *iopubSocket) sendDisplayData(data *DisplayData, parent *message, update bool) {
 	var msg message
 	msgType := "display_data"
@@ -176,9 +217,10 @@ type shellSocket struct {
 	cancelCtx func()
 
 	execQueue *executeQueue
+	commQueue *commQueue
 }
 
-func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string, cinfo *connectionInfo, iopub *iopubSocket, handlers RequestHandlers, cancelCtx func(), execQueue *executeQueue) (*shellSocket, error) {
+func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string, cinfo *connectionInfo, iopub *iopubSocket, handlers RequestHandlers, cancelCtx func(), execQueue *executeQueue, commQueue *commQueue) (*shellSocket, error) {
 	var routerAddr string
 	if name == "shell" {
 		routerAddr = cinfo.getAddr(cinfo.ShellPort)
@@ -221,6 +263,7 @@ func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string,
 		ctx:        serverCtx,
 		cancelCtx:  cancelCtx,
 		execQueue:  execQueue,
+		commQueue:  commQueue,
 	}, nil
 }
 
@@ -373,6 +416,22 @@ func (s *shellSocket) handleMessages() error {
 			res.Content = reply
 			s.pushResult(res)
 		}()
+	case "comm_open", "comm_msg", "comm_close":
+		s.commQueue.push(&msg)
+	case "comm_info_request":
+		go func() {
+			reply := s.handlers.HandleCommInfo(msg.Content.(*CommInfoRequest))
+			if reply == nil {
+				reply = &CommInfoReply{Status: "ok"}
+			}
+			if reply.Comms == nil {
+				reply.Comms = make(map[string]CommInfo)
+			}
+			res := newMessageWithParent(&msg)
+			res.Header.MsgType = "comm_info_reply"
+			res.Content = reply
+			s.pushResult(res)
+		}()
 	default:
 		glog.Warningf("Unsupported MsgType in %s: %q", s.name, typ)
 	}
@@ -0,0 +1,199 @@
+// This file implements the stdin channel, which is used to request raw input from users.
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-channel
+
+package gojupyterscaffold
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// stdinSocket sends input_request to the client and dispatches input_reply to the goroutine waiting for it.
+// Like shellSocket, messages are sent from the goroutine of loop via an inproc socket
+// because zmq sockets are not goroutine-safe.
+type stdinSocket struct {
+	hmacKey    []byte
+	socket     *zmq.Socket
+	reqPush    *zmq.Socket
+	reqPushMux sync.Mutex
+	reqPull    *zmq.Socket
+
+	// waiting stores channels to receive input_reply keyed by msg_id of input_request.
+	mu      sync.Mutex
+	waiting map[string]chan string
+}
+
+func newStdinSocket(zmqCtx *zmq.Context, cinfo *connectionInfo) (*stdinSocket, error) {
+	sock, err := zmqCtx.NewSocket(zmq.ROUTER)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
+	}
+	if err := sock.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
+		return nil, fmt.Errorf("Failed to bind stdin socket: %v", err)
+	}
+	reqPush, err := zmqCtx.NewSocket(zmq.PUSH)
+	if err != nil {
+		return nil, err
+	}
+	inprocAddr := "inproc://request-for-stdin-socket"
+	if err := reqPush.Bind(inprocAddr); err != nil {
+		return nil, err
+	}
+	reqPull, err := zmqCtx.NewSocket(zmq.PULL)
+	if err != nil {
+		return nil, err
+	}
+	if err := reqPull.Connect(inprocAddr); err != nil {
+		return nil, err
+	}
+	return &stdinSocket{
+		hmacKey: []byte(cinfo.Key),
+		socket:  sock,
+		reqPush: reqPush,
+		reqPull: reqPull,
+		waiting: make(map[string]chan string),
+	}, nil
+}
+
+func (s *stdinSocket) close() (err error) {
+	if cerr := s.socket.Close(); cerr != nil {
+		err = cerr
+	}
+	if cerr := s.reqPush.Close(); cerr != nil {
+		err = cerr
+	}
+	if cerr := s.reqPull.Close(); cerr != nil {
+		err = cerr
+	}
+	return
+}
+
+// notifyLoopEnd notifies the end of the loop to the goroutine in loop().
+func (s *stdinSocket) notifyLoopEnd() error {
+	s.reqPushMux.Lock()
+	defer s.reqPushMux.Unlock()
+	_, err := s.reqPush.SendMessage("END_OF_LOOP")
+	return err
+}
+
+// readInput sends input_request for parent (execute_request) and waits for input_reply.
+// readInput stops waiting when either reqCtx (the context of the request) or ctx (the context of the caller) is done.
+// This method is goroutine-safe.
+func (s *stdinSocket) readInput(reqCtx, ctx context.Context, parent *message, prompt string, password bool) (string, error) {
+	req := newMessageWithParent(parent)
+	req.Header.MsgType = "input_request"
+	req.Content = &InputRequest{
+		Prompt:   prompt,
+		Password: password,
+	}
+	ch := make(chan string, 1)
+	s.mu.Lock()
+	s.waiting[req.Header.MsgID] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.waiting, req.Header.MsgID)
+	}()
+
+	err := func() error {
+		s.reqPushMux.Lock()
+		defer s.reqPushMux.Unlock()
+		return req.Send(s.reqPush, s.hmacKey)
+	}()
+	if err != nil {
+		return "", fmt.Errorf("Failed to send input_request: %v", err)
+	}
+	select {
+	case value := <-ch:
+		return value, nil
+	case <-reqCtx.Done():
+		return "", reqCtx.Err()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *stdinSocket) loop() {
+	poller := zmq.NewPoller()
+	poller.Add(s.socket, zmq.POLLIN)
+	poller.Add(s.reqPull, zmq.POLLIN)
+loop:
+	for {
+		polled, err := poller.Poll(-1)
+		if isEINTR(err) {
+			glog.Info("zmq.Poll was interrupted")
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Poll on stdin socket failed: %v", err)
+			continue
+		}
+		for _, p := range polled {
+			switch p.Socket {
+			case s.socket:
+				if err := s.handleReply(); err != nil {
+					glog.Errorf("Failed to handle a message on stdin socket: %v", err)
+				}
+			case s.reqPull:
+				err := s.handleReqPull()
+				if err == errLoopEnd {
+					glog.Info("Exiting polling loop for stdin")
+					break loop
+				}
+				if err != nil {
+					glog.Errorf("Failed to send a message on stdin socket: %v", err)
+				}
+			default:
+				panic(errors.New("zmq.Poll returned an unexpected socket"))
+			}
+		}
+	}
+}
+
+func (s *stdinSocket) handleReply() error {
+	msgs, err := s.socket.RecvMessageBytes(0)
+	if err != nil {
+		return err
+	}
+	var msg message
+	if err := msg.Unmarshal(msgs, s.hmacKey); err != nil {
+		return err
+	}
+	reply, ok := msg.Content.(*InputReply)
+	if !ok {
+		return fmt.Errorf("Unexpected message on stdin socket: %q", msg.Header.MsgType)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.waiting[msg.ParentHeader.MsgID]
+	if ch == nil {
+		// The request was already canceled.
+		glog.Warningf("Received input_reply for an unknown input_request: %s", msg.ParentHeader.MsgID)
+		return nil
+	}
+	delete(s.waiting, msg.ParentHeader.MsgID)
+	ch <- reply.Value
+	return nil
+}
+
+// Forward a message on reqPull to socket.
+func (s *stdinSocket) handleReqPull() error {
+	msgs, err := s.reqPull.RecvMessageBytes(0)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 1 && string(msgs[0]) == "END_OF_LOOP" {
+		return errLoopEnd
+	}
+	var msg message
+	if err := msg.Unmarshal(msgs, s.hmacKey); err != nil {
+		return err
+	}
+	return msg.Send(s.socket, s.hmacKey)
+}
@@ -0,0 +1,257 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+)
+
+// Parameters of the layout of call graphs in pixels.
+const (
+	graphCharWidth  = 7
+	graphNodeHeight = 52
+	graphRowGap     = 60
+	graphColGap     = 24
+	graphMargin     = 16
+	graphLineHeight = 15
+)
+
+// minNodeFraction is the minimum fraction of the total value of nodes shown in call graphs.
+const minNodeFraction = 0.005
+
+type graphNode struct {
+	entry *Entry
+	rank  int
+	order float64
+	// x and y are the position of the top-left corner of the node. w is the width of the node.
+	x, y, w float64
+}
+
+type graphEdge struct {
+	from, to *graphNode
+	weight   int64
+	// back is true if the edge makes a cycle. Back edges are ignored when nodes are ranked.
+	back bool
+}
+
+// callGraph is a call graph of functions with the layout information.
+type callGraph struct {
+	nodes         []*graphNode
+	edges         []*graphEdge
+	width, height float64
+}
+
+// newCallGraph builds a call graph of at most maxNodes functions with the largest cumulative values.
+func newCallGraph(p *Profile, index, maxNodes int) *callGraph {
+	total := p.Total(index)
+	byFunc := make(map[string]*graphNode)
+	var nodes []*graphNode
+	for _, e := range p.Top(index, 0) {
+		if float64(e.Cum) < float64(total)*minNodeFraction {
+			continue
+		}
+		nodes = append(nodes, &graphNode{entry: e})
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].entry.Cum > nodes[j].entry.Cum })
+	if maxNodes > 0 && len(nodes) > maxNodes {
+		nodes = nodes[:maxNodes]
+	}
+	for _, n := range nodes {
+		byFunc[n.entry.Func] = n
+	}
+
+	type edgeKey struct{ from, to *graphNode }
+	edges := make(map[edgeKey]*graphEdge)
+	var edgeList []*graphEdge
+	for _, s := range p.Samples {
+		v := s.Values[index]
+		if v == 0 {
+			continue
+		}
+		seen := make(map[edgeKey]bool)
+		for i := 0; i+1 < len(s.Stack); i++ {
+			from, to := byFunc[s.Stack[i+1].Func], byFunc[s.Stack[i].Func]
+			if from == nil || to == nil || from == to {
+				continue
+			}
+			key := edgeKey{from, to}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			e := edges[key]
+			if e == nil {
+				e = &graphEdge{from: from, to: to}
+				edges[key] = e
+				edgeList = append(edgeList, e)
+			}
+			e.weight += v
+		}
+	}
+	sort.SliceStable(edgeList, func(i, j int) bool { return edgeList[i].weight > edgeList[j].weight })
+	g := &callGraph{nodes: nodes, edges: edgeList}
+	g.layout()
+	return g
+}
+
+// markBackEdges marks edges that make cycles with depth-first search from nodes with larger values.
+func (g *callGraph) markBackEdges() {
+	out := make(map[*graphNode][]*graphEdge)
+	for _, e := range g.edges {
+		out[e.from] = append(out[e.from], e)
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*graphNode]int)
+	var visit func(n *graphNode)
+	visit = func(n *graphNode) {
+		state[n] = visiting
+		for _, e := range out[n] {
+			switch state[e.to] {
+			case visiting:
+				e.back = true
+			case unvisited:
+				visit(e.to)
+			}
+		}
+		state[n] = visited
+	}
+	for _, n := range g.nodes {
+		if state[n] == unvisited {
+			visit(n)
+		}
+	}
+}
+
+// layout assigns positions to nodes. Nodes are placed in rows by the longest path from roots
+// and ordered in each row by the average positions of their callers.
+func (g *callGraph) layout() {
+	g.markBackEdges()
+	// Compute ranks by relaxation. This terminates because the graph without back edges is a DAG.
+	for changed := true; changed; {
+		changed = false
+		for _, e := range g.edges {
+			if !e.back && e.to.rank < e.from.rank+1 {
+				e.to.rank = e.from.rank + 1
+				changed = true
+			}
+		}
+	}
+	rows := make(map[int][]*graphNode)
+	maxRank := 0
+	for _, n := range g.nodes {
+		rows[n.rank] = append(rows[n.rank], n)
+		if n.rank > maxRank {
+			maxRank = n.rank
+		}
+	}
+	for rank := 0; rank <= maxRank; rank++ {
+		row := rows[rank]
+		for i, n := range row {
+			n.order = float64(i)
+			var sum float64
+			var count int
+			for _, e := range g.edges {
+				if e.to == n && !e.back && e.from.rank < rank {
+					sum += e.from.x + e.from.w/2
+					count++
+				}
+			}
+			if count > 0 {
+				n.order = sum / float64(count)
+			}
+		}
+		sort.SliceStable(row, func(i, j int) bool { return row[i].order < row[j].order })
+		x := float64(graphMargin)
+		for _, n := range row {
+			n.w = float64(graphCharWidth*maxLabelLen(n.entry)) + 16
+			n.x, n.y = x, float64(graphMargin+rank*(graphNodeHeight+graphRowGap))
+			x += n.w + graphColGap
+		}
+		if w := x - graphColGap + graphMargin; w > g.width {
+			g.width = w
+		}
+	}
+	// Center rows.
+	for rank := 0; rank <= maxRank; rank++ {
+		row := rows[rank]
+		if len(row) == 0 {
+			continue
+		}
+		last := row[len(row)-1]
+		shift := (g.width - (last.x + last.w + graphMargin)) / 2
+		for _, n := range row {
+			n.x += shift
+		}
+	}
+	g.height = float64(2*graphMargin + (maxRank+1)*graphNodeHeight + maxRank*graphRowGap)
+}
+
+func nodeLabel(e *Entry) string {
+	if loc := e.location(); loc != "" {
+		return e.Func + " (" + loc + ")"
+	}
+	return e.Func
+}
+
+func maxLabelLen(e *Entry) int {
+	n := len(nodeLabel(e))
+	// The minimum width for lines of values (e.g. "flat 1.23ms (12.3%)").
+	if n < 24 {
+		n = 24
+	}
+	return n
+}
+
+// CallGraphSVG renders the call graph of functions in p as SVG.
+// The graph shows at most maxNodes functions with the largest cumulative values at index.
+func CallGraphSVG(p *Profile, index int, unit string, maxNodes int) string {
+	g := newCallGraph(p, index, maxNodes)
+	total := p.Total(index)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="monospace" font-size="12">`, g.width, g.height)
+	buf.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0L10,5L0,10z" fill="#555"/></marker></defs>`)
+	for _, e := range g.edges {
+		x1, y1 := e.from.x+e.from.w/2, e.from.y+graphNodeHeight
+		x2, y2 := e.to.x+e.to.w/2, e.to.y
+		if e.back || e.to.rank <= e.from.rank {
+			// Draw edges to upper rows from the right side of nodes.
+			x1, y1 = e.from.x+e.from.w, e.from.y+graphNodeHeight/2
+			x2, y2 = e.to.x+e.to.w, e.to.y+graphNodeHeight/2
+		}
+		width := 1.0
+		if total > 0 {
+			width += 4 * float64(e.weight) / float64(total)
+		}
+		my := (y1 + y2) / 2
+		fmt.Fprintf(&buf, `<path d="M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="none" stroke="#555" stroke-width="%.1f" marker-end="url(#arrow)"/>`,
+			x1, y1, x1, my, x2, my, x2, y2, width)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" fill="#333">%s</text>`, (x1+x2)/2+4, my, html.EscapeString(FormatValue(e.weight, unit)))
+	}
+	for _, n := range g.nodes {
+		// The more flat value a node has, the redder it is.
+		var ratio float64
+		if total > 0 {
+			ratio = float64(n.entry.Flat) / float64(total)
+		}
+		fill := fmt.Sprintf("hsl(0,%.0f%%,%.0f%%)", 20+80*ratio, 95-40*ratio)
+		fmt.Fprintf(&buf, `<g><title>%s</title>`, html.EscapeString(n.entry.Func))
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%d" rx="4" fill="%s" stroke="#333"/>`, n.x, n.y, n.w, graphNodeHeight, fill)
+		lines := []string{
+			nodeLabel(n.entry),
+			fmt.Sprintf("flat %s (%s)", FormatValue(n.entry.Flat, unit), percent(n.entry.Flat, total)),
+			fmt.Sprintf("cum %s (%s)", FormatValue(n.entry.Cum, unit), percent(n.entry.Cum, total)),
+		}
+		for i, l := range lines {
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`,
+				n.x+n.w/2, n.y+float64((i+1)*graphLineHeight), html.EscapeString(l))
+		}
+		buf.WriteString("</g>")
+	}
+	buf.WriteString("</svg>")
+	return buf.String()
+}
@@ -0,0 +1,241 @@
+// Package profile analyzes profiles written by runtime/pprof and renders them as tables and call graphs.
+// lgo uses this package to show profiles of executions in notebooks without external tools like pprof and dot.
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// ValueType describes the type of values in samples (e.g. "cpu" and "nanoseconds").
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// Frame is a function call in a stack.
+type Frame struct {
+	// Func is the name of the function including its package path.
+	Func string
+	File string
+	Line int
+	// StartLine is the line where the function starts.
+	StartLine int
+}
+
+// Sample is a stack with measured values.
+type Sample struct {
+	// Stack is the list of frames from the leaf (innermost) to the root.
+	Stack  []Frame
+	Values []int64
+}
+
+// Profile is a set of samples.
+type Profile struct {
+	SampleTypes []ValueType
+	Samples     []*Sample
+}
+
+// ValueIndex returns the index of values of the sample type typ. It returns -1 if typ is not found.
+func (p *Profile) ValueIndex(typ string) int {
+	for i, vt := range p.SampleTypes {
+		if vt.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+func stackKey(stack []Frame) string {
+	var b bytes.Buffer
+	for _, f := range stack {
+		fmt.Fprintf(&b, "%s:%s:%d\n", f.Func, f.File, f.Line)
+	}
+	return b.String()
+}
+
+// Sub returns a profile whose values are the differences of values in p from base.
+// This is used to extract samples recorded in a period from cumulative profiles (e.g. allocs and block profiles).
+// Samples whose values are not positive are dropped.
+func (p *Profile) Sub(base *Profile) *Profile {
+	baseValues := make(map[string][]int64)
+	for _, s := range base.Samples {
+		key := stackKey(s.Stack)
+		baseValues[key] = addValues(baseValues[key], s.Values)
+	}
+	merged := make(map[string]*Sample)
+	var keys []string
+	for _, s := range p.Samples {
+		key := stackKey(s.Stack)
+		if m := merged[key]; m != nil {
+			m.Values = addValues(m.Values, s.Values)
+			continue
+		}
+		merged[key] = &Sample{Stack: s.Stack, Values: addValues(nil, s.Values)}
+		keys = append(keys, key)
+	}
+	diff := &Profile{SampleTypes: p.SampleTypes}
+	for _, key := range keys {
+		s := merged[key]
+		positive := false
+		for i, b := range baseValues[key] {
+			if i < len(s.Values) {
+				s.Values[i] -= b
+			}
+		}
+		for _, v := range s.Values {
+			if v > 0 {
+				positive = true
+			}
+		}
+		if positive {
+			diff.Samples = append(diff.Samples, s)
+		}
+	}
+	return diff
+}
+
+func addValues(dst, src []int64) []int64 {
+	for len(dst) < len(src) {
+		dst = append(dst, 0)
+	}
+	for i, v := range src {
+		dst[i] += v
+	}
+	return dst
+}
+
+// Focus returns a profile that keeps only samples with frames for which keep returns true.
+// Frames closer to the root than the outermost kept frame are removed from stacks.
+func (p *Profile) Focus(keep func(f Frame) bool) *Profile {
+	focused := &Profile{SampleTypes: p.SampleTypes}
+	for _, s := range p.Samples {
+		root := -1
+		for i, f := range s.Stack {
+			if keep(f) {
+				root = i
+			}
+		}
+		if root < 0 {
+			continue
+		}
+		focused.Samples = append(focused.Samples, &Sample{Stack: s.Stack[:root+1], Values: s.Values})
+	}
+	return focused
+}
+
+// MapFrames returns a profile whose frames are replaced with the results of fn.
+func (p *Profile) MapFrames(fn func(f Frame) Frame) *Profile {
+	mapped := &Profile{SampleTypes: p.SampleTypes}
+	for _, s := range p.Samples {
+		stack := make([]Frame, len(s.Stack))
+		for i, f := range s.Stack {
+			stack[i] = fn(f)
+		}
+		mapped.Samples = append(mapped.Samples, &Sample{Stack: stack, Values: s.Values})
+	}
+	return mapped
+}
+
+// Total returns the sum of values at index.
+func (p *Profile) Total(index int) int64 {
+	var total int64
+	for _, s := range p.Samples {
+		total += s.Values[index]
+	}
+	return total
+}
+
+// Entry is the aggregated value of a function.
+type Entry struct {
+	Func string
+	// File and StartLine are the location of the function.
+	File      string
+	StartLine int
+	// Flat is the value measured in the function itself. Cum is the value including its callees.
+	Flat, Cum int64
+}
+
+// Top returns entries of functions sorted by Flat values in descending order.
+// If n is positive, Top returns at most n entries.
+func (p *Profile) Top(index, n int) []*Entry {
+	entries := make(map[string]*Entry)
+	get := func(f Frame) *Entry {
+		e := entries[f.Func]
+		if e == nil {
+			e = &Entry{Func: f.Func, File: f.File, StartLine: f.StartLine}
+			entries[f.Func] = e
+		}
+		return e
+	}
+	for _, s := range p.Samples {
+		v := s.Values[index]
+		if len(s.Stack) == 0 || v == 0 {
+			continue
+		}
+		get(s.Stack[0]).Flat += v
+		// Recursive functions appear multiple times in a stack. Count them only once.
+		seen := make(map[string]bool)
+		for _, f := range s.Stack {
+			if seen[f.Func] {
+				continue
+			}
+			seen[f.Func] = true
+			get(f).Cum += v
+		}
+	}
+	var list []*Entry
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Flat != list[j].Flat {
+			return list[i].Flat > list[j].Flat
+		}
+		if list[i].Cum != list[j].Cum {
+			return list[i].Cum > list[j].Cum
+		}
+		return list[i].Func < list[j].Func
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// FormatValue formats v in unit in a human readable way.
+func FormatValue(v int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		return formatDuration(v)
+	case "bytes":
+		return formatBytes(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatDuration(ns int64) string {
+	switch {
+	case ns >= 1e9:
+		return fmt.Sprintf("%.2fs", float64(ns)/1e9)
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2fms", float64(ns)/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2fµs", float64(ns)/1e3)
+	}
+	return fmt.Sprintf("%dns", ns)
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
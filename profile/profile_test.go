@@ -0,0 +1,168 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+var allocSink [][]byte
+
+//go:noinline
+func allocateForTest() {
+	for i := 0; i < 100; i++ {
+		allocSink = append(allocSink, make([]byte, 1024))
+	}
+}
+
+func TestParse(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	allocateForTest()
+	runtime.GC()
+	runtime.GC()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	allocSink = nil
+	p, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := p.ValueIndex("alloc_space")
+	if index < 0 {
+		t.Fatalf("alloc_space is not found in %v", p.SampleTypes)
+	}
+	if unit := p.SampleTypes[index].Unit; unit != "bytes" {
+		t.Errorf("Got %q; want bytes", unit)
+	}
+	var found *Entry
+	for _, e := range p.Top(index, 0) {
+		if strings.HasSuffix(e.Func, ".allocateForTest") {
+			found = e
+		}
+	}
+	if found == nil {
+		t.Fatal("allocateForTest is not found in the profile")
+	}
+	if found.Flat < 100*1024 {
+		t.Errorf("Got %d bytes; want >= %d", found.Flat, 100*1024)
+	}
+	if !strings.HasSuffix(found.File, "profile_test.go") || found.StartLine == 0 {
+		t.Errorf("Unexpected location: %s:%d", found.File, found.StartLine)
+	}
+}
+
+func frames(names ...string) []Frame {
+	var fs []Frame
+	for _, n := range names {
+		fs = append(fs, Frame{Func: n})
+	}
+	return fs
+}
+
+func testProfile() *Profile {
+	return &Profile{
+		SampleTypes: []ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Samples: []*Sample{
+			{Stack: frames("c", "b", "a", "main"), Values: []int64{30}},
+			{Stack: frames("b", "a", "main"), Values: []int64{20}},
+			{Stack: frames("b", "b", "main"), Values: []int64{10}},
+			{Stack: frames("gc"), Values: []int64{40}},
+		},
+	}
+}
+
+func TestTop(t *testing.T) {
+	var got []Entry
+	for _, e := range testProfile().Top(0, 3) {
+		got = append(got, *e)
+	}
+	want := []Entry{
+		{Func: "gc", Flat: 40, Cum: 40},
+		{Func: "b", Flat: 30, Cum: 60},
+		{Func: "c", Flat: 30, Cum: 30},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v; want %+v", got, want)
+	}
+}
+
+func TestSub(t *testing.T) {
+	base := testProfile()
+	p := testProfile()
+	p.Samples[0].Values[0] += 5
+	p.Samples = append(p.Samples, &Sample{Stack: frames("d", "main"), Values: []int64{7}})
+	diff := p.Sub(base)
+	if len(diff.Samples) != 2 {
+		t.Fatalf("Got %d samples; want 2", len(diff.Samples))
+	}
+	if got := diff.Samples[0]; got.Stack[0].Func != "c" || got.Values[0] != 5 {
+		t.Errorf("Unexpected sample: %+v", got)
+	}
+	if got := diff.Samples[1]; got.Stack[0].Func != "d" || got.Values[0] != 7 {
+		t.Errorf("Unexpected sample: %+v", got)
+	}
+}
+
+func TestFocus(t *testing.T) {
+	p := testProfile().Focus(func(f Frame) bool { return f.Func == "a" || f.Func == "c" })
+	var got [][]Frame
+	for _, s := range p.Samples {
+		got = append(got, s.Stack)
+	}
+	want := [][]Frame{frames("c", "b", "a"), frames("b", "a")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v; want %v", got, want)
+	}
+}
+
+func TestFormatTop(t *testing.T) {
+	p := testProfile()
+	got := FormatTop(p.Top(0, 2), p.Total(0), "nanoseconds")
+	want := "  flat  flat%   cum   cum%  function\n" +
+		"  40ns  40.0%  40ns  40.0%  gc\n" +
+		"  30ns  30.0%  60ns  60.0%  b\n"
+	if got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+	html := TopHTML(p.Top(0, 1), p.Total(0), "nanoseconds")
+	if !strings.Contains(html, "<td>40ns</td>") {
+		t.Errorf("Unexpected HTML: %s", html)
+	}
+}
+
+func TestCallGraphSVG(t *testing.T) {
+	p := testProfile()
+	g := newCallGraph(p, 0, 0)
+	ranks := make(map[string]int)
+	for _, n := range g.nodes {
+		ranks[n.entry.Func] = n.rank
+	}
+	if want := map[string]int{"main": 0, "a": 1, "b": 2, "c": 3, "gc": 0}; !reflect.DeepEqual(ranks, want) {
+		t.Errorf("Got ranks %v; want %v", ranks, want)
+	}
+	svg := CallGraphSVG(p, 0, "nanoseconds", 0)
+	// Check the output is well-formed XML.
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid SVG: %v\n%s", err, svg)
+		}
+	}
+	for _, want := range []string{"<svg", ">main<", ">gc<", "flat 30ns (30.0%)"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("%q is not found in %s", want, svg)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// This file implements a minimal decoder of profile.proto, which is the format written by runtime/pprof.
+// Only fields necessary to build Profile are decoded.
+// c.f. https://github.com/google/pprof/blob/master/proto/profile.proto
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// protoBuffer reads fields of a protobuf message.
+type protoBuffer struct {
+	data []byte
+	// field and wireType are the field number and the wire type of the last field read by next.
+	field    int
+	wireType int
+	// u64 is the value of the last varint or fixed field. bytes is the value of the last length-delimited field.
+	u64   uint64
+	bytes []byte
+}
+
+func (b *protoBuffer) varint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(b.data) == 0 {
+			return 0, errTruncated
+		}
+		c := b.data[0]
+		b.data = b.data[1:]
+		x |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, errors.New("too long varint")
+}
+
+// next reads the next field. next returns false when it reaches the end of the message.
+func (b *protoBuffer) next() (bool, error) {
+	if len(b.data) == 0 {
+		return false, nil
+	}
+	key, err := b.varint()
+	if err != nil {
+		return false, err
+	}
+	b.field, b.wireType = int(key>>3), int(key&7)
+	switch b.wireType {
+	case 0:
+		b.u64, err = b.varint()
+		return err == nil, err
+	case 1:
+		if len(b.data) < 8 {
+			return false, errTruncated
+		}
+		b.u64 = 0
+		for i := 7; i >= 0; i-- {
+			b.u64 = b.u64<<8 | uint64(b.data[i])
+		}
+		b.data = b.data[8:]
+	case 2:
+		n, err := b.varint()
+		if err != nil {
+			return false, err
+		}
+		if uint64(len(b.data)) < n {
+			return false, errTruncated
+		}
+		b.bytes, b.data = b.data[:n], b.data[n:]
+	case 5:
+		if len(b.data) < 4 {
+			return false, errTruncated
+		}
+		b.u64 = uint64(b.data[0]) | uint64(b.data[1])<<8 | uint64(b.data[2])<<16 | uint64(b.data[3])<<24
+		b.data = b.data[4:]
+	default:
+		return false, fmt.Errorf("unsupported wire type: %d", b.wireType)
+	}
+	return true, nil
+}
+
+// uint64s appends the value of the current repeated integer field to s. It handles both packed and unpacked fields.
+func (b *protoBuffer) uint64s(s []uint64) ([]uint64, error) {
+	if b.wireType != 2 {
+		return append(s, b.u64), nil
+	}
+	packed := &protoBuffer{data: b.bytes}
+	for len(packed.data) > 0 {
+		x, err := packed.varint()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, x)
+	}
+	return s, nil
+}
+
+// decodeMessage calls fn for each field of the message in data.
+func decodeMessage(data []byte, fn func(b *protoBuffer) error) error {
+	b := &protoBuffer{data: data}
+	for {
+		ok, err := b.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+}
+
+type rawSample struct {
+	locations []uint64
+	values    []uint64
+}
+
+type rawLine struct {
+	function uint64
+	line     int64
+}
+
+type rawFunction struct {
+	name, filename int64
+	startLine      int64
+}
+
+// rawProfile keeps a decoded profile.proto before string and id references are resolved.
+type rawProfile struct {
+	sampleTypes [][2]int64
+	samples     []rawSample
+	locations   map[uint64][]rawLine
+	functions   map[uint64]rawFunction
+	strings     []string
+}
+
+func decodeRawProfile(data []byte) (*rawProfile, error) {
+	p := &rawProfile{
+		locations: make(map[uint64][]rawLine),
+		functions: make(map[uint64]rawFunction),
+	}
+	err := decodeMessage(data, func(b *protoBuffer) error {
+		switch b.field {
+		case 1: // sample_type
+			var vt [2]int64
+			err := decodeMessage(b.bytes, func(b *protoBuffer) error {
+				if b.field == 1 || b.field == 2 {
+					vt[b.field-1] = int64(b.u64)
+				}
+				return nil
+			})
+			p.sampleTypes = append(p.sampleTypes, vt)
+			return err
+		case 2: // sample
+			var s rawSample
+			err := decodeMessage(b.bytes, func(b *protoBuffer) (err error) {
+				switch b.field {
+				case 1:
+					s.locations, err = b.uint64s(s.locations)
+				case 2:
+					s.values, err = b.uint64s(s.values)
+				}
+				return err
+			})
+			p.samples = append(p.samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var lines []rawLine
+			err := decodeMessage(b.bytes, func(b *protoBuffer) error {
+				switch b.field {
+				case 1:
+					id = b.u64
+				case 4:
+					var l rawLine
+					err := decodeMessage(b.bytes, func(b *protoBuffer) error {
+						switch b.field {
+						case 1:
+							l.function = b.u64
+						case 2:
+							l.line = int64(b.u64)
+						}
+						return nil
+					})
+					lines = append(lines, l)
+					return err
+				}
+				return nil
+			})
+			p.locations[id] = lines
+			return err
+		case 5: // function
+			var id uint64
+			var f rawFunction
+			err := decodeMessage(b.bytes, func(b *protoBuffer) error {
+				switch b.field {
+				case 1:
+					id = b.u64
+				case 2:
+					f.name = int64(b.u64)
+				case 4:
+					f.filename = int64(b.u64)
+				case 5:
+					f.startLine = int64(b.u64)
+				}
+				return nil
+			})
+			p.functions[id] = f
+			return err
+		case 6: // string_table
+			p.strings = append(p.strings, string(b.bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *rawProfile) str(i int64) string {
+	if i < 0 || i >= int64(len(p.strings)) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// Parse parses a profile written by runtime/pprof. data may be compressed with gzip.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := decodeRawProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode a profile: %v", err)
+	}
+	prof := &Profile{}
+	for _, vt := range raw.sampleTypes {
+		prof.SampleTypes = append(prof.SampleTypes, ValueType{Type: raw.str(vt[0]), Unit: raw.str(vt[1])})
+	}
+	for _, s := range raw.samples {
+		sample := &Sample{}
+		for _, v := range s.values {
+			sample.Values = append(sample.Values, int64(v))
+		}
+		for _, id := range s.locations {
+			// Lines of a location are ordered from the inlined callee to the caller.
+			for _, l := range raw.locations[id] {
+				f := raw.functions[l.function]
+				sample.Stack = append(sample.Stack, Frame{
+					Func:      raw.str(f.name),
+					File:      raw.str(f.filename),
+					Line:      int(l.line),
+					StartLine: int(f.startLine),
+				})
+			}
+		}
+		prof.Samples = append(prof.Samples, sample)
+	}
+	return prof, nil
+}
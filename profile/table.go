@@ -0,0 +1,59 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// location returns the location of the function of e in "file:line" format.
+func (e *Entry) location() string {
+	if e.File == "" {
+		return ""
+	}
+	if e.StartLine <= 0 {
+		return filepath.Base(e.File)
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(e.File), e.StartLine)
+}
+
+func percent(v, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(v)*100/float64(total))
+}
+
+// FormatTop formats entries returned from Top as a text table. total is the total value of the profile.
+func FormatTop(entries []*Entry, total int64, unit string) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "flat\tflat%\tcum\tcum%\t  function")
+	for _, e := range entries {
+		fn := e.Func
+		if loc := e.location(); loc != "" {
+			fn += " " + loc
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t  %s\n",
+			FormatValue(e.Flat, unit), percent(e.Flat, total),
+			FormatValue(e.Cum, unit), percent(e.Cum, total), fn)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// TopHTML formats entries returned from Top as an HTML table. total is the total value of the profile.
+func TopHTML(entries []*Entry, total int64, unit string) string {
+	var buf bytes.Buffer
+	buf.WriteString(`<table><thead><tr><th>flat</th><th>flat%</th><th>cum</th><th>cum%</th><th>function</th><th>location</th></tr></thead><tbody>`)
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td style=\"text-align:left\">%s</td><td style=\"text-align:left\">%s</td></tr>",
+			FormatValue(e.Flat, unit), percent(e.Flat, total),
+			FormatValue(e.Cum, unit), percent(e.Cum, total),
+			html.EscapeString(e.Func), html.EscapeString(e.location()))
+	}
+	buf.WriteString("</tbody></table>")
+	return buf.String()
+}